@@ -0,0 +1,145 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// defaultACMECache is where --acme keeps its account key and certificates
+// without --acmecache, under the user's cache directory.
+const defaultACMECache = "websocketd/acme"
+
+// newACMEManager returns the certificate manager for --acme. It obtains a
+// certificate for each of the domains on first use, answering the CA's
+// TLS-ALPN-01 challenge on the TLS listener and, with --redirport, its
+// HTTP-01 challenge on the redirect server; it renews them in the background
+// well before they expire. Certificates and the account key are kept in the
+// cache directory, so a restart does not ask the CA again.
+func newACMEManager(config *Config) (*autocert.Manager, error) {
+	cacheDir := config.ACMECache
+	if cacheDir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("no --acmecache given and %s", err)
+		}
+		cacheDir = filepath.Join(userCache, defaultACMECache)
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("ACME cache: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.ACMECA != "" {
+		// For a private or test CA (such as Pebble) whose own HTTPS
+		// certificate is not in the system pool.
+		pool, err := loadCAPool(config.ACMECA)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	client := &acme.Client{
+		DirectoryURL: config.ACMEDirectory,
+		HTTPClient:   &http.Client{Transport: &orderLocations{next: transport}, Timeout: time.Minute},
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(config.ACMEDomains...),
+		Email:      config.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// orderLocations fills in the Location header of a finalize response that
+// lacks one. The acme client polls that URL for the certificate when the CA
+// is still issuing it, but RFC 8555 does not require the header and CAs that
+// issue asynchronously (Pebble, for one) leave it out. The order's URL is
+// known from when it was created or fetched, keyed by its finalize URL.
+type orderLocations struct {
+	next http.RoundTripper
+
+	mu         sync.Mutex
+	byFinalize map[string]string
+}
+
+func (o *orderLocations) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := o.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost || res.StatusCode/100 != 2 ||
+		!strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		return res, err
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	var order struct{ Finalize string }
+	if json.Unmarshal(body, &order) != nil || order.Finalize == "" {
+		return res, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if loc := res.Header.Get("Location"); loc != "" {
+		if o.byFinalize == nil {
+			o.byFinalize = make(map[string]string)
+		}
+		o.byFinalize[order.Finalize] = loc
+	} else if loc, ok := o.byFinalize[req.URL.String()]; ok {
+		res.Header.Set("Location", loc)
+		delete(o.byFinalize, req.URL.String())
+	}
+	return res, nil
+}
+
+// offersACMEALPN reports whether a TLS client is the CA validating a
+// TLS-ALPN-01 challenge, which never presents a client certificate.
+func offersACMEALPN(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// validateACME checks the --acme flags. The certificate comes from the CA,
+// so --sslcert and --sslkey have no place beside it.
+func validateACME(domains, certFile, keyFile, directory string) error {
+	if domains == "" {
+		return nil
+	}
+	if certFile != "" || keyFile != "" {
+		return fmt.Errorf("--acme gets its certificate from the CA; drop --sslcert and --sslkey")
+	}
+	for _, d := range strings.Split(domains, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" || strings.ContainsAny(d, ":/ ") {
+			return fmt.Errorf("--acme: %q is not a domain name", d)
+		}
+	}
+	if !strings.HasPrefix(directory, "https://") && !strings.HasPrefix(directory, "http://") {
+		return fmt.Errorf("--acmedirectory must be a URL, not %q", directory)
+	}
+	return nil
+}
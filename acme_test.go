@@ -0,0 +1,54 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type fakeCA map[string]*http.Response
+
+func (ca fakeCA) RoundTrip(req *http.Request) (*http.Response, error) {
+	return ca[req.URL.String()], nil
+}
+
+func jsonResponse(body, location string) *http.Response {
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+	res.Header.Set("Content-Type", "application/json")
+	if location != "" {
+		res.Header.Set("Location", location)
+	}
+	return res
+}
+
+// TestOrderLocations checks that a finalize response without a Location
+// header gets the order's URL, as Pebble's does not carry one.
+func TestOrderLocations(t *testing.T) {
+	const order = `{"status":"processing","finalize":"https://ca/finalize/1"}`
+	rt := &orderLocations{next: fakeCA{
+		"https://ca/new-order":  jsonResponse(order, "https://ca/order/1"),
+		"https://ca/finalize/1": jsonResponse(order, ""),
+	}}
+	post := func(url string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		res, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(res.Body); string(body) != order {
+			t.Errorf("body = %q, want it passed through", body)
+		}
+		return res
+	}
+
+	post("https://ca/new-order")
+	if got := post("https://ca/finalize/1").Header.Get("Location"); got != "https://ca/order/1" {
+		t.Errorf("finalize Location = %q, want the order URL", got)
+	}
+}
@@ -6,16 +6,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/joewalnes/websocketd/libwebsocketd"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // defaultMaxForks is a finite runaway backstop, not a capacity plan. Each fork
@@ -30,8 +35,26 @@ type Config struct {
 	UnixSocket        string   // Path of a Unix domain socket to listen on, in addition to (or instead of) Addr
 	MaxForks          int      // Number of allowable concurrent forks
 	LogLevel          libwebsocketd.LogLevel
+	LogFormat         string // One of the keys of logFormats
+	LogFile           string // Write the log here instead of stdout
+	AccessLog         string // Write access-level lines here instead of the log
+	LogMaxSize        int64  // Rotate log files before they pass this many bytes (0 never)
+	LogRotate         string // One of the keys of logRotations
+	LogBackups        int    // Rotated log files to keep (0 keeps all)
 	RedirPort         int
 	CertFile, KeyFile string
+	ACMEDomains       []string            // Get certificates for these from an ACME CA (--acme), instead of CertFile and KeyFile
+	ACMEEmail         string              // Contact address for the ACME account
+	ACMECache         string              // Directory for the ACME account key and certificates
+	ACMEDirectory     string              // ACME directory URL of the CA
+	ACMECA            string              // CA certificates to trust for the ACME directory's HTTPS, if not the system's
+	DrainTimeout      time.Duration       // How long a SIGINT/SIGTERM shutdown waits for sessions to end
+	GoingAway         bool                // Send clients a 1001 close frame when shutting down
+	MetricsAddr       string              // Address of the Prometheus metrics listener, if any
+	ProxyProtocol     bool                // Read a PROXY protocol header from trusted proxies on TCP listeners
+	OptionalCert      bool                // With --sslca, let clients without a certificate through (--sslclientauth=optional)
+	ConfigFile        string              // --config file, re-read on SIGHUP
+	GivenFlags        map[string][]string // Flags set on the command line, which a reload must not override
 	*libwebsocketd.Config
 }
 
@@ -121,17 +144,63 @@ func validateSSL(ssl bool, certFile, keyFile string) error {
 	return nil
 }
 
-// validateBinaryPassStderr checks that --binary and --passstderr aren't both
-// set. Tagging binary chunks as JSON isn't implemented (--passstderr always
-// reads line by line), so combining the two would silently discard --binary
-// instead of behaving as either flag alone.
-func validateBinaryPassStderr(binary, passStderr bool) error {
-	if binary && passStderr {
-		return fmt.Errorf("please only specify one of --binary and --passstderr")
+// validateClientCerts checks --sslclientauth and --certrule, which only
+// mean something when client certificates are verified (--ssl --sslca).
+func validateClientCerts(ssl bool, caFile, clientAuth string, rules int) error {
+	if clientAuth != "require" && clientAuth != "optional" {
+		return fmt.Errorf("--sslclientauth must be require or optional, not %q", clientAuth)
+	}
+	if (clientAuth == "optional" || rules > 0) && (!ssl || caFile == "") {
+		return fmt.Errorf("--sslclientauth=optional and --certrule need --ssl and --sslca")
 	}
 	return nil
 }
 
+// validateStreamByte checks that --streambyte has a binary session with
+// --passstderr to apply to, server-wide or in a route.
+func validateStreamByte(streamByte, binary, passStderr bool, routes []libwebsocketd.Route) error {
+	if !streamByte || (binary && passStderr) {
+		return nil
+	}
+	for _, r := range routes {
+		b, p := binary, passStderr
+		if r.Binary != nil {
+			b = *r.Binary
+		}
+		if r.PassStderr != nil {
+			p = *r.PassStderr
+		}
+		if b && p {
+			return nil
+		}
+	}
+	return fmt.Errorf("--streambyte needs --binary and --passstderr")
+}
+
+// validateEnvelope checks --envelope, and that it and --rawstdout have a
+// text session with --passstderr to apply to, server-wide or in a route.
+func validateEnvelope(envelope string, rawStdout, binary, passStderr bool, routes []libwebsocketd.Route) error {
+	if envelope != "basic" && envelope != "extended" {
+		return fmt.Errorf("--envelope must be basic or extended, not %q", envelope)
+	}
+	if (envelope == "basic" && !rawStdout) || (!binary && passStderr) {
+		return nil
+	}
+	for _, r := range routes {
+		b, p := binary, passStderr
+		if r.Binary != nil {
+			b = *r.Binary
+		}
+		if r.PassStderr != nil {
+			p = *r.PassStderr
+		}
+		if !b && p {
+			return nil
+		}
+	}
+	return fmt.Errorf("--envelope=extended and --rawstdout need --passstderr without --binary")
+}
+
 // buildParentEnv constructs the filtered parent environment variable list.
 func buildParentEnv(passenv string) []string {
 	env := make([]string, 0)
@@ -196,6 +265,529 @@ func validateDir(dir, label string) error {
 	return nil
 }
 
+// validateLogFiles checks the log destination and rotation flags. Rotation
+// only applies to files, so the rotation flags need --logfile or --accesslog.
+func validateLogFiles(logFile, accessLog string, maxSizeMB int, rotate string, backups int) error {
+	if _, ok := logRotations[rotate]; !ok {
+		return fmt.Errorf("--logrotate must be hourly or daily, not %q", rotate)
+	}
+	if maxSizeMB < 0 || backups < 0 {
+		return fmt.Errorf("--logmaxsize and --logbackups cannot be negative")
+	}
+	if logFile == "" && accessLog == "" && (maxSizeMB > 0 || rotate != "" || backups > 0) {
+		return fmt.Errorf("--logmaxsize, --logrotate and --logbackups need --logfile or --accesslog")
+	}
+	if logFile != "" && logFile == accessLog {
+		return fmt.Errorf("--logfile and --accesslog must be different files")
+	}
+	return nil
+}
+
+// validateSessionRates checks the per-session rate flags.
+func validateSessionRates(mode string, rates ...float64) error {
+	if mode != "throttle" && mode != "close" {
+		return fmt.Errorf("--ratemode must be throttle or close, not %q", mode)
+	}
+	for _, r := range rates {
+		if r < 0 {
+			return fmt.Errorf("--inmsgrate, --inbyterate, --outmsgrate and --outbyterate cannot be negative")
+		}
+	}
+	return nil
+}
+
+// validateClientLimits checks the per-client limit flags. A burst only
+// means something for a rate, so --clientburst needs --clientrate.
+func validateClientLimits(maxConns int, rate float64, burst, prefix4, prefix6 int) error {
+	if maxConns < 0 || rate < 0 || burst < 0 {
+		return fmt.Errorf("--maxclientconns, --clientrate and --clientburst cannot be negative")
+	}
+	if burst > 0 && rate == 0 {
+		return fmt.Errorf("--clientburst needs --clientrate")
+	}
+	if prefix4 < 1 || prefix4 > 32 {
+		return fmt.Errorf("--clientprefix4 must be between 1 and 32, not %d", prefix4)
+	}
+	if prefix6 < 1 || prefix6 > 128 {
+		return fmt.Errorf("--clientprefix6 must be between 1 and 128, not %d", prefix6)
+	}
+	return nil
+}
+
+// validatePool checks the pre-forking flags. --poolmax raises the number of
+// spares from --poolmin under load, so it needs --poolmin and cannot be
+// below it.
+func validatePool(poolMin, poolMax int) error {
+	if poolMin < 0 || poolMax < 0 {
+		return fmt.Errorf("--poolmin and --poolmax cannot be negative")
+	}
+	if poolMax > 0 && poolMin == 0 {
+		return fmt.Errorf("--poolmax needs --poolmin")
+	}
+	if poolMax > 0 && poolMax < poolMin {
+		return fmt.Errorf("--poolmax (%d) cannot be below --poolmin (%d)", poolMax, poolMin)
+	}
+	return nil
+}
+
+// validateMixed checks that --mixed has a framing to carry binary records
+// in, and no tagged stderr messages to send without a type.
+func validateMixed(mixed bool, framing string, passStderr bool, routes []libwebsocketd.Route) error {
+	if !mixed {
+		return nil
+	}
+	if framing == "lines" || framing == "jsonlines" {
+		return fmt.Errorf("--mixed needs --framing=delim:C, netstring or length to carry binary messages")
+	}
+	if passStderr {
+		return fmt.Errorf("please only specify one of --mixed and --passstderr")
+	}
+	for _, r := range routes {
+		if r.PassStderr != nil && *r.PassStderr {
+			return fmt.Errorf("route %s: passstderr cannot be used with --mixed", r.Path)
+		}
+	}
+	return nil
+}
+
+// validateMultiplex checks the flags that cannot work with --multiplex: a
+// shared process's stderr belongs to no one session, there is nothing
+// to pre-fork when one process serves them all, and its stdin and stdout
+// are framed by the multiplex protocol.
+func validateMultiplex(passStderr, framed bool, poolMin int, routes []libwebsocketd.Route) error {
+	if poolMin > 0 {
+		return fmt.Errorf("please only specify one of --multiplex and --poolmin")
+	}
+	if passStderr {
+		return fmt.Errorf("please only specify one of --multiplex and --passstderr")
+	}
+	if framed {
+		return fmt.Errorf("please only specify one of --multiplex and --framing")
+	}
+	for _, r := range routes {
+		if r.PassStderr != nil && *r.PassStderr {
+			return fmt.Errorf("route %s: passstderr cannot be used with --multiplex", r.Path)
+		}
+	}
+	return nil
+}
+
+// validateBroadcast checks the --broadcast flags. One process already
+// serves every client, so there is nothing to pre-fork or multiplex.
+func validateBroadcast(broadcast, multiplex bool, poolMin, buffer int, slowClient string) error {
+	if slowClient != "drop" && slowClient != "close" {
+		return fmt.Errorf("--slowclient must be drop or close, not %q", slowClient)
+	}
+	if buffer <= 0 {
+		return fmt.Errorf("--broadcastbuffer must be at least 1")
+	}
+	if !broadcast {
+		return nil
+	}
+	if multiplex {
+		return fmt.Errorf("please only specify one of --broadcast and --multiplex")
+	}
+	if poolMin > 0 {
+		return fmt.Errorf("please only specify one of --broadcast and --poolmin")
+	}
+	return nil
+}
+
+// validateResume checks the --resumems flags. A shared process belongs to
+// no one session, so there is none to keep for a client.
+func validateResume(resumeMs uint, buffer int, multiplex, broadcast bool) error {
+	if buffer <= 0 {
+		return fmt.Errorf("--resumebuffer must be at least 1")
+	}
+	if resumeMs == 0 {
+		return nil
+	}
+	if multiplex {
+		return fmt.Errorf("please only specify one of --resumems and --multiplex")
+	}
+	if broadcast {
+		return fmt.Errorf("please only specify one of --resumems and --broadcast")
+	}
+	return nil
+}
+
+// validateAuth checks the flags that only mean something for JWTs.
+func validateAuth(auth, issuer, audience string) error {
+	if (issuer != "" || audience != "") && !strings.HasPrefix(auth, "jwt:") {
+		return fmt.Errorf("--jwtissuer and --jwtaudience need --auth=jwt:JWKS")
+	}
+	return nil
+}
+
+// configFileOnlyFlags are the flags that make no sense inside a --config
+// file: they either name the file itself or print something and exit.
+var configFileOnlyFlags = map[string]bool{"config": true, "help": true, "version": true, "license": true}
+
+// applyConfigFile reads the JSON object in the file at path and applies each
+// of its settings to the flag of the same name, unless that flag was already
+// given on the command line — command-line flags override the file. Because
+// the file only supplies flag values, everything parseCommandLine does with
+// flags afterwards (defaults, validation, path resolution) applies to the
+// merged result unchanged.
+//
+// Values may be strings, numbers or booleans. An array sets a repeatable flag
+// (such as "header") once per element, and is joined with commas for a list
+// flag (such as "origin" or "passenv"). The optional "command" key holds
+// COMMAND and its arguments as an array; it is returned, and used only when
+// no COMMAND is given on the command line. The "routes" key is left to
+// configRoutes.
+func applyConfigFile(fs *flag.FlagSet, path string) (command []string, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var settings map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // report problems deterministically
+
+	for _, key := range keys {
+		value := settings[key]
+		if key == "command" {
+			if _, isArray := value.([]interface{}); isArray {
+				command, err = configStrings(value)
+			}
+			if len(command) == 0 || err != nil {
+				return nil, fmt.Errorf("config file %s: \"command\" must be a non-empty array of strings", path)
+			}
+			continue
+		}
+		if key == "routes" {
+			continue
+		}
+		f := fs.Lookup(key)
+		if f == nil || configFileOnlyFlags[key] {
+			return nil, fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		if given[key] {
+			continue
+		}
+		values, err := configStrings(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: setting %q: %s", path, key, err)
+		}
+		if _, repeatable := f.Value.(*Arglist); !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, v := range values {
+			if err := fs.Set(key, v); err != nil {
+				return nil, fmt.Errorf("config file %s: setting %q: %s", path, key, err)
+			}
+		}
+	}
+	return command, nil
+}
+
+// configRoutes reads the "routes" object of the --config file at path, which
+// maps script paths under --dir to settings that differ from the server-wide
+// ones for the scripts at and below them:
+//
+//	"routes": {"/video": {"binary": true, "maxframesize": 0}}
+//
+// The settings are named and written as their flags. "header-ws" adds to
+// the server's headers rather than replacing them. A route may also have a
+// "command" array, as for the file's own "command", to serve its path with
+// that instead of a script (see --route).
+func configRoutes(path string) ([]libwebsocketd.Route, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	var file struct {
+		Routes map[string]map[string]interface{} `json:"routes"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("config file %s: \"routes\" must map script paths to objects of settings", path)
+	}
+
+	paths := make([]string, 0, len(file.Routes))
+	for p := range file.Routes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	routes := make([]libwebsocketd.Route, 0, len(paths))
+	for _, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("config file %s: route %q: path must start with /", path, p)
+		}
+		r := libwebsocketd.Route{Path: p}
+		if p != "/" {
+			r.Path = strings.TrimSuffix(p, "/")
+		}
+
+		fs := flag.NewFlagSet("route", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		binary := fs.Bool("binary", false, "")
+		passStderr := fs.Bool("passstderr", false, "")
+		closeMs := fs.Uint("closems", 0, "")
+		pingMs := fs.Uint("pingms", 0, "")
+		maxFrameSize := fs.Int64("maxframesize", 0, "")
+		var headersWs Arglist
+		fs.Var(&headersWs, "header-ws", "")
+
+		keys := make([]string, 0, len(file.Routes[p]))
+		for key := range file.Routes[p] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := file.Routes[p][key]
+			if key == "command" {
+				var command []string
+				if _, isArray := value.([]interface{}); isArray {
+					command, err = configStrings(value)
+				}
+				if len(command) == 0 || err != nil {
+					return nil, fmt.Errorf("config file %s: route %q: \"command\" must be a non-empty array of strings", path, p)
+				}
+				r.Command, r.Args = command[0], command[1:]
+				continue
+			}
+			f := fs.Lookup(key)
+			if f == nil {
+				return nil, fmt.Errorf("config file %s: route %q: %q cannot be set per route", path, p, key)
+			}
+			values, err := configStrings(value)
+			if err != nil {
+				return nil, fmt.Errorf("config file %s: route %q: setting %q: %s", path, p, key, err)
+			}
+			if _, repeatable := f.Value.(*Arglist); !repeatable {
+				values = []string{strings.Join(values, ",")}
+			}
+			for _, v := range values {
+				if err := fs.Set(key, v); err != nil {
+					return nil, fmt.Errorf("config file %s: route %q: setting %q: %s", path, p, key, err)
+				}
+			}
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "binary":
+				r.Binary = binary
+			case "passstderr":
+				r.PassStderr = passStderr
+			case "closems":
+				r.CloseMs = closeMs
+			case "pingms":
+				interval := time.Duration(*pingMs) * time.Millisecond
+				r.PingInterval = &interval
+			case "maxframesize":
+				r.MaxFrameSize = maxFrameSize
+			case "header-ws":
+				r.Headers = []string(headersWs)
+			}
+		})
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// addCommandRoutes adds the --route values, "PATH=COMMAND [ARGS]", to the
+// routes from the config file: a path the file already has a route for gets
+// the command (replacing any the file gave it), keeping its settings. Each
+// route's command is then looked up as COMMAND is.
+func addCommandRoutes(routes []libwebsocketd.Route, values []string) ([]libwebsocketd.Route, error) {
+	for _, v := range values {
+		p, command, ok := strings.Cut(v, "=")
+		if !ok || !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("%q should be PATH=COMMAND, with PATH starting with /", v)
+		}
+		if p != "/" {
+			p = strings.TrimSuffix(p, "/")
+		}
+		words, err := splitCommand(command)
+		if err != nil || len(words) == 0 {
+			return nil, fmt.Errorf("%q: no COMMAND to run", v)
+		}
+
+		i := 0
+		for i < len(routes) && routes[i].Path != p {
+			i++
+		}
+		if i == len(routes) {
+			routes = append(routes, libwebsocketd.Route{Path: p})
+		}
+		routes[i].Command, routes[i].Args = words[0], words[1:]
+	}
+
+	for i, r := range routes {
+		if r.Command == "" {
+			continue
+		}
+		path, err := exec.LookPath(r.Command)
+		if err != nil {
+			return nil, fmt.Errorf("unable to locate COMMAND '%s' for %s in OS path", r.Command, r.Path)
+		}
+		routes[i].Command = path
+	}
+	return routes, nil
+}
+
+// splitCommand splits the COMMAND of a --route into words, as a shell would
+// without expanding anything: words are separated by spaces, and quotes
+// ('...' literally, "..." with \" and \\) or a backslash keep them together.
+func splitCommand(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated \" in %q", s)
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// configStrings converts a config file value to the string(s) a flag accepts.
+func configStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool, json.Number:
+		return []string{fmt.Sprint(v)}, nil
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, elem := range v {
+			s, err := configStrings(elem)
+			if err != nil || len(s) != 1 {
+				return nil, fmt.Errorf("arrays may only hold strings, numbers or booleans")
+			}
+			out = append(out, s[0])
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// reloadableFlags are the settings a SIGHUP re-reads from the --config file.
+// Everything else is fixed at startup; see reloadConfigFile.
+var reloadableFlags = []string{"origin", "sameorigin", "header", "header-ws", "header-http", "maxforks"}
+
+// givenFlags records the value(s) of every flag set on fs so far.
+func givenFlags(fs *flag.FlagSet) map[string][]string {
+	given := make(map[string][]string)
+	fs.Visit(func(f *flag.Flag) {
+		if list, ok := f.Value.(*Arglist); ok {
+			given[f.Name] = append([]string(nil), *list...)
+		} else {
+			given[f.Name] = []string{f.Value.String()}
+		}
+	})
+	return given
+}
+
+// ignoredFlag stands in for a flag that cannot change after startup, so a
+// reloaded file may still mention it.
+type ignoredFlag struct{}
+
+func (ignoredFlag) String() string   { return "" }
+func (ignoredFlag) Set(string) error { return nil }
+func (ignoredFlag) IsBoolFlag() bool { return true }
+
+// reloadConfigFile re-reads config.ConfigFile and returns a copy of the lib
+// config with the reloadableFlags applied, plus the new --maxforks. defs is
+// the flag set parsed at startup; it supplies the names of the settings that
+// are accepted but ignored. As at startup, the command line overrides the
+// file, and a setting removed from the file reverts to its default.
+func reloadConfigFile(defs *flag.FlagSet, config *Config) (*libwebsocketd.Config, int, error) {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	allowOrigins := fs.String("origin", "", "")
+	sameOrigin := fs.Bool("sameorigin", false, "")
+	maxForks := fs.Int("maxforks", defaultMaxForks, "")
+	var headers, headersWs, headersHttp Arglist
+	fs.Var(&headers, "header", "")
+	fs.Var(&headersWs, "header-ws", "")
+	fs.Var(&headersHttp, "header-http", "")
+	defs.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(ignoredFlag{}, f.Name, "")
+		}
+	})
+
+	for name, values := range config.GivenFlags {
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if _, err := applyConfigFile(fs, config.ConfigFile); err != nil {
+		return nil, 0, err
+	}
+
+	reloaded := *config.Config
+	reloaded.AllowOrigins = splitOrigins(*allowOrigins)
+	reloaded.SameOrigin = *sameOrigin
+	reloaded.Headers = []string(headers)
+	reloaded.HeadersWs = []string(headersWs)
+	reloaded.HeadersHTTP = []string(headersHttp)
+	return &reloaded, *maxForks, nil
+}
+
+// splitOrigins turns the --origin list into Config.AllowOrigins.
+func splitOrigins(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
 func parseCommandLine() *Config {
 	var mainConfig Config
 	var config libwebsocketd.Config
@@ -210,24 +802,73 @@ func parseCommandLine() *Config {
 	flag.Var(&addrlist, "address", "Interfaces to bind to (e.g. 127.0.0.1 or [::1]).")
 
 	// server config options
+	configFlag := flag.String("config", "", "JSON file of option settings; command line options override it")
 	portFlag := flag.Int("port", 0, "HTTP port to listen on")
 	unixSocketFlag := flag.String("unixsocket", "", "Path of a Unix domain socket to listen on, in addition to (or instead of) --address/--port")
 	versionFlag := flag.Bool("version", false, "Print version and exit")
 	licenseFlag := flag.Bool("license", false, "Print license and exit")
 	logLevelFlag := flag.String("loglevel", "access", "Log level, one of: debug, trace, access, info, error, fatal")
+	logFormatFlag := flag.String("logformat", "text", "Log format, one of: text, json, logfmt")
+	logFileFlag := flag.String("logfile", "", "Write the log to this file instead of stdout")
+	accessLogFlag := flag.String("accesslog", "", "Write access-level log lines (CONNECT, DISCONNECT, ...) to this file instead")
+	logMaxSizeFlag := flag.Int("logmaxsize", 0, "Rotate log files when they reach this many megabytes (0 never)")
+	logRotateFlag := flag.String("logrotate", "", "Rotate log files hourly or daily")
+	logBackupsFlag := flag.Int("logbackups", 0, "Number of rotated log files to keep (0 keeps all)")
 	sslFlag := flag.Bool("ssl", false, "Use TLS on listening socket (see also --sslcert and --sslkey)")
 	sslCert := flag.String("sslcert", "", "Should point to certificate PEM file when --ssl is used")
 	sslKey := flag.String("sslkey", "", "Should point to certificate private key file when --ssl is used")
 	maxForksFlag := flag.Int("maxforks", defaultMaxForks, "Max forks, zero means unlimited")
+	poolMinFlag := flag.Int("poolmin", 0, "Spare processes to keep started for each WebSocket command (0 starts one per session)")
+	poolMaxFlag := flag.Int("poolmax", 0, "Most spare processes per command when sessions arrive faster than --poolmin are replaced (default --poolmin)")
+	multiplexFlag := flag.Bool("multiplex", false, "Run one process per command for all of its sessions, framed as JSON lines on stdin/stdout")
+	broadcastFlag := flag.Bool("broadcast", false, "Run one process per command and send its output to every client")
+	broadcastBufferFlag := flag.Int("broadcastbuffer", libwebsocketd.DefaultBroadcastBuffer, "Messages a --broadcast client may fall behind")
+	slowClientFlag := flag.String("slowclient", "drop", "What to do with a --broadcast client further behind: drop messages for it, or close it with 1013")
+	broadcastInputFlag := flag.Bool("broadcastinput", false, "Write --broadcast clients' messages to the process's stdin")
+	resumeMsFlag := flag.Uint("resumems", 0, "Milliseconds to keep a session's process for its client to reconnect to (0 disables)")
+	resumeBufferFlag := flag.Int("resumebuffer", libwebsocketd.DefaultResumeBuffer, "Messages to keep for a client that has gone away, with --resumems")
 	closeMsFlag := flag.Uint("closems", 0, "Time to start sending signals (0 never)")
 	pingMsFlag := flag.Uint("pingms", 0, "WebSocket ping interval in milliseconds (0 disables)")
 	maxFrameSizeFlag := flag.Int64("maxframesize", 1<<20, "Max inbound WebSocket message size in bytes (0 = unlimited)")
 	redirPortFlag := flag.Int("redirport", 0, "HTTP port to redirect to canonical --port address")
 	sslCaFlag := flag.String("sslca", "", "CA certificate file for client certificate verification (mutual TLS)")
+	acmeFlag := flag.String("acme", "", "Get and renew the TLS certificate for these domains from an ACME CA such as Let's Encrypt (implies --ssl)")
+	acmeEmailFlag := flag.String("acmeemail", "", "Contact email address for the ACME account")
+	acmeCacheFlag := flag.String("acmecache", "", "Directory to keep ACME account keys and certificates in")
+	acmeDirectoryFlag := flag.String("acmedirectory", autocert.DefaultACMEDirectory, "ACME directory URL of the CA")
+	acmeCAFlag := flag.String("acmeca", "", "CA certificates to trust for the ACME directory's HTTPS (e.g. a test CA's)")
+	sslClientAuthFlag := flag.String("sslclientauth", "require", "With --sslca, whether client certificates are required or optional")
+	drainMsFlag := flag.Uint("drainms", 5000, "On SIGINT/SIGTERM, how long to wait for sessions to end before killing their processes")
+	goingAwayFlag := flag.Bool("goingaway", true, "On SIGINT/SIGTERM, send each client a 1001 (going away) close frame")
+	closeCodesFlag := flag.String("closecodes", libwebsocketd.DefaultCloseCodes, "Close code to send when the process exits, by exit status (e.g. 0=1000,2=4002,signal=1001,*=1011)")
+	closeReasonFlag := flag.Bool("closereason", false, "Send the process's last line of STDERR as the close reason")
+	notifyCloseFlag := flag.Bool("notifyclose", false, "Write the client's close code and reason to the process's STDIN as a final JSON line")
+	inMsgRateFlag := flag.Float64("inmsgrate", 0, "Max messages per second from each client to its process (0 = unlimited)")
+	inByteRateFlag := flag.Float64("inbyterate", 0, "Max bytes per second from each client to its process (0 = unlimited)")
+	outMsgRateFlag := flag.Float64("outmsgrate", 0, "Max messages per second from each process to its client (0 = unlimited)")
+	outByteRateFlag := flag.Float64("outbyterate", 0, "Max bytes per second from each process to its client (0 = unlimited)")
+	rateModeFlag := flag.String("ratemode", "throttle", "What to do with a session over a rate: throttle it, or close it with 1008")
+	trustedProxyFlag := flag.String("trustedproxy", "", "Proxies (IP addresses or CIDR networks) whose Forwarded and X-Forwarded-* headers are believed")
+	proxyProtocolFlag := flag.Bool("proxyprotocol", false, "Expect a HAProxy PROXY protocol header on connections from --trustedproxy addresses")
+	maxClientConnsFlag := flag.Int("maxclientconns", 0, "Max concurrent WebSocket sessions per client address (0 = unlimited)")
+	clientRateFlag := flag.Float64("clientrate", 0, "Max new WebSocket sessions per second per client address (0 = unlimited)")
+	clientBurstFlag := flag.Int("clientburst", 0, "Sessions a client may open in a burst before --clientrate applies (0 = one second's worth)")
+	clientPrefix4Flag := flag.Int("clientprefix4", 32, "IPv4 prefix length that counts as one client for the per-client limits")
+	clientPrefix6Flag := flag.Int("clientprefix6", 64, "IPv6 prefix length that counts as one client for the per-client limits")
+	authFlag := flag.String("auth", "", "Authenticate WebSocket upgrades: basic:HTPASSWD, jwt:JWKS, command:PATH or url:URL")
+	authRealmFlag := flag.String("authrealm", "websocketd", "Realm of the WWW-Authenticate challenge sent with a 401")
+	jwtIssuerFlag := flag.String("jwtissuer", "", "With --auth=jwt:..., require this \"iss\" claim")
+	jwtAudienceFlag := flag.String("jwtaudience", "", "With --auth=jwt:..., require this value in the \"aud\" claim")
+	metricsFlag := flag.String("metrics", "", "Serve Prometheus metrics at /metrics on this address (e.g. 127.0.0.1:9100)")
 
 	// lib config options
 	binaryFlag := flag.Bool("binary", false, "Set websocketd to experimental binary mode (default is line by line)")
-	passStderrFlag := flag.Bool("passstderr", false, "Forward STDERR to WebSocket clients as tagged JSON messages, alongside tagged STDOUT (mutually exclusive with --binary)")
+	passStderrFlag := flag.Bool("passstderr", false, "Forward STDERR to WebSocket clients as tagged JSON messages, alongside tagged STDOUT (with --binary, as text messages)")
+	streamByteFlag := flag.Bool("streambyte", false, "With --binary --passstderr, send STDERR as binary too, each message starting with 1 (STDOUT) or 2 (STDERR)")
+	envelopeFlag := flag.String("envelope", "basic", "With --passstderr, the JSON messages: basic, or extended with a seq number, a time, and a last message giving the exit status")
+	rawStdoutFlag := flag.Bool("rawstdout", false, "With --passstderr, send STDOUT as it is and wrap only STDERR in JSON")
+	framingFlag := flag.String("framing", "lines", "How messages are delimited on the process's stdin and stdout: lines, delim:C, netstring, length or jsonlines")
+	mixedFlag := flag.Bool("mixed", false, "Relay text and binary messages both, each record starting with its type: t or b (needs --framing)")
 	reverseLookupFlag := flag.Bool("reverselookup", false, "Perform reverse DNS lookups on remote clients")
 	scriptDirFlag := flag.String("dir", "", "Base directory for WebSocket scripts")
 	staticDirFlag := flag.String("staticdir", "", "Serve static content from this directory over HTTP")
@@ -237,6 +878,11 @@ func parseCommandLine() *Config {
 	sameOriginFlag := flag.Bool("sameorigin", false, "Restrict upgrades if origin and host headers differ")
 	allowOriginsFlag := flag.String("origin", "", "Restrict upgrades if origin does not match the list")
 
+	routes := Arglist(make([]string, 0))
+	flag.Var(&routes, "route", "Serve WebSocket connections to PATH with COMMAND (PATH=COMMAND [ARGS]); repeatable")
+	certRules := Arglist(make([]string, 0))
+	flag.Var(&certRules, "certrule", "Require a verified client certificate for WebSocket scripts under PATH, matching PATTERN if given (PATH[:PATTERN])")
+
 	headers := Arglist(make([]string, 0))
 	headersWs := Arglist(make([]string, 0))
 	headersHttp := Arglist(make([]string, 0))
@@ -272,6 +918,32 @@ func parseCommandLine() *Config {
 		os.Exit(0)
 	}
 
+	// Fill in whatever the command line left unset from the config file,
+	// before anything below reads a flag.
+	args := flag.Args()
+	mainConfig.GivenFlags = givenFlags(flag.CommandLine)
+	mainConfig.ConfigFile = *configFlag
+	if *configFlag != "" {
+		fileCommand, err := applyConfigFile(flag.CommandLine, *configFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			args = fileCommand
+		}
+		if config.Routes, err = configRoutes(*configFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// --acme is --ssl with a certificate from the CA, so it decides the
+	// default port and everything else --ssl does.
+	if *acmeFlag != "" {
+		*sslFlag = true
+	}
+
 	// Resolve port and addresses. A bare --unixsocket with no --port,
 	// --address, or --redirport means Unix-socket-only: skip the default
 	// TCP listener entirely rather than also binding ":80".
@@ -282,6 +954,9 @@ func parseCommandLine() *Config {
 	mainConfig.UnixSocket = *unixSocketFlag
 	mainConfig.MaxForks = *maxForksFlag
 	mainConfig.RedirPort = *redirPortFlag
+	mainConfig.DrainTimeout = time.Duration(*drainMsFlag) * time.Millisecond
+	mainConfig.GoingAway = *goingAwayFlag
+	mainConfig.MetricsAddr = *metricsFlag
 
 	// Validate log level
 	mainConfig.LogLevel = libwebsocketd.LevelFromString(*logLevelFlag)
@@ -290,17 +965,138 @@ func parseCommandLine() *Config {
 		ShortHelp()
 		os.Exit(1)
 	}
+	if _, ok := logFormats[*logFormatFlag]; !ok {
+		fmt.Printf("Incorrect logformat flag '%s'. Use --help to see allowed values.\n", *logFormatFlag)
+		ShortHelp()
+		os.Exit(1)
+	}
+	mainConfig.LogFormat = *logFormatFlag
+
+	// Validate log files
+	if err := validateLogFiles(*logFileFlag, *accessLogFlag, *logMaxSizeFlag, *logRotateFlag, *logBackupsFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	mainConfig.LogFile = *logFileFlag
+	mainConfig.AccessLog = *accessLogFlag
+	mainConfig.LogMaxSize = int64(*logMaxSizeFlag) << 20
+	mainConfig.LogRotate = *logRotateFlag
+	mainConfig.LogBackups = *logBackupsFlag
 
 	// Validate SSL
-	if err := validateSSL(*sslFlag, *sslCert, *sslKey); err != nil {
+	if err := validateACME(*acmeFlag, *sslCert, *sslKey, *acmeDirectoryFlag); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
+	if *acmeFlag == "" {
+		if err := validateSSL(*sslFlag, *sslCert, *sslKey); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	} else {
+		for _, d := range strings.Split(*acmeFlag, ",") {
+			mainConfig.ACMEDomains = append(mainConfig.ACMEDomains, strings.TrimSpace(d))
+		}
+	}
+	mainConfig.ACMEEmail = *acmeEmailFlag
+	mainConfig.ACMECache = *acmeCacheFlag
+	mainConfig.ACMEDirectory = *acmeDirectoryFlag
+	mainConfig.ACMECA = *acmeCAFlag
 	mainConfig.CertFile = *sslCert
 	mainConfig.KeyFile = *sslKey
 
-	// Validate --binary / --passstderr
-	if err := validateBinaryPassStderr(*binaryFlag, *passStderrFlag); err != nil {
+	// Validate client certificate options
+	if err := validateClientCerts(*sslFlag, *sslCaFlag, *sslClientAuthFlag, len(certRules)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	clientCertRules, err := libwebsocketd.ParseCertRules([]string(certRules))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--certrule: %s\n", err)
+		os.Exit(1)
+	}
+	mainConfig.OptionalCert = *sslClientAuthFlag == "optional"
+
+	// Parse --framing
+	framing, err := libwebsocketd.NewFraming(*framingFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--framing: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Add --route commands to the config file's routes
+	if config.Routes, err = addCommandRoutes(config.Routes, []string(routes)); err != nil {
+		fmt.Fprintf(os.Stderr, "--route: %s\n", err)
+		os.Exit(1)
+	}
+	if err := validateStreamByte(*streamByteFlag, *binaryFlag, *passStderrFlag, config.Routes); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err := validateEnvelope(*envelopeFlag, *rawStdoutFlag, *binaryFlag, *passStderrFlag, config.Routes); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate close codes
+	closeCodes, err := libwebsocketd.ParseCloseCodes(*closeCodesFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--closecodes: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate trusted proxies
+	trustedProxies, err := libwebsocketd.ParseTrustedProxies(*trustedProxyFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--trustedproxy: %s\n", err)
+		os.Exit(1)
+	}
+	if *proxyProtocolFlag && len(trustedProxies) == 0 {
+		fmt.Fprintf(os.Stderr, "--proxyprotocol needs --trustedproxy to say which peers may send the header\n")
+		os.Exit(1)
+	}
+	mainConfig.ProxyProtocol = *proxyProtocolFlag
+
+	// Validate per-session rate limits
+	if err := validateSessionRates(*rateModeFlag, *inMsgRateFlag, *inByteRateFlag, *outMsgRateFlag, *outByteRateFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate per-client limits
+	if err := validateClientLimits(*maxClientConnsFlag, *clientRateFlag, *clientBurstFlag, *clientPrefix4Flag, *clientPrefix6Flag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate pre-forking
+	if err := validatePool(*poolMinFlag, *poolMaxFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate --mixed
+	if err := validateMixed(*mixedFlag, *framingFlag, *passStderrFlag, config.Routes); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate --multiplex
+	if *multiplexFlag {
+		if err := validateMultiplex(*passStderrFlag, framing != nil, *poolMinFlag, config.Routes); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Validate --broadcast
+	if err := validateBroadcast(*broadcastFlag, *multiplexFlag, *poolMinFlag, *broadcastBufferFlag, *slowClientFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// Validate --resumems
+	if err := validateResume(*resumeMsFlag, *resumeBufferFlag, *multiplexFlag, *broadcastFlag); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
@@ -312,11 +1108,40 @@ func parseCommandLine() *Config {
 	config.CloseMs = *closeMsFlag
 	config.PingInterval = time.Duration(*pingMsFlag) * time.Millisecond
 	config.MaxFrameSize = *maxFrameSizeFlag
+	config.CloseCodes = closeCodes
+	config.CloseReason = *closeReasonFlag
+	config.NotifyClose = *notifyCloseFlag
+	config.TrustedProxies = trustedProxies
+	config.InMsgRate = *inMsgRateFlag
+	config.InByteRate = *inByteRateFlag
+	config.OutMsgRate = *outMsgRateFlag
+	config.OutByteRate = *outByteRateFlag
+	config.RateClose = *rateModeFlag == "close"
+	config.PoolMin = *poolMinFlag
+	config.PoolMax = *poolMaxFlag
+	config.Multiplex = *multiplexFlag
+	config.Broadcast = *broadcastFlag
+	config.BroadcastBuffer = *broadcastBufferFlag
+	config.BroadcastClose = *slowClientFlag == "close"
+	config.BroadcastInput = *broadcastInputFlag
+	config.ResumeMs = *resumeMsFlag
+	config.ResumeBuffer = *resumeBufferFlag
+	config.MaxClientConns = *maxClientConnsFlag
+	config.ClientRate = *clientRateFlag
+	config.ClientBurst = *clientBurstFlag
+	config.ClientPrefix4 = *clientPrefix4Flag
+	config.ClientPrefix6 = *clientPrefix6Flag
 	config.Binary = *binaryFlag
+	config.Framing = framing
+	config.Mixed = *mixedFlag
+	config.StreamByte = *streamByteFlag
+	config.ExtEnvelope = *envelopeFlag == "extended"
+	config.RawStdout = *rawStdoutFlag
 	config.PassStderr = *passStderrFlag
 	config.ReverseLookup = *reverseLookupFlag
 	config.Ssl = *sslFlag
 	config.SslCaFile = *sslCaFlag
+	config.CertRules = clientCertRules
 	config.ScriptDir = *scriptDirFlag
 	config.StaticDir = *staticDirFlag
 	config.CgiDir = *cgiDirFlag
@@ -328,16 +1153,31 @@ func parseCommandLine() *Config {
 	// Build parent environment
 	config.ParentEnv = buildParentEnv(*passEnvFlag)
 
-	// Parse origins
-	if *allowOriginsFlag != "" {
-		config.AllowOrigins = strings.Split(*allowOriginsFlag, ",")
+	// Load authentication
+	if err := validateAuth(*authFlag, *jwtIssuerFlag, *jwtAudienceFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
+	if *authFlag != "" {
+		config.Auth, err = libwebsocketd.NewAuthenticator(*authFlag, libwebsocketd.AuthOptions{
+			Realm:    *authRealmFlag,
+			Issuer:   *jwtIssuerFlag,
+			Audience: *jwtAudienceFlag,
+			Env:      config.ParentEnv,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--auth: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Parse origins
+	config.AllowOrigins = splitOrigins(*allowOriginsFlag)
 	config.SameOrigin = *sameOriginFlag
 
 	// Resolve command or script directory
-	args := flag.Args()
-	if len(args) < 1 && config.ScriptDir == "" && config.StaticDir == "" && config.CgiDir == "" {
-		fmt.Fprintf(os.Stderr, "Please specify COMMAND or provide --dir, --staticdir or --cgidir argument.\n")
+	if len(args) < 1 && len(routes) == 0 && config.ScriptDir == "" && config.StaticDir == "" && config.CgiDir == "" {
+		fmt.Fprintf(os.Stderr, "Please specify COMMAND or provide --route, --dir, --staticdir or --cgidir argument.\n")
 		ShortHelp()
 		os.Exit(1)
 	}
@@ -364,6 +1204,12 @@ func parseCommandLine() *Config {
 		config.ScriptDir = scriptDir
 		config.UsingScriptDir = true
 	}
+	for _, r := range config.Routes {
+		if r.Command == "" && !config.UsingScriptDir {
+			fmt.Fprintf(os.Stderr, "Route %s in the config file has no \"command\", so needs --dir.\n", r.Path)
+			os.Exit(1)
+		}
+	}
 
 	if err := validateDir(config.CgiDir, "CGI dir"); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
 )
 
 // TestDefaultMaxForksIsFinite guards the security intent: the fork limit must
@@ -120,23 +125,56 @@ func TestWantsUnixSocketOnly(t *testing.T) {
 	}
 }
 
-func TestValidateBinaryPassStderr(t *testing.T) {
+func TestValidateStreamByte(t *testing.T) {
+	yes, no := true, false
 	tests := []struct {
-		name       string
-		binary     bool
-		passStderr bool
-		wantErr    bool
+		name                           string
+		streamByte, binary, passStderr bool
+		routes                         []libwebsocketd.Route
+		wantErr                        bool
+	}{
+		{"off", false, false, false, nil, false},
+		{"binary and passstderr", true, true, true, nil, false},
+		{"binary only", true, true, false, nil, true},
+		{"passstderr only", true, false, true, nil, true},
+		{"passstderr route, global binary", true, true, false, []libwebsocketd.Route{{Path: "/debug", PassStderr: &yes}}, false},
+		{"binary route, global passstderr", true, false, true, []libwebsocketd.Route{{Path: "/video", Binary: &yes}}, false},
+		{"route turns off binary", true, true, true, []libwebsocketd.Route{{Path: "/chat", Binary: &no}}, false},
+		{"route without both", true, false, false, []libwebsocketd.Route{{Path: "/video", Binary: &yes}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStreamByte(tt.streamByte, tt.binary, tt.passStderr, tt.routes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateStreamByte() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEnvelope(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name                      string
+		envelope                  string
+		rawStdout, binary, stderr bool
+		routes                    []libwebsocketd.Route
+		wantErr                   bool
 	}{
-		{"neither set", false, false, false},
-		{"binary only", true, false, false},
-		{"passstderr only", false, true, false},
-		{"both set", true, true, true},
+		{"default", "basic", false, false, false, nil, false},
+		{"extended", "extended", false, false, true, nil, false},
+		{"rawstdout", "basic", true, false, true, nil, false},
+		{"unknown", "full", false, false, true, nil, true},
+		{"without passstderr", "extended", false, false, false, nil, true},
+		{"binary", "basic", true, true, true, nil, true},
+		{"text route of a binary server", "extended", false, true, true, []libwebsocketd.Route{{Path: "/log", Binary: &no}}, false},
+		{"passstderr route", "extended", true, false, false, []libwebsocketd.Route{{Path: "/debug", PassStderr: &yes}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateBinaryPassStderr(tt.binary, tt.passStderr)
+			err := validateEnvelope(tt.envelope, tt.rawStdout, tt.binary, tt.stderr, tt.routes)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("validateBinaryPassStderr(%v, %v) error = %v, wantErr %v", tt.binary, tt.passStderr, err, tt.wantErr)
+				t.Errorf("validateEnvelope() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -324,3 +362,572 @@ func TestValidateDir(t *testing.T) {
 		}
 	})
 }
+
+func TestValidateLogFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		logFile   string
+		accessLog string
+		maxSizeMB int
+		rotate    string
+		backups   int
+		wantErr   bool
+	}{
+		{"stdout", "", "", 0, "", 0, false},
+		{"log file", "ws.log", "", 0, "", 0, false},
+		{"access log only", "", "access.log", 0, "", 0, false},
+		{"size rotation", "ws.log", "", 10, "", 5, false},
+		{"daily rotation", "", "access.log", 0, "daily", 7, false},
+		{"hourly rotation", "ws.log", "access.log", 100, "hourly", 0, false},
+		{"unknown period", "ws.log", "", 0, "weekly", 0, true},
+		{"rotation without a file", "", "", 10, "", 0, true},
+		{"period without a file", "", "", 0, "daily", 0, true},
+		{"backups without a file", "", "", 0, "", 3, true},
+		{"negative size", "ws.log", "", -1, "", 0, true},
+		{"negative backups", "ws.log", "", 0, "", -1, true},
+		{"same file twice", "ws.log", "ws.log", 0, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLogFiles(tt.logFile, tt.accessLog, tt.maxSizeMB, tt.rotate, tt.backups)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLogFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSessionRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		rates   []float64
+		wantErr bool
+	}{
+		{"defaults", "throttle", []float64{0, 0, 0, 0}, false},
+		{"close", "close", []float64{10, 65536, 0, 0}, false},
+		{"fractional rate", "throttle", []float64{0.5, 0, 0, 0}, false},
+		{"unknown mode", "drop", []float64{0, 0, 0, 0}, true},
+		{"negative rate", "throttle", []float64{0, 0, -1, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSessionRates(tt.mode, tt.rates...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSessionRates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateClientLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxConns int
+		rate     float64
+		burst    int
+		prefix4  int
+		prefix6  int
+		wantErr  bool
+	}{
+		{"defaults", 0, 0, 0, 32, 64, false},
+		{"connection cap", 4, 0, 0, 32, 64, false},
+		{"rate and burst", 0, 0.5, 5, 24, 48, false},
+		{"negative cap", -1, 0, 0, 32, 64, true},
+		{"negative rate", 0, -1, 0, 32, 64, true},
+		{"burst without rate", 0, 0, 5, 32, 64, true},
+		{"ipv4 prefix too long", 0, 0, 0, 33, 64, true},
+		{"zero ipv4 prefix", 0, 0, 0, 0, 64, true},
+		{"ipv6 prefix too long", 0, 0, 0, 32, 129, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateClientLimits(tt.maxConns, tt.rate, tt.burst, tt.prefix4, tt.prefix6)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateClientLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateClientCerts(t *testing.T) {
+	tests := []struct {
+		name       string
+		ssl        bool
+		caFile     string
+		clientAuth string
+		rules      int
+		wantErr    bool
+	}{
+		{"defaults", false, "", "require", 0, false},
+		{"mutual tls", true, "ca.pem", "require", 0, false},
+		{"optional with rules", true, "ca.pem", "optional", 2, false},
+		{"unknown mode", true, "ca.pem", "sometimes", 0, true},
+		{"optional without ca", true, "", "optional", 0, true},
+		{"rules without ssl", false, "ca.pem", "require", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateClientCerts(tt.ssl, tt.caFile, tt.clientAuth, tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateClientCerts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateACME(t *testing.T) {
+	const le = "https://acme-v02.api.letsencrypt.org/directory"
+	tests := []struct {
+		name      string
+		domains   string
+		certFile  string
+		keyFile   string
+		directory string
+		wantErr   bool
+	}{
+		{"off", "", "cert.pem", "key.pem", le, false},
+		{"one domain", "example.com", "", "", le, false},
+		{"several domains", "example.com, www.example.com", "", "", le, false},
+		{"with sslcert", "example.com", "cert.pem", "", le, true},
+		{"with sslkey", "example.com", "", "key.pem", le, true},
+		{"empty domain", "example.com,,", "", "", le, true},
+		{"domain with port", "example.com:443", "", "", le, true},
+		{"bad directory", "example.com", "", "", "letsencrypt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateACME(tt.domains, tt.certFile, tt.keyFile, tt.directory)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateACME() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     string
+		issuer   string
+		audience string
+		wantErr  bool
+	}{
+		{"no auth", "", "", "", false},
+		{"basic", "basic:htpasswd", "", "", false},
+		{"jwt claims", "jwt:jwks.json", "https://issuer.example", "websocketd", false},
+		{"issuer without jwt", "basic:htpasswd", "https://issuer.example", "", true},
+		{"audience without auth", "", "", "websocketd", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAuth(tt.auth, tt.issuer, tt.audience)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// configFlagSet mirrors a slice of parseCommandLine's flags on a private
+// FlagSet, so applyConfigFile can be exercised without the global one.
+func configFlagSet(t *testing.T, args ...string) (*flag.FlagSet, *int, *string, *bool, *Arglist) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 0, "")
+	origin := fs.String("origin", "", "")
+	binary := fs.Bool("binary", false, "")
+	headers := Arglist(make([]string, 0))
+	fs.Var(&headers, "header", "")
+	fs.String("config", "", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parse %v: %v", args, err)
+	}
+	return fs, port, origin, binary, &headers
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "websocketd.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	t.Run("fills unset flags", func(t *testing.T) {
+		fs, port, origin, binary, headers := configFlagSet(t)
+		path := writeConfigFile(t, `{
+			"port": 8080,
+			"origin": ["https://a.example", "b.example:8443"],
+			"binary": true,
+			"header": ["X-One: 1", "X-Two: 2"],
+			"command": ["cat", "-u"]
+		}`)
+		command, err := applyConfigFile(fs, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *port != 8080 {
+			t.Errorf("port = %d, want 8080", *port)
+		}
+		if *origin != "https://a.example,b.example:8443" {
+			t.Errorf("origin = %q, want the array joined with commas", *origin)
+		}
+		if !*binary {
+			t.Error("binary = false, want true")
+		}
+		if len(*headers) != 2 || (*headers)[0] != "X-One: 1" || (*headers)[1] != "X-Two: 2" {
+			t.Errorf("header = %v, want one entry per array element", *headers)
+		}
+		if len(command) != 2 || command[0] != "cat" || command[1] != "-u" {
+			t.Errorf("command = %v, want [cat -u]", command)
+		}
+	})
+
+	t.Run("command line overrides file", func(t *testing.T) {
+		fs, port, origin, _, headers := configFlagSet(t, "--port=9090", "--header=X-Cli: 1")
+		path := writeConfigFile(t, `{"port": 8080, "origin": "a.example", "header": ["X-File: 1"]}`)
+		if _, err := applyConfigFile(fs, path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *port != 9090 {
+			t.Errorf("port = %d, want the command line's 9090", *port)
+		}
+		if *origin != "a.example" {
+			t.Errorf("origin = %q, want the file's value", *origin)
+		}
+		if len(*headers) != 1 || (*headers)[0] != "X-Cli: 1" {
+			t.Errorf("header = %v, want only the command line's header", *headers)
+		}
+	})
+
+	errorCases := []struct {
+		name    string
+		content string
+	}{
+		{"unknown setting", `{"nosuchflag": 1}`},
+		{"config inside config", `{"config": "other.json"}`},
+		{"version is not a setting", `{"version": true}`},
+		{"invalid JSON", `{"port": }`},
+		{"not an object", `["port", 8080]`},
+		{"object value", `{"origin": {"host": "a"}}`},
+		{"invalid flag value", `{"port": "eighty"}`},
+		{"empty command", `{"command": []}`},
+		{"command not an array", `{"command": "cat"}`},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, _, _, _, _ := configFlagSet(t)
+			if _, err := applyConfigFile(fs, writeConfigFile(t, tt.content)); err == nil {
+				t.Errorf("expected an error for %s", tt.content)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		fs, _, _, _, _ := configFlagSet(t)
+		if _, err := applyConfigFile(fs, filepath.Join(t.TempDir(), "absent.json")); err == nil {
+			t.Error("expected an error for a missing file")
+		}
+	})
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	// defs stands in for the startup flag set: --header came from the
+	// command line, everything else from the file.
+	defs, _, _, _, _ := configFlagSet(t, "--header=X-Cli: 1")
+	path := writeConfigFile(t, `{
+		"origin": ["https://new.example"],
+		"maxforks": 3,
+		"port": 9999,
+		"header": ["X-File: 1"],
+		"header-ws": ["X-Ws: 1"]
+	}`)
+	config := &Config{
+		Config:     &libwebsocketd.Config{CommandName: "cat", Headers: []string{"X-Cli: 1"}},
+		ConfigFile: path,
+		GivenFlags: givenFlags(defs),
+	}
+
+	reloaded, maxForks, err := reloadConfigFile(defs, config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxForks != 3 {
+		t.Errorf("maxForks = %d, want 3", maxForks)
+	}
+	if len(reloaded.AllowOrigins) != 1 || reloaded.AllowOrigins[0] != "https://new.example" {
+		t.Errorf("AllowOrigins = %v, want the file's list", reloaded.AllowOrigins)
+	}
+	if len(reloaded.Headers) != 1 || reloaded.Headers[0] != "X-Cli: 1" {
+		t.Errorf("Headers = %v, want only the command line's header", reloaded.Headers)
+	}
+	if len(reloaded.HeadersWs) != 1 || reloaded.HeadersWs[0] != "X-Ws: 1" {
+		t.Errorf("HeadersWs = %v, want the file's header", reloaded.HeadersWs)
+	}
+	if reloaded.CommandName != "cat" {
+		t.Errorf("CommandName = %q; settings outside the reloadable set must be kept", reloaded.CommandName)
+	}
+	if config.Config.AllowOrigins != nil {
+		t.Error("reloadConfigFile modified the startup config")
+	}
+
+	t.Run("removed setting reverts to default", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte(`{}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		reloaded, maxForks, err := reloadConfigFile(defs, config)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if maxForks != defaultMaxForks || reloaded.AllowOrigins != nil {
+			t.Errorf("got maxforks %d, origins %v; want the defaults", maxForks, reloaded.AllowOrigins)
+		}
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte(`{"maxforks": "many"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := reloadConfigFile(defs, config); err == nil {
+			t.Error("expected an error for an invalid setting")
+		}
+	})
+}
+
+func TestConfigRoutes(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"port": 8080,
+		"routes": {
+			"/video/": {"binary": true, "maxframesize": 0, "pingms": 5000},
+			"/chat": {"closems": 250, "header-ws": ["X-Room: lobby"]},
+			"/cat": {"command": ["cat", "-u"], "binary": true}
+		}
+	}`)
+	routes, err := configRoutes(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("got %d routes, want 3", len(routes))
+	}
+	cat, chat, video := routes[0], routes[1], routes[2]
+	if cat.Command != "cat" || len(cat.Args) != 1 || cat.Args[0] != "-u" || cat.Binary == nil {
+		t.Errorf("cat route = %+v", cat)
+	}
+	if chat.Path != "/chat" || chat.CloseMs == nil || *chat.CloseMs != 250 || len(chat.Headers) != 1 || chat.Binary != nil {
+		t.Errorf("chat route = %+v", chat)
+	}
+	if video.Path != "/video" || video.Binary == nil || !*video.Binary || video.MaxFrameSize == nil || *video.MaxFrameSize != 0 ||
+		video.PingInterval == nil || *video.PingInterval != 5*time.Second || video.CloseMs != nil {
+		t.Errorf("video route = %+v", video)
+	}
+
+	if routes, err := configRoutes(writeConfigFile(t, `{"port": 8080}`)); err != nil || len(routes) != 0 {
+		t.Errorf("no routes: got %v, %v", routes, err)
+	}
+
+	errorCases := []struct {
+		name    string
+		content string
+	}{
+		{"not an object", `{"routes": ["/video"]}`},
+		{"relative path", `{"routes": {"video": {"binary": true}}}`},
+		{"global-only setting", `{"routes": {"/video": {"port": 8080}}}`},
+		{"invalid value", `{"routes": {"/video": {"closems": "soon"}}}`},
+		{"command not an array", `{"routes": {"/cat": {"command": "cat"}}}`},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := configRoutes(writeConfigFile(t, tt.content)); err == nil {
+				t.Errorf("expected an error for %s", tt.content)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"./chat.py", []string{"./chat.py"}},
+		{"  tail  -F log ", []string{"tail", "-F", "log"}},
+		{`tail -F "my log"`, []string{"tail", "-F", "my log"}},
+		{`echo 'a "b"' c\ d`, []string{"echo", `a "b"`, "c d"}},
+		{`echo "say \"hi\"" x""`, []string{"echo", `say "hi"`, "x"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{`echo "hi`, `echo 'hi`} {
+		if _, err := splitCommand(bad); err == nil {
+			t.Errorf("splitCommand(%q) should fail", bad)
+		}
+	}
+}
+
+func TestAddCommandRoutes(t *testing.T) {
+	yes := true
+	fromFile := []libwebsocketd.Route{{Path: "/feed", Binary: &yes}, {Path: "/video"}}
+	routes, err := addCommandRoutes(fromFile, []string{"/chat/=cat -u", `/feed=tail -F "my log"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("got %d routes, want 3: %+v", len(routes), routes)
+	}
+	feed, video, chat := routes[0], routes[1], routes[2]
+	if filepath.Base(feed.Command) != "tail" || len(feed.Args) != 2 || feed.Args[1] != "my log" || feed.Binary == nil {
+		t.Errorf("feed = %+v, want the file's settings with the --route command", feed)
+	}
+	if video.Command != "" {
+		t.Errorf("video = %+v, want no command", video)
+	}
+	if chat.Path != "/chat" || filepath.Base(chat.Command) != "cat" || !filepath.IsAbs(chat.Command) {
+		t.Errorf("chat = %+v, want cat looked up in PATH", chat)
+	}
+
+	for _, bad := range []string{"chat=cat", "/chat", "/chat=", "/chat=no-such-command-here"} {
+		if _, err := addCommandRoutes(nil, []string{bad}); err == nil {
+			t.Errorf("addCommandRoutes(%q) should fail", bad)
+		}
+	}
+}
+
+func TestValidatePool(t *testing.T) {
+	tests := []struct {
+		name             string
+		poolMin, poolMax int
+		wantErr          bool
+	}{
+		{"off", 0, 0, false},
+		{"min only", 4, 0, false},
+		{"min and max", 4, 16, false},
+		{"equal", 4, 4, false},
+		{"negative", -1, 0, true},
+		{"max without min", 0, 8, true},
+		{"max below min", 8, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePool(tt.poolMin, tt.poolMax)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMixed(t *testing.T) {
+	stderr := true
+	tests := []struct {
+		name       string
+		mixed      bool
+		framing    string
+		passStderr bool
+		routes     []libwebsocketd.Route
+		wantErr    bool
+	}{
+		{"off", false, "lines", false, nil, false},
+		{"length", true, "length", false, nil, false},
+		{"netstring", true, "netstring", false, nil, false},
+		{"delimiter", true, "delim:\\0", false, nil, false},
+		{"lines", true, "lines", false, nil, true},
+		{"jsonlines", true, "jsonlines", false, nil, true},
+		{"with passstderr", true, "length", true, nil, true},
+		{"route with passstderr", true, "length", false, []libwebsocketd.Route{{Path: "/a", PassStderr: &stderr}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMixed(tt.mixed, tt.framing, tt.passStderr, tt.routes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMixed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMultiplex(t *testing.T) {
+	stderr, quiet := true, false
+	tests := []struct {
+		name               string
+		passStderr, framed bool
+		poolMin            int
+		routes             []libwebsocketd.Route
+		wantErr            bool
+	}{
+		{"alone", false, false, 0, nil, false},
+		{"with poolmin", false, false, 2, nil, true},
+		{"with passstderr", true, false, 0, nil, true},
+		{"with framing", false, true, 0, nil, true},
+		{"route with passstderr", false, false, 0, []libwebsocketd.Route{{Path: "/a", PassStderr: &stderr}}, true},
+		{"route without passstderr", false, false, 0, []libwebsocketd.Route{{Path: "/a", PassStderr: &quiet}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMultiplex(tt.passStderr, tt.framed, tt.poolMin, tt.routes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMultiplex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateResume(t *testing.T) {
+	tests := []struct {
+		name                 string
+		resumeMs             uint
+		buffer               int
+		multiplex, broadcast bool
+		wantErr              bool
+	}{
+		{"off", 0, 256, false, false, false},
+		{"on", 5000, 256, false, false, false},
+		{"no buffer", 5000, 0, false, false, true},
+		{"with multiplex", 5000, 256, true, false, true},
+		{"with broadcast", 5000, 256, false, true, true},
+		{"multiplex without resume", 0, 256, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResume(tt.resumeMs, tt.buffer, tt.multiplex, tt.broadcast)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateResume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateBroadcast(t *testing.T) {
+	tests := []struct {
+		name                 string
+		broadcast, multiplex bool
+		poolMin, buffer      int
+		slowClient           string
+		wantErr              bool
+	}{
+		{"off", false, false, 0, 256, "drop", false},
+		{"on", true, false, 0, 256, "drop", false},
+		{"close slow clients", true, false, 0, 16, "close", false},
+		{"bad policy", true, false, 0, 256, "block", true},
+		{"no buffer", true, false, 0, 0, "drop", true},
+		{"with multiplex", true, true, 0, 256, "drop", true},
+		{"with poolmin", true, false, 2, 256, "drop", true},
+		{"poolmin without broadcast", false, false, 2, 256, "drop", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBroadcast(tt.broadcast, tt.multiplex, tt.poolMin, tt.buffer, tt.slowClient)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateBroadcast() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+#!/usr/bin/python
+
+# Copyright 2026 Joe Walnes and the websocketd team.
+# All rights reserved.
+# Use of this source code is governed by a BSD-style
+# license that can be found in the LICENSE file.
+
+# Run with: websocketd --port=8080 --multiplex ./chat-room.py
+
+# With --multiplex one process serves every client, so they can talk to each
+# other. Each line on STDIN and STDOUT is a JSON object naming the session
+# ("id") it is about.
+
+import json
+from sys import stdin, stdout
+
+names = {}
+
+def send(id, data):
+  print(json.dumps({'event': 'message', 'id': id, 'data': data}))
+
+def announce(text):
+  for id in names:
+    send(id, text)
+
+for line in stdin:
+  frame = json.loads(line)
+  id = frame['id']
+  if frame['event'] == 'connect':
+    names[id] = frame['env'].get('REMOTE_ADDR', 'someone')
+    announce('%s joined' % names[id])
+  elif frame['event'] == 'disconnect':
+    announce('%s left' % names.pop(id, 'someone'))
+  elif frame['event'] == 'message':
+    announce('%s: %s' % (names.get(id), frame['data']))
+  stdout.flush() # Remember to flush
@@ -0,0 +1,29 @@
+#!/usr/bin/python
+
+# Copyright 2026 Joe Walnes and the websocketd team.
+# All rights reserved.
+# Use of this source code is governed by a BSD-style
+# license that can be found in the LICENSE file.
+
+# Run with: websocketd --port=8080 --poolmin=4 ./pooled-greeter.py
+
+import json
+import os
+from sys import stdin, stdout
+
+# Slow imports and setup go here, before a client connects.
+
+# A pre-forked process (--poolmin) is started before its session, so the
+# session's variables (REMOTE_ADDR, QUERY_STRING, ...) come as a JSON object
+# on the first line of STDIN rather than in the environment.
+if os.environ.get('WEBSOCKETD_POOL'):
+  os.environ.update(json.loads(stdin.readline()))
+
+print('Hello %s, what is your name?' % os.environ.get('REMOTE_ADDR'))
+stdout.flush() # Remember to flush
+
+# For each line FOO received on STDIN, respond with "Hello FOO!".
+while True:
+  line = stdin.readline().strip()
+  print('Hello %s!' % line)
+  stdout.flush()
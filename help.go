@@ -28,8 +28,38 @@ Usage:
   Or, export an entire directory of executables as WebSocket endpoints:
     {{binary}} [options] --dir=SOMEDIR
 
+  Or, export several programs, each on its own path:
+    {{binary}} [options] --route /PATH=COMMAND [--route /PATH=COMMAND ...]
+
+  Or, take options (and COMMAND) from a file:
+    {{binary}} --config=FILE [options] [COMMAND [command args]]
+
 Options:
 
+  --config=FILE                  Read option settings from a JSON file. Keys
+                                 are option names without the dashes; a list
+                                 sets a repeatable option once per entry, and
+                                 "command" holds COMMAND and its args, e.g.
+                                   {"port": 8080,
+                                    "origin": ["https://example.com"],
+                                    "header": ["Server: ws/1"],
+                                    "command": ["./chat.py", "--room=1"]}
+                                 Options on the command line (and a COMMAND
+                                 given there) override the file. Relative
+                                 paths are resolved from the working
+                                 directory, as they are for options.
+                                 On SIGHUP the file is read again and its
+                                 origin, sameorigin, header, header-ws,
+                                 header-http and maxforks settings apply to
+                                 new connections; other settings need a
+                                 restart. A file that fails to load leaves
+                                 the previous settings in place.
+                                 With --dir, "routes" gives the scripts at
+                                 and below a path their own binary,
+                                 passstderr, closems, pingms, maxframesize
+                                 and header-ws settings, e.g.
+                                   "routes": {"/video": {"binary": true}}
+
   --port=PORT                    HTTP port to listen on.
 
   --address=ADDRESS              Address to bind to (multiple options allowed)
@@ -60,19 +90,89 @@ Options:
                                  origins; prefix "https://" to require TLS.
                                  Default: "" (allow any origin)
 
+  --auth=KIND:ARG                Authenticate WebSocket upgrades before
+                                 starting a process, which then sees the user
+                                 in REMOTE_USER and AUTH_TYPE:
+                                   basic:FILE    HTTP Basic, against an
+                                                 htpasswd file (bcrypt, MD5
+                                                 or SHA-1 hashes)
+                                   jwt:FILE      bearer JWT (Authorization
+                                                 header or ?access_token=),
+                                                 signed by a key in a JWKS
+                                                 file; the user is "sub"
+                                   command:PATH  run PATH with the request
+                                                 in CGI variables; exit 0
+                                                 admits the user it prints
+                                   url:URL       GET URL with the client's
+                                                 Authorization and Cookie;
+                                                 2xx admits the user in its
+                                                 Remote-User header
+                                 Refusals are 401 or 403. Files are read again
+                                 on SIGHUP. Default: "" (no authentication)
+  --authrealm=REALM              Realm of the 401 challenge. Default: websocketd
+  --jwtissuer=ISS                With --auth=jwt:..., require this "iss" claim.
+  --jwtaudience=AUD              With --auth=jwt:..., require this in "aud".
+
   --ssl                          Listen for HTTPS socket instead of HTTP.
   --sslcert=FILE                 All three options must be used or all of
   --sslkey=FILE                  them should be omitted.
 
   --sslca=FILE                   Require clients to present a certificate
                                  signed by this CA (mutual TLS). Only takes
-                                 effect together with --ssl.
+                                 effect together with --ssl. Processes see
+                                 who connected in SSL_CLIENT_S_DN,
+                                 SSL_CLIENT_S_DN_CN, SSL_CLIENT_I_DN,
+                                 SSL_CLIENT_SERIAL and SSL_CLIENT_FINGERPRINT.
+
+  --sslclientauth=require|optional
+                                 With --sslca, whether every client must
+                                 present a certificate (require), or only
+                                 those using a --certrule path (optional).
+                                 Default: require
+  --certrule=PATH[:PATTERN]      Refuse (HTTP 403) WebSocket upgrades to the
+                                 scripts at and below PATH without a verified
+                                 client certificate. With PATTERN ("*" is a
+                                 wildcard), the certificate's subject DN, CN
+                                 or a SAN must also match it. Repeat for more
+                                 paths or patterns; the longest PATH applies.
+
+  --acme=DOMAIN[,DOMAIN...]      Get the TLS certificate for these domains
+                                 from an ACME CA (Let's Encrypt by default)
+                                 instead of --sslcert and --sslkey, and renew
+                                 it before it expires. Implies --ssl. The CA
+                                 must reach this server on port 443 (TLS-ALPN)
+                                 or, with --redirport=80, on port 80 (HTTP).
+  --acmeemail=EMAIL              Contact address for the ACME account.
+  --acmecache=DIR                Where to keep the account key and
+                                 certificates. Default: websocketd/acme in
+                                 the user's cache directory
+  --acmedirectory=URL            ACME directory of the CA. Default:
+                                 https://acme-v02.api.letsencrypt.org/directory
+  --acmeca=FILE                  Trust these CA certificates for the ACME
+                                 directory's own HTTPS (e.g. a test CA's).
+
+                                 On SIGHUP, the --sslcert, --sslkey and --sslca
+                                 files are read again and used for new
+                                 connections (e.g. after a certificate
+                                 renewal). If any fails to load, the previous
+                                 ones stay in use.
 
   --redirport=PORT               Open alternative port and redirect HTTP traffic
                                  from it to canonical address (mostly useful
                                  for HTTPS-only configurations to redirect HTTP
                                  traffic)
 
+  --trustedproxy=ADDR[,ADDR...]  Reverse proxies (IP addresses or CIDR
+                                 networks) whose Forwarded or X-Forwarded-For,
+                                 -Host and -Proto headers are believed: the
+                                 process, log, origin checks and per-client
+                                 limits then see the client's address, host
+                                 and scheme instead of the proxy's.
+
+  --proxyprotocol                Expect a PROXY protocol (v1 or v2) header on
+                                 TCP connections from --trustedproxy
+                                 addresses, giving the client's address.
+
   --passenv VAR[,VAR...]         Lists environment variables allowed to be
                                  passed to executed scripts. Does not work for
                                  Windows since all the variables are kept there.
@@ -86,8 +186,44 @@ Options:
                                  clients, tagged (alongside STDOUT) as JSON:
                                  {"stream":"stdout","data":"..."} or
                                  {"stream":"stderr","data":"..."}. STDERR is
-                                 still logged server-side either way. Cannot
-                                 be combined with --binary. Default: false
+                                 still logged server-side either way. With
+                                 --binary, STDOUT stays binary and STDERR
+                                 lines are sent as text messages.
+                                 Default: false
+
+  --streambyte                   With --binary --passstderr, send STDERR as
+                                 binary messages too, every message starting
+                                 with a byte for its stream: 1 for STDOUT, 2
+                                 for STDERR. Default: false
+
+  --envelope=ENVELOPE            The JSON messages of --passstderr: basic,
+                                 or extended, which adds "seq" (numbered
+                                 from 1) and "time", and ends the session
+                                 with {"stream":"exit","code":N} and, if
+                                 the process was killed, "signal".
+                                 Default: basic
+
+  --rawstdout                    With --passstderr, send STDOUT as it is
+                                 and wrap only STDERR in JSON.
+                                 Default: false
+
+  --framing=FRAMING              How messages are delimited on the process's
+                                 STDIN and STDOUT, one WebSocket message per
+                                 record either way:
+                                   lines      one per line
+                                   delim:C    ended by the byte C, e.g. \0
+                                              or \x1e
+                                   netstring  LENGTH:DATA,
+                                   length     a 4-byte big-endian length,
+                                              then the data
+                                   jsonlines  a JSON string per line
+                                 Default: lines
+
+  --mixed                        Relay both text and binary messages, each
+                                 record on STDIN and STDOUT starting with its
+                                 type: "t" for text or "b" for binary. Needs
+                                 --framing=delim:C, netstring or length.
+                                 Default: false
 
   --reverselookup={true,false}   Perform DNS reverse lookups on remote clients.
                                  Default: false
@@ -97,6 +233,15 @@ Options:
                                  option, then the standard program and args
                                  options should not be specified.
 
+  --route=/PATH=COMMAND          Serve WebSocket connections to PATH and the
+                                 paths below it with COMMAND, which may have
+                                 arguments (split as a shell would, without
+                                 expanding anything). Repeat for more paths;
+                                 the longest PATH applies. Routes come before
+                                 a COMMAND or --dir, which serve the rest. In
+                                 the --config file's "routes", a route can
+                                 have its own "command" and settings.
+
   --staticdir=DIR                Serve static files in this directory over HTTP.
 
   --cgidir=DIR                   Serve CGI scripts in this directory over HTTP.
@@ -112,6 +257,100 @@ Options:
                                  gates only WS upgrades and CGI execs, not
                                  static/redirect requests.
 
+  --poolmin=N                    Keep N processes of each WebSocket command
+                                 started ahead of sessions, so a session
+                                 does not wait for its process to start.
+                                 Such a process has WEBSOCKETD_POOL=1 set and
+                                 reads the session's variables (REMOTE_ADDR,
+                                 QUERY_STRING, HTTP_*, ...) as a JSON object
+                                 on the first line of stdin. Spares do not
+                                 count towards --maxforks.
+                                 Default: 0 (one process started per session)
+
+  --poolmax=N                    Let the number of spares grow to N while
+                                 sessions find none ready, shrinking back
+                                 to --poolmin once they stop.
+                                 Default: --poolmin
+
+  --multiplex                    Run one process per command for all of its
+                                 sessions, rather than one per session. Each
+                                 line on its STDIN and STDOUT is a JSON
+                                 object about one session, e.g.
+                                   {"event":"message","id":"1","data":"hi"}
+                                 It is sent connect (with the session's
+                                 variables as "env"), message, pause, resume
+                                 and disconnect events, and writes message,
+                                 or disconnect to close a session. With
+                                 --binary, data is base64. The process is
+                                 started for the first session and again
+                                 after it exits. Default: false
+
+  --broadcast                    Run one process per command and send each
+                                 message it writes to every client, e.g. for
+                                 a single "tail -F" behind a live dashboard.
+                                 The process is started with the server (or
+                                 for the first client of a --dir script),
+                                 has WEBSOCKETD_BROADCAST=1 set and none of
+                                 the clients' variables, and is started again
+                                 for the next client after it exits.
+                                 Default: false
+
+  --broadcastbuffer=N            Messages a --broadcast client may fall behind
+                                 before --slowclient applies. Default: 256
+
+  --slowclient={drop,close}      What to do with a --broadcast client further
+                                 behind: drop messages for it until it
+                                 catches up, or close it with 1013.
+                                 Default: drop
+
+  --broadcastinput               Write --broadcast clients' messages to the
+                                 process's STDIN; otherwise they are ignored.
+                                 Default: false
+
+  --resumems=N                   Keep a session's process for N milliseconds
+                                 after its client drops without a close frame,
+                                 for the client to reconnect to with the token
+                                 it was sent first (?websocketd_resume=TOKEN).
+                                 Default: 0 (the process ends with the
+                                 connection)
+
+  --resumebuffer=N               Messages to keep for a client that has gone
+                                 away with --resumems; older ones are dropped.
+                                 Default: 256
+
+  --inmsgrate=N                  Limit each session to N messages, or N
+  --inbyterate=N                 bytes, per second from client to process.
+                                 Each allows a burst of one second's worth;
+                                 a byte rate's is at least --maxframesize
+                                 in, 10 MiB out, so one message is never
+                                 over it on its own. Default: 0 (unlimited)
+
+  --outmsgrate=N                 The same, from process to client.
+  --outbyterate=N
+
+  --ratemode=MODE                What to do with a session over a rate:
+                                 throttle  hold messages back (backpressure)
+                                 close     close with 1008 (policy violation)
+                                 Default: throttle
+
+  --maxclientconns=N             Limit the WebSocket sessions one client may
+                                 have open at once; more get 429 with a
+                                 Retry-After header. Default: 0 (unlimited)
+
+  --clientrate=R                 Limit one client to opening R WebSocket
+                                 sessions per second on average (e.g. 0.2);
+                                 faster upgrades get 429 with Retry-After.
+                                 Default: 0 (unlimited)
+
+  --clientburst=N                Sessions a client may open in quick
+                                 succession before --clientrate applies.
+                                 Default: one second's worth, at least 1
+
+  --clientprefix4=BITS           Count each IPv4 /BITS or IPv6 /BITS network
+  --clientprefix6=BITS           as one client for the two limits above.
+                                 Defaults: 32 and 64. Unix socket clients
+                                 are not limited.
+
   --maxframesize=bytes           Reject inbound WebSocket messages larger than
                                  this, closing the connection (bounds per-client
                                  memory use). Default: 1048576 (1 MiB). Set 0 to
@@ -127,6 +366,39 @@ Options:
                                  connections that miss pongs for twice that long,
                                  detecting dead clients. Default: 0 (disabled)
 
+  --drainms=milliseconds         On SIGINT or SIGTERM, stop accepting connections,
+                                 close every WebSocket session and terminate its
+                                 process (as --closems describes), waiting at most
+                                 this long before killing whatever is still
+                                 running. A second signal stops waiting at once.
+                                 websocketd then exits 0 if every session ended
+                                 in time, or 5 if processes had to be killed.
+                                 Default: 5000
+
+  --goingaway={true,false}       When shutting down on SIGINT or SIGTERM, send
+                                 each client a 1001 (going away) close frame
+                                 before closing its connection. Default: true
+
+  --closecodes=STATUS=CODE,...   Close code sent to the client when its process
+                                 exits first, by exit status: an exit code,
+                                 "signal" (killed by one) or "*" (anything
+                                 else). Codes must be sendable: 1000-1003,
+                                 1007-1014 or 3000-4999. An empty list sends no
+                                 close frame. Default: 0=1000,*=1011
+
+  --closereason                  Use the last line the process wrote to STDERR
+                                 (cut to 123 bytes) as the close reason.
+                                 Default: false
+
+  --notifyclose                  When the client closes first, write its close
+                                 code and reason to the process's STDIN as one
+                                 last line before closing STDIN:
+                                 {"websocketd":"close","code":1000,"reason":""}
+                                 Code 1006 means it went away without a close
+                                 frame. A client can send the same text, but
+                                 only the real notice is followed by EOF.
+                                 Default: false
+
   --header="..."                 Set custom HTTP header to each answer. For
                                  example: --header="Server: someserver/0.0.1"
 
@@ -159,6 +431,42 @@ Options:
                                  From most to least verbose:
                                  debug, trace, access, info, error, fatal
 
+  --logformat=FORMAT             Log line format (default text):
+                                 text    human-readable, pipe-delimited
+                                 json    one JSON object per line with
+                                         timestamp, level, category, message
+                                         and the session's fields (id,
+                                         remote, url, command, pid, ...)
+                                 logfmt  the same fields as key=value pairs
+
+  --logfile=FILE                 Append the log to FILE instead of stdout.
+
+  --accesslog=FILE               Append access-level lines (CONNECT,
+                                 DISCONNECT, NOT FOUND, ...) to FILE instead
+                                 of the log, so they can be shipped apart
+                                 from errors.
+
+  --logmaxsize=MB                Rotate --logfile/--accesslog before it grows
+                                 past MB megabytes. Default: 0 (never)
+
+  --logrotate=PERIOD             Rotate --logfile/--accesslog hourly or daily
+                                 (local time). Rotated files get a timestamp
+                                 suffix, e.g. ws.log.2026-10-17T09-00-00.000
+
+  --logbackups=N                 Keep only the N newest rotated files.
+                                 Default: 0 (keep all)
+
+                                 SIGHUP reopens both files, for tools such as
+                                 logrotate that move them aside themselves.
+
+  --metrics=ADDRESS              Serve Prometheus metrics at /metrics on a
+                                 separate plain HTTP listener at ADDRESS, e.g.
+                                 127.0.0.1:9100. Reports live sessions, fork
+                                 usage, upgrades by result, messages and bytes
+                                 in each direction, process exit codes and the
+                                 termination step that ended each process,
+                                 and CGI/static request counts.
+
 Full documentation at https://websocketd.com/
 
 Copyright 2013 Joe Walnes and the websocketd team. All rights reserved.
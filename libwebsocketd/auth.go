@@ -0,0 +1,314 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// authTimeout bounds an --auth command or subrequest, which the upgrade
+// waits on.
+const authTimeout = 5 * time.Second
+
+// Authenticator decides who is asking for a WebSocket session (--auth),
+// before a process is started for it.
+type Authenticator interface {
+	// Authenticate returns who made req, or an error: an *AuthError to
+	// refuse the request, anything else if it could not be checked.
+	Authenticate(req *http.Request) (Identity, error)
+}
+
+// Identity is an authenticated client, as the process sees it in the
+// REMOTE_USER and AUTH_TYPE environment variables.
+type Identity struct {
+	User string // REMOTE_USER; may be empty when an external check names nobody
+	Type string // AUTH_TYPE: "Basic", "Bearer", or the scheme an external check was given
+}
+
+// AuthError refuses a request. Status is 401 or 403; a 401 carries the
+// WWW-Authenticate Challenge. Reason is for the log, never the client.
+type AuthError struct {
+	Status    int
+	Challenge string
+	Reason    string
+}
+
+func (e *AuthError) Error() string {
+	return e.Reason
+}
+
+// AuthOptions are the settings an Authenticator may need besides its --auth
+// spec.
+type AuthOptions struct {
+	Realm    string   // realm of WWW-Authenticate challenges
+	Issuer   string   // JWT "iss" claim to require, if not empty
+	Audience string   // JWT "aud" value to require, if not empty
+	Env      []string // environment for an auth command, before the request's variables
+}
+
+// NewAuthenticator builds the Authenticator an --auth spec describes:
+//
+//	basic:FILE     HTTP Basic, checked against an htpasswd file
+//	jwt:FILE       bearer JWTs, verified with the keys in a JWKS file
+//	command:PATH   run PATH; exit status 0 admits, and stdout names the user
+//	url:URL        GET URL with the client's credentials; 2xx admits
+func NewAuthenticator(spec string, opts AuthOptions) (Authenticator, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("%q is not basic:FILE, jwt:FILE, command:PATH or url:URL", spec)
+	}
+	switch kind {
+	case "basic":
+		a := &basicAuth{file: arg, realm: opts.Realm}
+		return a, a.reload()
+	case "jwt":
+		a := &jwtAuth{file: arg, realm: opts.Realm, issuer: opts.Issuer, audience: opts.Audience, now: time.Now}
+		return a, a.reload()
+	case "command":
+		path, err := exec.LookPath(arg)
+		if err != nil {
+			return nil, fmt.Errorf("auth command: %w", err)
+		}
+		return &commandAuth{path: path, env: opts.Env}, nil
+	case "url":
+		if !strings.HasPrefix(arg, "http://") && !strings.HasPrefix(arg, "https://") {
+			return nil, fmt.Errorf("auth URL %q must be http:// or https://", arg)
+		}
+		return newURLAuth(arg), nil
+	}
+	return nil, fmt.Errorf("unknown authentication %q; use basic, jwt, command or url", kind)
+}
+
+// ReloadAuth re-reads the file behind a, for SIGHUP: the htpasswd file of
+// basic: or the JWKS of jwt:. On error a keeps what it had.
+func ReloadAuth(a Authenticator) error {
+	if r, ok := a.(interface{ reload() error }); ok {
+		return r.reload()
+	}
+	return nil
+}
+
+// identityKey is the context key of the Identity an upgrade request was
+// authenticated as.
+type identityKey struct{}
+
+// authIdentity returns who req was authenticated as, if anyone.
+func authIdentity(req *http.Request) (Identity, bool) {
+	id, ok := req.Context().Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// authenticate applies config.Auth to a WebSocket upgrade. If the request is
+// refused it answers it and returns false; otherwise it returns req carrying
+// the Identity for createEnv.
+func (h *WebsocketdServer) authenticate(w http.ResponseWriter, req *http.Request, config *Config, log *LogScope) (*http.Request, bool) {
+	if config.Auth == nil {
+		return req, true
+	}
+	id, err := config.Auth.Authenticate(req)
+	if err != nil {
+		var refused *AuthError
+		if !errors.As(err, &refused) {
+			h.metrics.countUpgrade(upgradeError)
+			log.Error("session", "Could not authenticate: %s", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return nil, false
+		}
+		if refused.Status == http.StatusUnauthorized {
+			h.metrics.countUpgrade(upgradeUnauthorized)
+			log.Access("session", "UNAUTHORIZED: %s", refused.Reason)
+			if refused.Challenge != "" {
+				w.Header().Set("WWW-Authenticate", refused.Challenge)
+			}
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+		} else {
+			h.metrics.countUpgrade(upgradeForbidden)
+			log.Access("session", "FORBIDDEN: %s", refused.Reason)
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+		}
+		return nil, false
+	}
+	if id.User != "" {
+		log.Associate("user", id.User)
+	}
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, id)), true
+}
+
+// externalAuthType is the AUTH_TYPE reported for a request an auth command
+// or subrequest admitted: the scheme of its Authorization header, if any.
+func externalAuthType(req *http.Request) string {
+	scheme, _, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if scheme == "" {
+		return "External"
+	}
+	return scheme
+}
+
+// commandAuth runs a program (command:PATH) to decide. It gets the CGI
+// variables describing the request: REMOTE_ADDR, REQUEST_METHOD,
+// REQUEST_URI, QUERY_STRING and the headers as HTTP_*. Exit status 0 admits
+// the client as the user named on the first line of stdout (which may be
+// empty); any other status refuses it with 403.
+type commandAuth struct {
+	path string
+	env  []string
+}
+
+func (a *commandAuth) Authenticate(req *http.Request) (Identity, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), authTimeout)
+	defer cancel()
+
+	env := append([]string(nil), a.env...)
+	env = appendEnv(env, "REMOTE_ADDR", remoteHost(req.RemoteAddr))
+	env = appendEnv(env, "REQUEST_METHOD", req.Method)
+	env = appendEnv(env, "REQUEST_URI", redactSecrets(req.RequestURI))
+	env = appendEnv(env, "QUERY_STRING", redactSecrets(req.URL.RawQuery))
+	for k, v := range req.Header {
+		env = appendEnv(env, "HTTP_"+dashReplacer.Replace(k), v...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.path)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && ctx.Err() == nil {
+		reason := fmt.Sprintf("auth command exited with status %d", exit.ExitCode())
+		if msg := lastLine(stderr.String()); msg != "" {
+			reason += ": " + msg
+		}
+		return Identity{}, &AuthError{Status: http.StatusForbidden, Reason: reason}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("auth command did not finish within %v", authTimeout)
+		}
+		return Identity{}, err
+	}
+	user, _, _ := strings.Cut(stdout.String(), "\n")
+	return Identity{User: strings.TrimSpace(user), Type: externalAuthType(req)}, nil
+}
+
+// secretParams are the query parameters whose values redactSecrets hides:
+// a bearer token (which jwt: takes from the query, as browsers cannot set
+// headers on a WebSocket), and a resume token, which would let whoever saw
+// it take over the session.
+var secretParams = []string{"access_token", ResumeParam}
+
+// redactSecrets replaces the value of any of secretParams in a query string,
+// or in a request URI's query, so that it is not logged or passed on.
+func redactSecrets(s string) string {
+	path, query, hasPath := strings.Cut(s, "?")
+	if !hasPath {
+		path, query = "", s
+	}
+	params := strings.Split(query, "&")
+	redacted := false
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil && isSecretParam(k) {
+			params[i] = key + "=REDACTED"
+			redacted = true
+		}
+	}
+	if !redacted {
+		return s
+	}
+	if !hasPath {
+		return strings.Join(params, "&")
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+func isSecretParam(key string) bool {
+	for _, p := range secretParams {
+		if key == p {
+			return true
+		}
+	}
+	return false
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	return strings.TrimSpace(s[strings.LastIndexByte(s, '\n')+1:])
+}
+
+func remoteHost(remoteAddr string) string {
+	if ip := remoteIP(remoteAddr); ip != nil {
+		return ip.String()
+	}
+	return remoteAddr
+}
+
+// urlAuth asks an HTTP service (url:URL), as nginx's auth_request does. The
+// subrequest is a GET carrying the client's Authorization and Cookie
+// headers, with X-Original-URI, X-Original-Method and X-Real-IP describing
+// the upgrade. A 2xx answer admits the client as the user its Remote-User
+// or X-Auth-Request-User header names; 401 and 403 are passed on to the
+// client, with the service's WWW-Authenticate challenge.
+type urlAuth struct {
+	url    string
+	client *http.Client
+}
+
+func newURLAuth(url string) *urlAuth {
+	return &urlAuth{url: url, client: &http.Client{
+		Timeout: authTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // a redirect to a login page is a refusal
+		},
+	}}
+}
+
+func (a *urlAuth) Authenticate(req *http.Request) (Identity, error) {
+	sub, err := http.NewRequestWithContext(req.Context(), http.MethodGet, a.url, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		for _, v := range req.Header.Values(name) {
+			sub.Header.Add(name, v)
+		}
+	}
+	sub.Header.Set("X-Original-URI", req.RequestURI)
+	sub.Header.Set("X-Original-Method", req.Method)
+	sub.Header.Set("X-Real-IP", remoteHost(req.RemoteAddr))
+
+	resp, err := a.client.Do(sub)
+	if err != nil {
+		return Identity{}, fmt.Errorf("auth subrequest: %w", err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		user := resp.Header.Get("Remote-User")
+		if user == "" {
+			user = resp.Header.Get("X-Auth-Request-User")
+		}
+		return Identity{User: user, Type: externalAuthType(req)}, nil
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return Identity{}, &AuthError{
+			Status:    resp.StatusCode,
+			Challenge: resp.Header.Get("WWW-Authenticate"),
+			Reason:    fmt.Sprintf("auth subrequest answered %s", resp.Status),
+		}
+	}
+	return Identity{}, fmt.Errorf("auth subrequest answered %s", resp.Status)
+}
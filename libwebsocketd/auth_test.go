@@ -0,0 +1,153 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticateUpgrade(t *testing.T) {
+	h, url := newTestServer(t, "/bin/sh", "-c", `echo "$REMOTE_USER $AUTH_TYPE ${HTTP_AUTHORIZATION:-hidden}"`)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	os.WriteFile(htpasswd, []byte("alice:"+string(hash)+"\n"), 0600)
+	auth, err := NewAuthenticator("basic:"+htpasswd, AuthOptions{Realm: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Config.Auth = auth
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without credentials: %v, %v", resp, err)
+	}
+	if got := resp.Header.Get("WWW-Authenticate"); got != `Basic realm="test", charset="UTF-8"` {
+		t.Errorf("WWW-Authenticate = %q", got)
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Basic YWxpY2U6d3Jvbmc=") // alice:wrong
+	if _, resp, _ := websocket.DefaultDialer.Dial(url, headers); resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial with a wrong password: %v", resp)
+	}
+
+	headers.Set("Authorization", "Basic YWxpY2U6c2VjcmV0") // alice:secret
+	client, _, err := websocket.DefaultDialer.Dial(url, headers)
+	if err != nil {
+		t.Fatalf("dial with credentials failed: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, msg, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "alice Basic hidden" {
+		t.Errorf("process saw %q, want REMOTE_USER, AUTH_TYPE and no password", msg)
+	}
+	expectMetric(t, scrape(t, h), `websocketd_upgrades_total{result="unauthorized"} 2`)
+}
+
+func TestCommandAuth(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("test uses POSIX commands")
+	}
+	script := filepath.Join(t.TempDir(), "auth")
+	os.WriteFile(script, []byte("#!/bin/sh\n"+
+		`[ "$HTTP_X_TOKEN" = good ] || { echo "bad token for $REQUEST_URI" >&2; exit 1; }`+"\n"+
+		"echo alice\n"), 0700)
+	a, err := NewAuthenticator("command:"+script, AuthOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/chat", nil)
+	req.Header.Set("X-Token", "good")
+	if id, err := a.Authenticate(req); err != nil || id != (Identity{User: "alice", Type: "External"}) {
+		t.Errorf("good token: got %+v, %v", id, err)
+	}
+
+	req.Header.Set("X-Token", "bad")
+	var refused *AuthError
+	_, err = a.Authenticate(req)
+	if !errors.As(err, &refused) || refused.Status != http.StatusForbidden || !strings.Contains(refused.Reason, "bad token for /chat") {
+		t.Errorf("bad token: got %v", err)
+	}
+}
+
+func TestURLAuth(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Original-URI") != "/chat?room=1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer good" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sso"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Remote-User", "alice")
+	}))
+	defer authServer.Close()
+	a, err := NewAuthenticator("url:"+authServer.URL, AuthOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/chat?room=1", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	if id, err := a.Authenticate(req); err != nil || id != (Identity{User: "alice", Type: "Bearer"}) {
+		t.Errorf("good token: got %+v, %v", id, err)
+	}
+
+	req.Header.Set("Authorization", "Bearer bad")
+	var refused *AuthError
+	_, err = a.Authenticate(req)
+	if !errors.As(err, &refused) || refused.Status != http.StatusUnauthorized || refused.Challenge != `Bearer realm="sso"` {
+		t.Errorf("bad token: got %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/elsewhere", nil)
+	if _, err := a.Authenticate(req); err == nil || errors.As(err, &refused) {
+		t.Errorf("400 from the auth service should be an error, not a refusal: %v", err)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                               "",
+		"/chat":                          "/chat",
+		"/chat?room=1":                   "/chat?room=1",
+		"/chat?access_token=abc":         "/chat?access_token=REDACTED",
+		"/chat?room=1&access_token=abc":  "/chat?room=1&access_token=REDACTED",
+		"/chat?access%5Ftoken=abc&x=1":   "/chat?access%5Ftoken=REDACTED&x=1",
+		"access_token=abc&access_token=": "access_token=REDACTED&access_token=REDACTED",
+		"my_access_token=abc":            "my_access_token=abc",
+		"/chat?websocketd_resume=abc":    "/chat?websocketd_resume=REDACTED",
+	} {
+		if got := redactSecrets(in); got != want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNewAuthenticatorErrors(t *testing.T) {
+	for _, spec := range []string{"basic", "basic:", "ldap:server", "basic:/nonexistent/htpasswd", "url:ftp://auth.example", "command:/nonexistent/auth"} {
+		if _, err := NewAuthenticator(spec, AuthOptions{}); err == nil {
+			t.Errorf("NewAuthenticator(%q) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// BroadcastEnvVar is set in the environment of a --broadcast process: the
+// one process of a command whose output goes to every client. It starts
+// before, or without, any one session, so it has none of their variables.
+const BroadcastEnvVar = "WEBSOCKETD_BROADCAST"
+
+// DefaultBroadcastBuffer is how many messages a client may fall behind a
+// --broadcast process when Config.BroadcastBuffer is not set.
+const DefaultBroadcastBuffer = 256
+
+// broadcaster runs one process of a command and sends each message it
+// writes to every client (--broadcast). A client more than the buffer behind
+// misses messages until it catches up, or is closed with BroadcastClose. The
+// process is started with the server if the command is known up front,
+// otherwise for the first client, and again for the next client after it
+// exits.
+type broadcaster struct {
+	command string
+	args    []string
+	env     []string
+	config  *Config // the settings the process runs with
+	log     *LogScope
+	metrics *metrics
+
+	wmu sync.Mutex // held while a client's message is written to the process
+
+	mu      sync.Mutex
+	process *sharedProcess // nil while none is running
+	clients map[*broadcastClient]struct{}
+	closed  bool
+}
+
+// startLocked launches the process if none is running.
+func (b *broadcaster) startLocked() (*sharedProcess, error) {
+	if b.process == nil {
+		p, err := startSharedProcess(b.command, b.args, b.env, b.config, b.config.Binary || b.config.Mixed, b.config.PassStderr, b.config.Framing, b.log, b.metrics)
+		if err != nil {
+			return nil, err
+		}
+		b.process = p
+		go b.read(p)
+	}
+	return b.process, nil
+}
+
+// attach adds a client, starting the process if none is running.
+func (b *broadcaster) attach(log *LogScope) (*broadcastClient, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrShuttingDown
+	}
+	p, err := b.startLocked()
+	if err != nil {
+		return nil, err
+	}
+	size := b.config.BroadcastBuffer
+	if size <= 0 {
+		size = DefaultBroadcastBuffer
+	}
+	c := &broadcastClient{broadcaster: b, process: p, log: log, queue: make(chan []byte, size)}
+	if b.clients == nil {
+		b.clients = make(map[*broadcastClient]struct{})
+	}
+	b.clients[c] = struct{}{}
+	return c, nil
+}
+
+// read sends each message of the process to every client until its output
+// closes, then stops it and ends the clients it was serving.
+func (b *broadcaster) read(p *sharedProcess) {
+	for msg := range p.Output() {
+		b.fanOut(p, msg)
+	}
+	p.stop()
+	b.log.Info("process", "Shared process %d for %s ended (%s)", p.process.cmd.Process.Pid, b.command, p.ExitStatus())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.process == p {
+		b.process = nil
+	}
+	for c := range b.clients {
+		if c.process == p {
+			b.endLocked(c)
+		}
+	}
+}
+
+func (b *broadcaster) fanOut(p *sharedProcess, msg []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		if c.process != p {
+			continue
+		}
+		select {
+		case c.queue <- msg:
+			c.behind = false
+			continue
+		default:
+		}
+		if b.config.BroadcastClose {
+			c.log.Error("process", "Closing client %d messages behind", len(c.queue))
+			c.closeCode = websocket.CloseTryAgainLater
+			b.endLocked(c)
+			continue
+		}
+		if !c.behind {
+			c.log.Info("process", "Client %d messages behind, dropping messages until it catches up", len(c.queue))
+			c.behind = true
+		}
+		b.metrics.countDropped()
+	}
+}
+
+// endLocked stops sending to c: it gets what is queued, then its session
+// closes.
+func (b *broadcaster) endLocked(c *broadcastClient) {
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c.queue)
+	}
+}
+
+// warm starts the process, so that it runs from startup.
+func (b *broadcaster) warm() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		if _, err := b.startLocked(); err != nil {
+			b.log.Error("process", "Could not launch process %s (%s)", b.command, err)
+		}
+	}
+}
+
+// close stops the process, if running, and refuses clients from then on.
+func (b *broadcaster) close() {
+	b.mu.Lock()
+	b.closed = true
+	p := b.process
+	b.mu.Unlock()
+	if p != nil {
+		p.stop()
+	}
+}
+
+// kill ends the process, if running, immediately.
+func (b *broadcaster) kill() {
+	b.mu.Lock()
+	p := b.process
+	b.mu.Unlock()
+	if p != nil {
+		p.Kill()
+	}
+}
+
+// broadcastClient is the Endpoint of one session of a --broadcast process.
+type broadcastClient struct {
+	broadcaster *broadcaster
+	process     *sharedProcess // the process the client receives from
+	log         *LogScope
+	queue       chan []byte // sent to and closed with broadcaster.mu held
+	behind      bool        // messages are being dropped; guarded by broadcaster.mu
+	closeCode   int         // close code when closed for falling behind; guarded by broadcaster.mu
+}
+
+func (c *broadcastClient) StartReading() {}
+
+func (c *broadcastClient) Output() chan []byte {
+	return c.queue
+}
+
+// Send writes the client's message to the process with BroadcastInput, and
+// otherwise ignores it.
+func (c *broadcastClient) Send(msg []byte) bool {
+	b := c.broadcaster
+	if !b.config.BroadcastInput {
+		return true
+	}
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	return c.process.Send(msg)
+}
+
+func (c *broadcastClient) Terminate() {
+	b := c.broadcaster
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endLocked(c)
+}
+
+// closedWith returns the close code for a client closed for falling
+// behind, or 0.
+func (c *broadcastClient) closedWith() int {
+	b := c.broadcaster
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return c.closeCode
+}
+
+// broadcasters holds a broadcaster for each command that has been used,
+// while Config.Broadcast is set.
+type broadcasters struct {
+	mu     sync.Mutex
+	casts  map[string]*broadcaster
+	closed bool
+}
+
+// get returns the broadcaster for command and args, creating it on first
+// use with the settings in config, or nil if broadcasting is off or the
+// server is shutting down.
+func (bb *broadcasters) get(command string, args []string, config *Config, log *LogScope, metrics *metrics) *broadcaster {
+	if !config.Broadcast {
+		return nil
+	}
+	key := commandKey(command, args)
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	if bb.closed {
+		return nil
+	}
+	b := bb.casts[key]
+	if b == nil {
+		if bb.casts == nil {
+			bb.casts = make(map[string]*broadcaster)
+		}
+		b = &broadcaster{command: command, args: args, env: sharedEnv(config, BroadcastEnvVar), config: config, log: log, metrics: metrics}
+		bb.casts[key] = b
+	}
+	return b
+}
+
+// warm starts the processes of the commands known up front, COMMAND and
+// those of --route; --dir scripts start with their first client.
+func (bb *broadcasters) warm(config *Config, log *LogScope, metrics *metrics) {
+	if config.CommandName != "" {
+		if b := bb.get(config.CommandName, config.CommandArgs, config, log, metrics); b != nil {
+			b.warm()
+		}
+	}
+	for _, r := range config.Routes {
+		if r.Command != "" {
+			if b := bb.get(r.Command, r.Args, config.forScript(r.Path), log, metrics); b != nil {
+				b.warm()
+			}
+		}
+	}
+}
+
+// close stops every process, returning once they have ended; get returns
+// nil from then on.
+func (bb *broadcasters) close() {
+	bb.mu.Lock()
+	bb.closed = true
+	casts := make([]*broadcaster, 0, len(bb.casts))
+	for _, b := range bb.casts {
+		casts = append(casts, b)
+	}
+	bb.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, b := range casts {
+		wg.Add(1)
+		go func(b *broadcaster) {
+			defer wg.Done()
+			b.close()
+		}(b)
+	}
+	wg.Wait()
+}
+
+// kill ends every process immediately.
+func (bb *broadcasters) kill() {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+	for _, b := range bb.casts {
+		b.kill()
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"context"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcast(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	config := &Config{
+		CommandName:      "/bin/sh",
+		CommandArgs:      []string{"-c", `echo "started $WEBSOCKETD_BROADCAST"; while read -r line; do echo "$line"; done`},
+		HandshakeTimeout: time.Second,
+		Broadcast:        true,
+		BroadcastInput:   true,
+	}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	b := h.casts.get(config.CommandName, config.CommandArgs, config, h.Log, h.metrics)
+	b.mu.Lock()
+	p := b.process
+	b.mu.Unlock()
+	if p == nil {
+		t.Fatal("process was not started with the server")
+	}
+	// Nobody was connected to hear it.
+	time.Sleep(100 * time.Millisecond)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/"
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return conn
+	}
+	a, c := dial(), dial()
+	waitForClients := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			b.mu.Lock()
+			got := len(b.clients)
+			b.mu.Unlock()
+			if got == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d clients attached, want %d", got, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForClients(2)
+
+	a.WriteMessage(websocket.TextMessage, []byte("from a"))
+	for _, conn := range []*websocket.Conn{a, c} {
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "from a" {
+			t.Errorf("got %q, %v; want what a sent, and not the startup line", msg, err)
+		}
+	}
+
+	a.Close()
+	waitForClients(1)
+	c.WriteMessage(websocket.TextMessage, []byte("from c"))
+	if _, msg, err := c.ReadMessage(); err != nil || string(msg) != "from c" {
+		t.Errorf("got %q, %v", msg, err)
+	}
+
+	if err := h.Shutdown(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if p.ExitState() == nil {
+		t.Errorf("process still running after Shutdown")
+	}
+}
+
+func TestBroadcastSlowClient(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	// Writes 50 lines, then waits for stdin to close.
+	const flood = `i=0; while [ $i -lt 50 ]; do echo $i; i=$((i + 1)); done; exec cat`
+	attach := func(t *testing.T, close bool) (*broadcastClient, *metrics) {
+		config := &Config{Broadcast: true, BroadcastBuffer: 10, BroadcastClose: close}
+		m := newMetrics()
+		b := (&broadcasters{}).get("/bin/sh", []string{"-c", flood}, config, quietLogScope(), m)
+		t.Cleanup(b.close)
+		c, err := b.attach(quietLogScope())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, m
+	}
+	poll := func(t *testing.T, what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		c, m := attach(t, false)
+		poll(t, "drops", func() bool { return m.dropped.Load() == 40 })
+		for i := 0; i < 10; i++ {
+			if msg := <-c.Output(); string(msg) != strconv.Itoa(i) {
+				t.Fatalf("message %d is %q", i, msg)
+			}
+		}
+		if code := c.closedWith(); code != 0 {
+			t.Errorf("client closed with %d, want it kept", code)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		c, m := attach(t, true)
+		poll(t, "the client to be closed", func() bool { return c.closedWith() != 0 })
+		if code := c.closedWith(); code != websocket.CloseTryAgainLater {
+			t.Errorf("client closed with %d, want %d", code, websocket.CloseTryAgainLater)
+		}
+		n := 0
+		for range c.Output() {
+			n++
+		}
+		if n != 10 || m.dropped.Load() != 0 {
+			t.Errorf("client got %d messages and %d were dropped, want 10 and none", n, m.dropped.Load())
+		}
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CertRule requires a verified client certificate for the WebSocket
+// scripts at and below Path (--certrule). If there are Patterns, the
+// certificate must also match one of them.
+type CertRule struct {
+	Path     string   // a script path, as URLInfo.ScriptPath
+	Patterns []string // "*" wildcards, matched against the subject's DN and CN and each SAN
+}
+
+// ParseCertRules parses --certrule values, "PATH" or "PATH:PATTERN". Rules
+// for the same path are merged, a certificate matching any of their
+// patterns being allowed; a bare PATH allows any verified certificate. The
+// rules come back longest path first, the order certRuleFor tries them in.
+func ParseCertRules(values []string) ([]CertRule, error) {
+	byPath := make(map[string]*CertRule)
+	anyCert := make(map[string]bool)
+	for _, v := range values {
+		p, pattern, hasPattern := strings.Cut(v, ":")
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("rule %q: path must start with /", v)
+		}
+		if p != "/" {
+			p = strings.TrimSuffix(p, "/")
+		}
+		if hasPattern && pattern == "" {
+			return nil, fmt.Errorf("rule %q: empty pattern", v)
+		}
+		r := byPath[p]
+		if r == nil {
+			r = &CertRule{Path: p}
+			byPath[p] = r
+		}
+		if hasPattern {
+			r.Patterns = append(r.Patterns, pattern)
+		} else {
+			anyCert[p] = true
+		}
+	}
+	rules := make([]CertRule, 0, len(byPath))
+	for p, r := range byPath {
+		if anyCert[p] {
+			r.Patterns = nil
+		}
+		rules = append(rules, *r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return len(rules[i].Path) > len(rules[j].Path) })
+	return rules, nil
+}
+
+// certRuleFor returns the rule covering scriptPath, the one with the
+// longest path at or above it, or nil if none does.
+func certRuleFor(scriptPath string, rules []CertRule) *CertRule {
+	for i, r := range rules {
+		if pathCovers(r.Path, scriptPath) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// checkClientCert applies the rule covering scriptPath, if any, to the
+// client certificate of the connection described by state (nil without TLS).
+func checkClientCert(state *tls.ConnectionState, scriptPath string, rules []CertRule) error {
+	rule := certRuleFor(scriptPath, rules)
+	if rule == nil {
+		return nil
+	}
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return fmt.Errorf("%s needs a verified client certificate", scriptPath)
+	}
+	if len(rule.Patterns) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	names := certNames(cert)
+	for _, pattern := range rule.Patterns {
+		for _, name := range names {
+			if wildcardMatch(pattern, name) {
+				return nil
+			}
+		}
+	}
+	return errors.New(scriptPath + " does not allow client certificate " + cert.Subject.String())
+}
+
+// certNames lists what a rule pattern may match in cert: the subject's
+// distinguished name (RFC 2253 form) and common name, and its DNS, email,
+// IP address and URI subject alternative names.
+func certNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// wildcardMatch reports whether s matches pattern, in which "*" stands for
+// any run of characters (including none, and including "/" and ".").
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
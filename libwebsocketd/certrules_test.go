@@ -0,0 +1,114 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestParseCertRules(t *testing.T) {
+	rules, err := ParseCertRules([]string{"/", "/admin/:CN=alice*", "/admin:*@example.com", "/ops", "/ops:bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CertRule{
+		{Path: "/admin", Patterns: []string{"CN=alice*", "*@example.com"}},
+		{Path: "/ops"}, // a bare path allows any verified certificate
+		{Path: "/"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %+v, want %+v", rules, want)
+	}
+	for i := range want {
+		if rules[i].Path != want[i].Path || len(rules[i].Patterns) != len(want[i].Patterns) {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"admin", "/admin:"} {
+		if _, err := ParseCertRules([]string{bad}); err == nil {
+			t.Errorf("ParseCertRules(%q) should fail", bad)
+		}
+	}
+}
+
+func TestCertRuleFor(t *testing.T) {
+	rules, _ := ParseCertRules([]string{"/admin", "/admin/deploy:ops"})
+	tests := []struct{ path, want string }{
+		{"/admin", "/admin"},
+		{"/admin/users", "/admin"},
+		{"/admin/deploy", "/admin/deploy"},
+		{"/administrator", ""},
+		{"/chat", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if r := certRuleFor(tt.path, rules); r != nil {
+			got = r.Path
+		}
+		if got != tt.want {
+			t.Errorf("certRuleFor(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckClientCert(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/deployer")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		EmailAddresses: []string{"alice@example.com"},
+		URIs:           []*url.URL{spiffe},
+	}
+	verified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	rules, _ := ParseCertRules([]string{"/any", "/mail:*@example.com", "/dn:CN=alice,O=*", "/spiffe:spiffe://example.org/ns/prod/*", "/bob:bob"})
+
+	tests := []struct {
+		path  string
+		state *tls.ConnectionState
+		ok    bool
+	}{
+		{"/open", nil, true},
+		{"/any", nil, false},
+		{"/any", &tls.ConnectionState{}, false},
+		{"/any", verified, true},
+		{"/mail", verified, true},
+		{"/dn", verified, true},
+		{"/spiffe/job", verified, true},
+		{"/bob", verified, false},
+	}
+	for _, tt := range tests {
+		err := checkClientCert(tt.state, tt.path, rules)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkClientCert(%s) = %v, want ok %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"alice", "alice", true},
+		{"alice", "alice2", false},
+		{"*", "", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false}, // prefix and suffix may not overlap
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
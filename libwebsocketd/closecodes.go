@@ -0,0 +1,108 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// CloseCodes maps how a process ended to the WebSocket close code sent to
+// its client. Keys are exit codes ("0", "2"), "signal" for a process killed
+// by a signal, and "*" for anything else.
+type CloseCodes map[string]int
+
+// DefaultCloseCodes is the --closecodes default: a clean exit is a normal
+// closure, anything else an internal error.
+const DefaultCloseCodes = "0=1000,*=1011"
+
+// ParseCloseCodes parses a comma-separated list of STATUS=CODE pairs, such
+// as DefaultCloseCodes. An empty list maps nothing, so no close frame is sent.
+func ParseCloseCodes(list string) (CloseCodes, error) {
+	codes := make(CloseCodes)
+	if list == "" {
+		return codes, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		status, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("close code mapping %q is not STATUS=CODE", pair)
+		}
+		if status != "*" && status != "signal" {
+			if n, err := strconv.Atoi(status); err != nil || n < 0 || n > 255 {
+				return nil, fmt.Errorf("close code mapping %q: status must be an exit code, \"signal\" or \"*\"", pair)
+			}
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil || !sendableCloseCode(code) {
+			return nil, fmt.Errorf("close code mapping %q: %s is not a close code that may be sent", pair, value)
+		}
+		codes[status] = code
+	}
+	return codes, nil
+}
+
+// sendableCloseCode reports whether code may appear in a close frame: the
+// codes RFC 6455 and its registry define for endpoints to send, and the
+// 3000-4999 ranges for libraries and applications. 1005, 1006 and 1015 are
+// reserved for reporting and must never be sent.
+func sendableCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// forExit returns the close code for a process that ended with state, or 0
+// if the mapping has none.
+func (c CloseCodes) forExit(state *os.ProcessState) int {
+	key := strconv.Itoa(state.ExitCode())
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		key = "signal"
+	}
+	if code, ok := c[key]; ok {
+		return code
+	}
+	return c["*"]
+}
+
+// maxCloseReason is the most a close frame can carry after its 2-byte code.
+const maxCloseReason = 123
+
+// truncateReason shortens reason to fit a close frame, without splitting a
+// UTF-8 sequence.
+func truncateReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	cut := maxCloseReason
+	for cut > 0 && !utf8.RuneStart(reason[cut]) {
+		cut--
+	}
+	return reason[:cut]
+}
+
+// closeEvent is the line --notifyclose writes to a process's stdin, just
+// before closing it, to say how the WebSocket connection ended.
+type closeEvent struct {
+	Event  string `json:"websocketd"` // always "close"
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func formatCloseNotice(code int, reason string) []byte {
+	// json.Marshal cannot fail on plain strings and ints.
+	msg, _ := json.Marshal(closeEvent{Event: "close", Code: code, Reason: reason})
+	return append(msg, '\n')
+}
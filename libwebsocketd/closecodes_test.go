@@ -0,0 +1,144 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseCloseCodes(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    CloseCodes
+		wantErr bool
+	}{
+		{DefaultCloseCodes, CloseCodes{"0": 1000, "*": 1011}, false},
+		{"", CloseCodes{}, false},
+		{"0=1000, 2=4002, signal=1001", CloseCodes{"0": 1000, "2": 4002, "signal": 1001}, false},
+		{"0", nil, true},
+		{"x=1000", nil, true},
+		{"256=1000", nil, true},
+		{"0=1006", nil, true}, // reserved, never sent
+		{"0=2000", nil, true},
+		{"0=abc", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCloseCodes(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCloseCodes(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseCloseCodes(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("ParseCloseCodes(%q)[%q] = %d, want %d", tt.list, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestCloseCodesForExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	state := func(script string) *os.ProcessState {
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Run()
+		return cmd.ProcessState
+	}
+	codes := CloseCodes{"0": 1000, "3": 4003, "signal": 1001, "*": 1011}
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 1000},
+		{"exit 3", 4003},
+		{"exit 4", 1011},
+		{"kill -9 $$", 1001},
+	}
+	for _, tt := range tests {
+		if got := codes.forExit(state(tt.script)); got != tt.want {
+			t.Errorf("forExit(%q) = %d, want %d", tt.script, got, tt.want)
+		}
+	}
+	if got := (CloseCodes{"0": 1000}).forExit(state("exit 1")); got != 0 {
+		t.Errorf("unmapped status gave %d, want 0 (no close frame)", got)
+	}
+}
+
+func TestTruncateReason(t *testing.T) {
+	if got := truncateReason("short"); got != "short" {
+		t.Errorf("truncateReason(short) = %q", got)
+	}
+	long := strings.Repeat("a", maxCloseReason-1) + "é" // 2-byte rune straddles the limit
+	if got := truncateReason(long); got != strings.Repeat("a", maxCloseReason-1) {
+		t.Errorf("truncateReason split a rune: %q", got[len(got)-3:])
+	}
+}
+
+func TestCloseFrameFromExitStatus(t *testing.T) {
+	h, url := newTestServer(t, "/bin/sh", "-c", "echo 'bad input' >&2; exit 3")
+	h.Config.CloseCodes = CloseCodes{"0": 1000, "*": 1011}
+	h.Config.CloseReason = true
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected a close frame, got %v", err)
+	}
+	if closeErr.Code != websocket.CloseInternalServerErr || closeErr.Text != "bad input" {
+		t.Errorf("close = %d %q, want 1011 \"bad input\"", closeErr.Code, closeErr.Text)
+	}
+}
+
+func TestNotifyClose(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "stdin")
+	h, url := newTestServer(t, "/bin/sh", "-c", `cat > "$0"`, out)
+	h.Config.NotifyClose = true
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	waitForSessions(t, h, 1)
+	client.WriteMessage(websocket.TextMessage, []byte("hello"))
+	client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "done"))
+	client.Close()
+	waitForSessions(t, h, 0)
+
+	want := "hello\n" + `{"websocketd":"close","code":4001,"reason":"done"}` + "\n"
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		got, _ := os.ReadFile(out)
+		if string(got) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process read %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
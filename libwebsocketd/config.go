@@ -6,6 +6,7 @@
 package libwebsocketd
 
 import (
+	"net"
 	"time"
 )
 
@@ -20,10 +21,52 @@ type Config struct {
 	HandshakeTimeout time.Duration // time to finish handshake (default 1500ms)
 	PingInterval     time.Duration // interval between WebSocket pings (0 = disabled)
 	MaxFrameSize     int64         // Max inbound WebSocket message size in bytes (0 = unlimited)
+	CloseCodes       CloseCodes    // Close code to send when the process exits, by exit status (empty = none)
+	CloseReason      bool          // Send the process's last stderr line as the close reason
+	NotifyClose      bool          // Tell the process the client's close code and reason on stdin
+
+	TrustedProxies []*net.IPNet  // Peers whose Forwarded/X-Forwarded-* headers say who the client is
+	Auth           Authenticator // Checks WebSocket upgrades before a process is started (nil = anyone)
+	CertRules      []CertRule    // Script paths that need a verified client certificate, longest first
+	Routes         []Route       // Per-script overrides of the settings below, with --dir
+
+	// per-session rate limits, in each direction; 0 does not limit
+	InMsgRate   float64 // Messages per second from the client to the process
+	InByteRate  float64 // Bytes per second from the client to the process
+	OutMsgRate  float64 // Messages per second from the process to the client
+	OutByteRate float64 // Bytes per second from the process to the client
+	RateClose   bool    // Close with 1008 when a session goes over a rate, instead of throttling it
+
+	// pre-forked processes, per command; 0 starts a process for each session
+	PoolMin int // Spare processes to keep started
+	PoolMax int // Most spares to keep when sessions arrive faster than PoolMin can be replaced
+
+	// one process per command whose output goes to every session
+	Broadcast       bool // Send each message of the process to every client
+	BroadcastBuffer int  // Messages a client may fall behind (0 = DefaultBroadcastBuffer)
+	BroadcastClose  bool // Close a client that falls further behind, instead of dropping messages for it
+	BroadcastInput  bool // Write client messages to the process's stdin, instead of ignoring them
+
+	// keeping a session's process for its client to reconnect to (see ResumeParam)
+	ResumeMs     uint // Milliseconds to keep the process after the connection drops (0 = end it with the connection)
+	ResumeBuffer int  // Messages kept for the client while it is away (0 = DefaultResumeBuffer)
+
+	// per-client limits; a client is an IP address, or its network under the prefixes
+	MaxClientConns int     // Max concurrent WebSocket sessions per client (0 = unlimited)
+	ClientRate     float64 // New WebSocket sessions per second per client (0 = unlimited)
+	ClientBurst    int     // Sessions a client may open in a burst before ClientRate applies (0 = one second's worth)
+	ClientPrefix4  int     // IPv4 prefix length that groups addresses into one client (0 = whole address)
+	ClientPrefix6  int     // IPv6 prefix length that groups addresses into one client (0 = whole address)
 
 	// settings
 	Binary         bool     // Use binary communication (send data in chunks they are read from process)
-	PassStderr     bool     // Forward STDERR to WebSocket clients as tagged JSON messages, alongside tagged STDOUT
+	Framing        Framing  // Records of the process's stdin and stdout (nil = lines, or read() chunks with Binary)
+	Mixed          bool     // Relay text and binary messages both, each record starting with its type (MixedText or MixedBinary)
+	PassStderr     bool     // Forward STDERR to WebSocket clients as tagged JSON messages, alongside tagged STDOUT; with Binary, as text messages
+	StreamByte     bool     // With Binary and PassStderr, send STDERR as binary too, every message starting with StreamStdout or StreamStderr
+	ExtEnvelope    bool     // With PassStderr (not Binary), number and time the JSON messages and end with one saying how the process exited
+	RawStdout      bool     // With PassStderr (not Binary), send STDOUT as it is and wrap only STDERR in JSON
+	Multiplex      bool     // Run one process per command for all of its sessions (see MultiplexEnvVar)
 	ReverseLookup  bool     // Perform reverse DNS lookups on hostnames (useful, but slower).
 	Ssl            bool     // websocketd works with --ssl which means TLS is in use
 	SslCaFile      string   // CA certificate file for client certificate verification (mutual TLS).
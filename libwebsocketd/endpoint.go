@@ -22,35 +22,57 @@ type Endpoint interface {
 // draining that endpoint's Output channel, which eventually blocks the
 // producer. No unbounded buffering occurs.
 func PipeEndpoints(e1, e2 Endpoint) {
+	pipeEndpoints(e1, e2, nil, nil)
+}
+
+// pipeEndpoints is PipeEndpoints, also counting the messages relayed from e1
+// in from1 and those from e2 in from2 (either flow may be nil), and holding
+// each direction to its flow's rate limit. It returns the endpoint that ended
+// the session: the one whose output closed, that could no longer be sent to,
+// or that went over a limit set to close, first.
+func pipeEndpoints(e1, e2 Endpoint, from1, from2 *flow) (ended Endpoint) {
 	e1.StartReading()
 	e2.StartReading()
 
-	done := make(chan struct{}, 2)
+	done := make(chan Endpoint, 2)
+	stop := make(chan struct{}) // interrupts a throttled direction
 
 	// e1 → e2 (e.g., WebSocket messages → process stdin)
 	go func() {
 		for msg := range e1.Output() {
-			if !e2.Send(msg) {
+			if !from1.wait(len(msg), stop) {
 				break
 			}
+			if !e2.Send(msg) {
+				done <- e2
+				return
+			}
+			from1.add(len(msg))
 		}
-		done <- struct{}{}
+		done <- e1
 	}()
 
 	// e2 → e1 (e.g., process stdout → WebSocket messages)
 	go func() {
 		for msg := range e2.Output() {
-			if !e1.Send(msg) {
+			if !from2.wait(len(msg), stop) {
 				break
 			}
+			if !e1.Send(msg) {
+				done <- e1
+				return
+			}
+			from2.add(len(msg))
 		}
-		done <- struct{}{}
+		done <- e2
 	}()
 
 	// Wait for either direction to finish (channel closed or send failed),
 	// then terminate both endpoints to clean up the other direction.
-	<-done
+	ended = <-done
+	close(stop)
 	e1.Terminate()
 	e2.Terminate()
 	<-done // wait for the second goroutine to finish
+	return ended
 }
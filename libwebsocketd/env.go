@@ -6,6 +6,8 @@
 package libwebsocketd
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"strings"
@@ -20,10 +22,12 @@ var dashReplacer = strings.NewReplacer("-", "_")
 
 func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []string {
 	headers := req.Header
+	config := handler.config
 
 	url := req.URL
 
-	serverName, serverPort, err := tellHostPort(req.Host, handler.server.Config.Ssl)
+	https := requestIsHTTPS(req, config.Ssl)
+	serverName, serverPort, err := tellHostPort(req.Host, https)
 	if err != nil {
 		// This does mean that we cannot detect port from Host: header... Just keep going with "", guessing is bad.
 		log.Debug("env", "Host port detection error: %s", err)
@@ -31,18 +35,21 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	}
 
 	standardEnvCount := 20
-	if handler.server.Config.Ssl {
+	if https {
 		standardEnvCount += 1
 	}
+	if req.TLS != nil {
+		standardEnvCount += sslEnvCount
+	}
 
-	parentLen := len(handler.server.Config.ParentEnv)
-	env := make([]string, 0, len(headers)+standardEnvCount+parentLen+len(handler.server.Config.Env))
+	parentLen := len(config.ParentEnv)
+	env := make([]string, 0, len(headers)+standardEnvCount+parentLen+len(config.Env))
 
 	// This variable could be rewritten from outside
-	env = appendEnv(env, "SERVER_SOFTWARE", handler.server.Config.ServerSoftware)
+	env = appendEnv(env, "SERVER_SOFTWARE", config.ServerSoftware)
 
 	parentStarts := len(env)
-	env = append(env, handler.server.Config.ParentEnv...)
+	env = append(env, config.ParentEnv...)
 
 	// IMPORTANT ---> Adding a header? Make sure standardEnvCount (above) is up to date.
 
@@ -58,35 +65,42 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	env = appendEnv(env, "SCRIPT_NAME", handler.URLInfo.ScriptPath)
 	env = appendEnv(env, "PATH_INFO", handler.URLInfo.PathInfo)
 	env = appendEnv(env, "PATH_TRANSLATED", url.Path)
-	env = appendEnv(env, "QUERY_STRING", url.RawQuery)
+	env = appendEnv(env, "QUERY_STRING", redactSecrets(url.RawQuery))
 
-	// Not supported, but we explicitly clear them so we don't get leaks from parent environment.
-	env = appendEnv(env, "AUTH_TYPE", "")
+	// Set by --auth; otherwise cleared, as are the unsupported ones, so we
+	// don't get leaks from parent environment.
+	id, _ := authIdentity(req)
+	env = appendEnv(env, "AUTH_TYPE", id.Type)
 	env = appendEnv(env, "CONTENT_LENGTH", "")
 	env = appendEnv(env, "CONTENT_TYPE", "")
 	env = appendEnv(env, "REMOTE_IDENT", "")
-	env = appendEnv(env, "REMOTE_USER", "")
+	env = appendEnv(env, "REMOTE_USER", id.User)
 
 	// Non standard, but commonly used headers.
 	env = appendEnv(env, "UNIQUE_ID", handler.Id) // Based on Apache mod_unique_id.
 	env = appendEnv(env, "REMOTE_PORT", handler.RemoteInfo.Port)
-	env = appendEnv(env, "REQUEST_URI", url.RequestURI()) // e.g. /foo/blah?a=b
+	env = appendEnv(env, "REQUEST_URI", redactSecrets(url.RequestURI())) // e.g. /foo/blah?a=b
 
 	// The following variables are part of the CGI specification, but are optional
 	// and not set by websocketd:
 	//
-	//   AUTH_TYPE, REMOTE_USER, REMOTE_IDENT
-	//     -- Authentication left to the underlying programs.
+	//   REMOTE_IDENT
+	//     -- Not supported.
+	//
+	//   AUTH_TYPE, REMOTE_USER
+	//     -- Only with --auth; otherwise authentication is left to the underlying programs.
 	//
 	//   CONTENT_LENGTH, CONTENT_TYPE
 	//     -- makes no sense for WebSocket connections.
 	//
 	//   SSL_*
-	//     -- SSL variables are not supported, HTTPS=on added for websocketd running with --ssl
+	//     -- Only for TLS websocketd terminated itself (--ssl); see appendSSLEnv. HTTPS=on
+	//        is also added behind a trusted proxy that says the client used TLS.
 
-	if handler.server.Config.Ssl {
+	if https {
 		env = appendEnv(env, "HTTPS", "on")
 	}
+	env = appendSSLEnv(env, req.TLS)
 
 	if log.MinLevel == LogDebug {
 		for i, v := range env {
@@ -99,12 +113,17 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	}
 
 	for k, hdrs := range headers {
+		if k == "Authorization" && (id.Type == "Basic" || id.Type == "Bearer") {
+			// Like a CGI server, keep the password or token --auth checked
+			// from the process.
+			continue
+		}
 		header := fmt.Sprintf("HTTP_%s", dashReplacer.Replace(k))
 		env = appendEnv(env, header, hdrs...)
 		log.Debug("env", "Header variable %s", env[len(env)-1])
 	}
 
-	for _, v := range handler.server.Config.Env {
+	for _, v := range config.Env {
 		env = append(env, v)
 		log.Debug("env", "External variable: %s", v)
 	}
@@ -112,6 +131,60 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	return env
 }
 
+// sslEnvCount is the most variables appendSSLEnv adds.
+const sslEnvCount = 9
+
+// appendSSLEnv adds mod_ssl style variables describing a TLS connection, if
+// state is not nil: the protocol, cipher suite and server name, and the
+// client certificate, if one was presented (see --sslca). Distinguished
+// names are in RFC 2253 form, the cipher suite has its IANA name (not
+// OpenSSL's), and the fingerprint is the SHA-256 of the certificate as
+// "openssl x509 -fingerprint -sha256" prints it.
+func appendSSLEnv(env []string, state *tls.ConnectionState) []string {
+	if state == nil {
+		return env
+	}
+	env = appendEnv(env, "SSL_PROTOCOL", tlsProtocolName(state.Version))
+	env = appendEnv(env, "SSL_CIPHER", tls.CipherSuiteName(state.CipherSuite))
+	env = appendEnv(env, "SSL_SERVER_NAME", state.ServerName)
+
+	if len(state.PeerCertificates) == 0 {
+		return appendEnv(env, "SSL_CLIENT_VERIFY", "NONE")
+	}
+	verify := "SUCCESS"
+	if len(state.VerifiedChains) == 0 {
+		verify = "GENERIC" // presented, but not checked against --sslca
+	}
+	cert := state.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(fingerprint))
+	for i, b := range fingerprint {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	env = appendEnv(env, "SSL_CLIENT_VERIFY", verify)
+	env = appendEnv(env, "SSL_CLIENT_S_DN", cert.Subject.String())
+	env = appendEnv(env, "SSL_CLIENT_S_DN_CN", cert.Subject.CommonName)
+	env = appendEnv(env, "SSL_CLIENT_I_DN", cert.Issuer.String())
+	env = appendEnv(env, "SSL_CLIENT_SERIAL", fmt.Sprintf("%X", cert.SerialNumber))
+	env = appendEnv(env, "SSL_CLIENT_FINGERPRINT", strings.Join(hex, ":"))
+	return env
+}
+
+// tlsProtocolName names a TLS version as mod_ssl does, e.g. "TLSv1.3".
+func tlsProtocolName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
 // Adapted from net/http/header.go
 func appendEnv(env []string, k string, v ...string) []string {
 	if len(v) == 0 {
@@ -0,0 +1,79 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendSSLEnv(t *testing.T) {
+	if env := appendSSLEnv(nil, nil); len(env) != 0 {
+		t.Errorf("plain connection got %v", env)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xBEEF),
+		Subject:      pkix.Name{CommonName: "alice", Organization: []string{"Example, Inc."}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	state := &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		ServerName:       "ws.example",
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	got := make(map[string]string)
+	for _, kv := range appendSSLEnv(nil, state) {
+		k, v, _ := strings.Cut(kv, "=")
+		got[k] = v
+	}
+	want := map[string]string{
+		"SSL_PROTOCOL":       "TLSv1.3",
+		"SSL_CIPHER":         "TLS_AES_128_GCM_SHA256",
+		"SSL_SERVER_NAME":    "ws.example",
+		"SSL_CLIENT_VERIFY":  "SUCCESS",
+		"SSL_CLIENT_S_DN":    `CN=alice,O=Example\, Inc.`,
+		"SSL_CLIENT_S_DN_CN": "alice",
+		"SSL_CLIENT_I_DN":    `CN=alice,O=Example\, Inc.`,
+		"SSL_CLIENT_SERIAL":  "BEEF",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if fp := got["SSL_CLIENT_FINGERPRINT"]; len(fp) != 32*3-1 || fp != strings.ToUpper(fp) {
+		t.Errorf("SSL_CLIENT_FINGERPRINT = %q, want colon-separated SHA-256", fp)
+	}
+	if len(got) > sslEnvCount {
+		t.Errorf("appendSSLEnv added %d variables; raise sslEnvCount", len(got))
+	}
+
+	state.VerifiedChains = nil
+	for _, kv := range appendSSLEnv(nil, state) {
+		if strings.HasPrefix(kv, "SSL_CLIENT_VERIFY=") && kv != "SSL_CLIENT_VERIFY=GENERIC" {
+			t.Errorf("unverified certificate gave %s", kv)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Framing splits a process's stdout into messages and frames each message
+// written to its stdin, so that one WebSocket message is one record whatever
+// bytes it holds (--framing). A nil Framing is the default: one message per
+// line, or with --binary whatever each read of stdout returns.
+type Framing interface {
+	// read returns the next record of r, io.EOF at the end of the stream, or
+	// another error if what r holds is not a record.
+	read(r *bufio.Reader) ([]byte, error)
+	// frame returns msg as a record, or an error if it cannot be one.
+	frame(msg []byte) ([]byte, error)
+}
+
+// maxRecordSize bounds a record whose size is given up front, so that a
+// bad length cannot have websocketd allocate gigabytes. It is as big as the
+// chunks --binary reads.
+const maxRecordSize = 10 * 1024 * 1024
+
+// NewFraming returns the Framing a --framing spec names:
+//
+//	lines       one message per line (the default; returns nil)
+//	delim:C     records ended by the byte C: one character, or an escape
+//	            such as \0, \t or \x1e
+//	netstring   records as LENGTH:DATA, with LENGTH in decimal
+//	length      records after their length as 4 bytes, big-endian
+//	jsonlines   one JSON string per line, so messages can hold newlines
+func NewFraming(spec string) (Framing, error) {
+	kind, arg, hasArg := strings.Cut(spec, ":")
+	if hasArg && kind != "delim" {
+		return nil, fmt.Errorf("framing %q takes no argument", kind)
+	}
+	switch kind {
+	case "lines":
+		return nil, nil
+	case "delim":
+		d, err := parseDelimiter(arg)
+		if err != nil {
+			return nil, err
+		}
+		return delimFraming{d}, nil
+	case "netstring":
+		return netstringFraming{}, nil
+	case "length":
+		return lengthFraming{}, nil
+	case "jsonlines":
+		return jsonLinesFraming{}, nil
+	}
+	return nil, fmt.Errorf("unknown framing %q; use lines, delim:C, netstring, length or jsonlines", spec)
+}
+
+// parseDelimiter reads the C of delim:C.
+func parseDelimiter(s string) (byte, error) {
+	if len(s) == 1 {
+		return s[0], nil
+	}
+	if s == `\0` {
+		return 0, nil
+	}
+	if u, err := strconv.Unquote(`"` + s + `"`); err == nil && len(u) == 1 {
+		return u[0], nil
+	}
+	return 0, fmt.Errorf("delimiter %q is not one byte; use a character or an escape such as \\0 or \\x1e", s)
+}
+
+// delimFraming ends each record with a byte that records cannot hold.
+type delimFraming struct {
+	delim byte
+}
+
+func (f delimFraming) read(r *bufio.Reader) ([]byte, error) {
+	buf, err := r.ReadBytes(f.delim)
+	if err != nil {
+		return nil, err // an unended record at EOF is dropped, as a line is
+	}
+	return buf[:len(buf)-1], nil
+}
+
+func (f delimFraming) frame(msg []byte) ([]byte, error) {
+	if bytes.IndexByte(msg, f.delim) >= 0 {
+		return nil, fmt.Errorf("message holds the delimiter %q", f.delim)
+	}
+	return append(msg, f.delim), nil
+}
+
+// netstringFraming writes each record as a netstring, LENGTH:DATA, with the
+// length in decimal.
+type netstringFraming struct{}
+
+func (netstringFraming) read(r *bufio.Reader) ([]byte, error) {
+	head, err := r.ReadSlice(':')
+	if err == io.EOF && len(head) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("netstring length: %w", err)
+	}
+	n, err := strconv.Atoi(string(head[:len(head)-1]))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("netstring length %q is not a number", head[:len(head)-1])
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("netstring of %d bytes is over %d", n, maxRecordSize)
+	}
+	buf := make([]byte, n+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("netstring: %w", unexpectedEOF(err))
+	}
+	if buf[n] != ',' {
+		return nil, fmt.Errorf("netstring not ended by a comma")
+	}
+	return buf[:n], nil
+}
+
+func (netstringFraming) frame(msg []byte) ([]byte, error) {
+	framed := strconv.AppendInt(nil, int64(len(msg)), 10)
+	framed = append(framed, ':')
+	framed = append(framed, msg...)
+	return append(framed, ','), nil
+}
+
+// lengthFraming writes each record after its length as a 4-byte big-endian
+// number.
+type lengthFraming struct{}
+
+func (lengthFraming) read(r *bufio.Reader) ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("record length: %w", err)
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes is over %d", n, maxRecordSize)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("record: %w", unexpectedEOF(err))
+	}
+	return buf, nil
+}
+
+func (lengthFraming) frame(msg []byte) ([]byte, error) {
+	if uint64(len(msg)) > math.MaxUint32 {
+		return nil, fmt.Errorf("message of %d bytes is too big for a 4-byte length", len(msg))
+	}
+	framed := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(msg)), uint32(len(msg)))
+	return append(framed, msg...), nil
+}
+
+// jsonLinesFraming writes each record as a JSON string on a line of its
+// own. It carries text: invalid UTF-8 in a message is replaced.
+type jsonLinesFraming struct{}
+
+func (jsonLinesFraming) read(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var s string
+	if err := json.Unmarshal(trimEOL(line), &s); err != nil {
+		return nil, fmt.Errorf("line is not a JSON string: %s", err)
+	}
+	return []byte(s), nil
+}
+
+func (jsonLinesFraming) frame(msg []byte) ([]byte, error) {
+	// json.Marshal cannot fail on a string, and escapes its newlines.
+	line, _ := json.Marshal(string(msg))
+	return append(line, '\n'), nil
+}
+
+// unexpectedEOF reports a stream that ended inside a record as such.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFraming(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Framing
+		wantErr bool
+	}{
+		{"lines", nil, false},
+		{"delim:\\0", delimFraming{0}, false},
+		{"delim:\\x1e", delimFraming{0x1e}, false},
+		{"delim:\\t", delimFraming{'\t'}, false},
+		{"delim:;", delimFraming{';'}, false},
+		{"delim:\"", delimFraming{'"'}, false},
+		{"netstring", netstringFraming{}, false},
+		{"length", lengthFraming{}, false},
+		{"jsonlines", jsonLinesFraming{}, false},
+		{"delim:", nil, true},
+		{"delim:ab", nil, true},
+		{"delim:\\u00e9", nil, true},
+		{"length:4", nil, true},
+		{"chunks", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := NewFraming(tt.spec)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("NewFraming(%q) = %#v, %v; want %#v", tt.spec, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFramingRoundTrip(t *testing.T) {
+	msgs := []string{"hello", "", "two\nlines", "bin\x00ary\xff", `"quoted", 12:34`}
+	for _, f := range []Framing{delimFraming{0x1e}, netstringFraming{}, lengthFraming{}, jsonLinesFraming{}} {
+		var stream []byte
+		for _, msg := range msgs {
+			if _, ok := f.(jsonLinesFraming); ok && msg == "bin\x00ary\xff" {
+				continue // jsonlines carries text
+			}
+			framed, err := f.frame([]byte(msg))
+			if err != nil {
+				t.Fatalf("%T: frame(%q): %v", f, msg, err)
+			}
+			stream = append(stream, framed...)
+		}
+		r := bufio.NewReader(strings.NewReader(string(stream)))
+		for _, want := range msgs {
+			if _, ok := f.(jsonLinesFraming); ok && want == "bin\x00ary\xff" {
+				continue
+			}
+			if got, err := f.read(r); err != nil || string(got) != want {
+				t.Errorf("%T: read = %q, %v; want %q", f, got, err, want)
+			}
+		}
+		if got, err := f.read(r); err != io.EOF {
+			t.Errorf("%T: read at the end = %q, %v; want io.EOF", f, got, err)
+		}
+	}
+}
+
+func TestFramingErrors(t *testing.T) {
+	if _, err := (delimFraming{0}).frame([]byte("a\x00b")); err == nil {
+		t.Errorf("frame of a message holding the delimiter succeeded")
+	}
+	tests := []struct {
+		f      Framing
+		stream string
+	}{
+		{netstringFraming{}, "abc:hello,"},
+		{netstringFraming{}, "5:hello;"},
+		{netstringFraming{}, "5:hel"},
+		{netstringFraming{}, "99999999:"},
+		{lengthFraming{}, "\x00\x00"},
+		{lengthFraming{}, "\x00\x00\x00\x05hel"},
+		{lengthFraming{}, "\x7f\xff\xff\xff"},
+		{jsonLinesFraming{}, "not json\n"},
+		{jsonLinesFraming{}, "{\"a\":1}\n"},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.stream))
+		if got, err := tt.f.read(r); err == nil || err == io.EOF {
+			t.Errorf("%T: read of %q = %q, %v; want an error", tt.f, tt.stream, got, err)
+		}
+	}
+}
+
+func TestProcessEndpointFraming(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	launched, err := launchCmd("cat", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe := NewProcessEndpoint(launched, false, quietLogScope(), false)
+	pe.framing = netstringFraming{}
+	pe.StartReading()
+	defer pe.Terminate()
+
+	// A text message from the WebSocketEndpoint, with its newline.
+	pe.Send([]byte("one\ntwo\n"))
+	select {
+	case msg := <-pe.Output():
+		if string(msg) != "one\ntwo" {
+			t.Errorf("got %q, want one message of two lines", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the record")
+	}
+}
@@ -30,7 +30,12 @@ type WebsocketdHandler struct {
 	*URLInfo
 	Env []string
 
+	config  *Config // the server's, with the route for the script applied
 	command string
+	args    []string
+
+	resumeToken string // the client's ResumeParam, if it is reconnecting
+	owner       string // who may resume the session: the command and authenticated client
 }
 
 // NewWebsocketdHandler constructs the struct and parses all required things in it...
@@ -38,27 +43,39 @@ func NewWebsocketdHandler(s *WebsocketdServer, req *http.Request, log *LogScope)
 	wsh = &WebsocketdHandler{server: s, Id: generateId()}
 	log.Associate("id", wsh.Id)
 
-	wsh.RemoteInfo, err = GetRemoteInfo(req.RemoteAddr, s.Config.ReverseLookup)
+	// One configuration for the whole request, even if a reload lands
+	// meanwhile.
+	cfg := s.config()
+	wsh.RemoteInfo, err = GetRemoteInfo(req.RemoteAddr, cfg.ReverseLookup)
 	if err != nil {
 		log.Error("session", "Could not understand remote address '%s': %s", req.RemoteAddr, err)
 		return nil, err
 	}
 	log.Associate("remote", wsh.RemoteInfo.Host)
 
-	wsh.URLInfo, err = GetURLInfo(req.URL.Path, s.Config)
+	wsh.URLInfo, err = GetURLInfo(req.URL.Path, cfg)
 	if err != nil {
 		log.Access("session", "NOT FOUND: %s", err)
 		return nil, err
 	}
 
-	wsh.command = s.Config.CommandName
-	if s.Config.UsingScriptDir {
+	wsh.config = cfg.forScript(wsh.URLInfo.ScriptPath)
+	wsh.command, wsh.args = cfg.CommandName, cfg.CommandArgs
+	if r := commandRouteFor(wsh.URLInfo.ScriptPath, cfg.Routes); r != nil {
+		wsh.command, wsh.args = r.Command, r.Args
+	} else if cfg.UsingScriptDir {
 		wsh.command = wsh.URLInfo.FilePath
 	}
 	log.Associate("command", wsh.command)
 
 	wsh.Env = createEnv(wsh, req, log)
 
+	if wsh.config.ResumeMs > 0 {
+		id, _ := authIdentity(req)
+		wsh.resumeToken = req.URL.Query().Get(ResumeParam)
+		wsh.owner = commandKey(wsh.command, wsh.args) + "\x00" + id.Type + "\x00" + id.User
+	}
+
 	return wsh, nil
 }
 
@@ -66,24 +83,265 @@ func (wsh *WebsocketdHandler) accept(ws *websocket.Conn, log *LogScope) {
 	defer ws.Close()
 
 	log.Access("session", "CONNECT")
-	defer log.Access("session", "DISCONNECT")
+	sess := &session{start: time.Now()}
+	closedBy := closedByServer
+	defer func() { sess.logSummary(log, closedBy) }()
+
+	if b := wsh.server.casts.get(wsh.command, wsh.args, wsh.config, wsh.server.Log, wsh.server.metrics); b != nil {
+		closedBy = wsh.acceptBroadcast(ws, b, sess, log)
+		return
+	}
+	if m := wsh.server.muxes.get(wsh.command, wsh.args, wsh.config, wsh.server.Log, wsh.server.metrics); m != nil {
+		closedBy = wsh.acceptShared(ws, m, sess, log)
+		return
+	}
 
-	launched, err := launchCmd(wsh.command, wsh.server.Config.CommandArgs, wsh.Env)
+	if r, token := wsh.server.resumes.resume(wsh.resumeToken, wsh.owner); r != nil {
+		var ok bool
+		if closedBy, ok = wsh.acceptResumable(ws, r, token, true, sess, log); ok {
+			return
+		}
+	} else if wsh.resumeToken != "" {
+		log.Info("session", "Unknown or expired resume token, starting a new process")
+	}
+
+	launched, err := wsh.launch(log)
 	if err != nil {
-		log.Error("process", "Could not launch process %s %s (%s)", wsh.command, strings.Join(wsh.server.Config.CommandArgs, " "), err)
+		log.Error("process", "Could not launch process %s %s (%s)", wsh.command, strings.Join(wsh.args, " "), err)
 		return
 	}
 
 	log.Associate("pid", strconv.Itoa(launched.cmd.Process.Pid))
 
-	binary := wsh.server.Config.Binary
-	process := NewProcessEndpoint(launched, binary, log, wsh.server.Config.PassStderr)
-	if cms := wsh.server.Config.CloseMs; cms != 0 {
+	config := wsh.config
+	binary := config.Binary
+	// With Mixed, messages carry their type and are relayed unchanged.
+	process := NewProcessEndpoint(launched, binary || config.Mixed, log, config.PassStderr)
+	if cms := config.CloseMs; cms != 0 {
 		process.closetime += time.Duration(cms) * time.Millisecond
 	}
-	wsEndpoint := NewWebSocketEndpoint(ws, binary, log, wsh.server.Config.PingInterval, wsh.server.Config.MaxFrameSize)
+	process.metrics = wsh.server.metrics
+	process.framing = config.Framing
+	process.streamByte = config.StreamByte
+	process.extended, process.rawStdout = config.ExtEnvelope, config.RawStdout
+	if config.ResumeMs > 0 {
+		r := newResumable(wsh.server, process, wsh.owner, config, log)
+		closedBy, _ = wsh.acceptResumable(ws, r, wsh.server.resumes.tokenOf(r), false, sess, log)
+		return
+	}
+	wsEndpoint := newWebSocketEndpoint(ws, config, log)
 
-	PipeEndpoints(process, wsEndpoint)
+	sess.ws, sess.process = wsEndpoint, process
+	wsh.limit(sess)
+	wsh.propagateClose(sess)
+	closedBy = wsh.pipe(sess, process, log)
+}
+
+// acceptResumable relays the session to a process that outlives its
+// connection (--resumems), first sending the client the token to resume
+// with. It reports false, having sent nothing, if the process was ended
+// before the client could resume it.
+func (wsh *WebsocketdHandler) acceptResumable(ws *websocket.Conn, r *resumable, token string, resumed bool, sess *session, log *LogScope) (closedBy string, ok bool) {
+	config := wsh.config
+	wsEndpoint := newWebSocketEndpoint(ws, config, log)
+	a, missed := r.attach(wsEndpoint, func() bool {
+		// Only a connection that dropped is kept: not one the client
+		// closed, nor one ended by the server or a rate limit.
+		code, _ := wsEndpoint.CloseStatus()
+		limited, _ := sess.limitClose()
+		dropped := code == 0 || code == websocket.CloseAbnormalClosure
+		return dropped && limited == 0 && !sess.drained.Load()
+	})
+	if a == nil {
+		log.Info("session", "Resumed process ended meanwhile, starting a new process")
+		return closedByServer, false
+	}
+	if resumed {
+		log.Associate("pid", strconv.Itoa(r.process.process.cmd.Process.Pid))
+		log.Access("session", "RESUMED: %d message(s) missed", missed)
+	}
+	sess.ws, sess.process = wsEndpoint, r.process
+	wsh.limit(sess)
+	wsEndpoint.closeFrame = func() (int, string) {
+		if code, reason := sess.limitClose(); code != 0 {
+			return code, reason
+		}
+		return exitCloseFrame(config, r.process)
+	}
+	if err := greetResumable(ws, token, resumed, missed); err != nil {
+		log.Debug("session", "Cannot send resume token: %s", err)
+	}
+	return wsh.pipe(sess, a, log), true
+}
+
+// acceptShared relays the session through its command's shared process
+// (--multiplex) rather than a process of its own.
+func (wsh *WebsocketdHandler) acceptShared(ws *websocket.Conn, m *multiplexer, sess *session, log *LogScope) (closedBy string) {
+	config := wsh.config
+	shared, err := m.attach(wsh.Env, config.Binary, log)
+	if err != nil {
+		log.Error("process", "Could not launch process %s %s (%s)", wsh.command, strings.Join(wsh.args, " "), err)
+		return closedByServer
+	}
+	log.Associate("session", shared.id)
+
+	wsEndpoint := newWebSocketEndpoint(ws, config, log)
+	sess.ws = wsEndpoint
+	wsh.limit(sess)
+	shared.closeStatus = wsEndpoint.CloseStatus
+	wsEndpoint.closeFrame = func() (int, string) {
+		if code, reason := sess.limitClose(); code != 0 {
+			return code, reason
+		}
+		if code, reason := shared.closedWith(); code != 0 {
+			return code, reason
+		}
+		return exitCloseFrame(config, shared.process.ProcessEndpoint)
+	}
+	return wsh.pipe(sess, shared, log)
+}
+
+// acceptBroadcast relays its command's shared process (--broadcast) to the
+// session's client.
+func (wsh *WebsocketdHandler) acceptBroadcast(ws *websocket.Conn, b *broadcaster, sess *session, log *LogScope) (closedBy string) {
+	config := wsh.config
+	client, err := b.attach(log)
+	if err != nil {
+		log.Error("process", "Could not launch process %s %s (%s)", wsh.command, strings.Join(wsh.args, " "), err)
+		return closedByServer
+	}
+
+	wsEndpoint := newWebSocketEndpoint(ws, b.config, log)
+	sess.ws = wsEndpoint
+	wsh.limit(sess)
+	wsEndpoint.closeFrame = func() (int, string) {
+		if code, reason := sess.limitClose(); code != 0 {
+			return code, reason
+		}
+		if code := client.closedWith(); code != 0 {
+			return code, "too far behind"
+		}
+		return exitCloseFrame(config, client.process.ProcessEndpoint)
+	}
+	closedBy = wsh.pipe(sess, client, log)
+	if client.closedWith() != 0 {
+		closedBy = closedByServer
+	}
+	return closedBy
+}
+
+// newWebSocketEndpoint wraps a session's connection in the mode config
+// asks for.
+func newWebSocketEndpoint(ws *websocket.Conn, config *Config, log *LogScope) *WebSocketEndpoint {
+	we := NewWebSocketEndpoint(ws, config.Binary, log, config.PingInterval, config.MaxFrameSize)
+	we.mixed = config.Mixed
+	// Binary stdout beside stderr as text (see ProcessEndpoint.tag).
+	we.sendTyped = config.Binary && config.PassStderr
+	return we
+}
+
+// exitCloseFrame returns the close code and reason for a client whose
+// process has exited, per --closecodes and --closereason, or 0 if it is
+// still running or the mapping has none.
+func exitCloseFrame(config *Config, process *ProcessEndpoint) (int, string) {
+	state := process.ExitState()
+	if state == nil || len(config.CloseCodes) == 0 {
+		return 0, "" // still running (it could not be killed), or no mapping
+	}
+	reason := ""
+	if config.CloseReason {
+		reason = truncateReason(process.LastStderr())
+	}
+	return config.CloseCodes.forExit(state), reason
+}
+
+// limit sets up the session's message counts and rate limits.
+func (wsh *WebsocketdHandler) limit(sess *session) {
+	config := wsh.config
+	sess.fromClient.total, sess.toClient.total = wsh.server.metrics.flows()
+	sess.fromClient.limit = newSessionLimit(config.InMsgRate, config.InByteRate, config.MaxFrameSize, config.RateClose)
+	// A process's message is a line, or a --binary chunk of up to 10 MiB;
+	// lines longer than that are rare enough to leave out.
+	sess.toClient.limit = newSessionLimit(config.OutMsgRate, config.OutByteRate, 10*1024*1024, config.RateClose)
+}
+
+// pipe relays between the session's client and process until either ends
+// it, and returns which side that was.
+func (wsh *WebsocketdHandler) pipe(sess *session, process Endpoint, log *LogScope) (closedBy string) {
+	if !wsh.server.addSession(sess) {
+		// Shutdown began after the upgrade was accepted.
+		log.Access("session", "REJECTED: %s", ErrShuttingDown)
+		sess.ws.Close(websocket.CloseGoingAway, "server shutting down")
+		process.Terminate()
+		return closedByServer
+	}
+	defer wsh.server.removeSession(sess)
+
+	ended := pipeEndpoints(process, sess.ws, &sess.toClient, &sess.fromClient)
+	switch {
+	case sess.drained.Load():
+		return closedByServer
+	case sess.fromClient.limit.Exceeded() || sess.toClient.limit.Exceeded():
+		return closedByLimit
+	case ended == process:
+		return closedByProcess
+	default:
+		return closedByClient
+	}
+}
+
+// poolTries bounds how many spares launch tries before starting a process
+// itself, should it keep finding ones that have exited.
+const poolTries = 3
+
+// launch starts the session's process, or with --poolmin takes a spare from
+// its command's pool and writes it the handshake line.
+func (wsh *WebsocketdHandler) launch(log *LogScope) (*LaunchedProcess, error) {
+	s := wsh.server
+	if pool := s.pools.get(wsh.command, wsh.args, wsh.config, s.Log); pool != nil {
+		handshake := poolHandshake(wsh.Env, pool.env)
+		for i := 0; i < poolTries; i++ {
+			launched := pool.take()
+			if launched == nil {
+				break
+			}
+			if _, err := launched.stdin.Write(handshake); err != nil {
+				log.Debug("process", "Pre-forked process %d is gone: %s", launched.cmd.Process.Pid, err)
+				go discardProcess(launched)
+				continue
+			}
+			s.metrics.countLaunch("pool")
+			return launched, nil
+		}
+	}
+	s.metrics.countLaunch("spawn")
+	return launchCmd(wsh.command, wsh.args, wsh.Env)
+}
+
+// propagateClose connects the two ends' closing: when the process exits
+// first, the client gets a close frame mapped from its exit status
+// (--closecodes), with its last stderr line as the reason (--closereason);
+// when the client closes first, the process can be told the code and reason
+// on stdin (--notifyclose). A session ended for going over a rate limit is
+// closed with 1008 (policy violation) instead.
+func (wsh *WebsocketdHandler) propagateClose(sess *session) {
+	config := wsh.config
+	ws, process := sess.ws, sess.process
+	ws.closeFrame = func() (int, string) {
+		if code, reason := sess.limitClose(); code != 0 {
+			return code, reason
+		}
+		return exitCloseFrame(config, process)
+	}
+	if config.NotifyClose {
+		process.closeNotice = func() []byte {
+			code, reason := ws.CloseStatus()
+			if code == 0 {
+				return nil // the process ended first; there is no one to tell
+			}
+			return formatCloseNotice(code, reason)
+		}
+	}
 }
 
 // RemoteInfo holds information about remote http client
@@ -127,7 +385,16 @@ type URLInfo struct {
 
 // GetURLInfo is a function that parses path and provides URL info according to libwebsocketd.Config fields
 func GetURLInfo(path string, config *Config) (*URLInfo, error) {
+	if r := commandRouteFor(path, config.Routes); r != nil {
+		if r.Path == "/" {
+			return &URLInfo{"/", path, ""}, nil
+		}
+		return &URLInfo{r.Path, path[len(r.Path):], ""}, nil
+	}
 	if !config.UsingScriptDir {
+		if config.CommandName == "" && hasCommandRoutes(config.Routes) {
+			return nil, ErrScriptNotFound // only --route paths are served
+		}
 		return &URLInfo{"/", path, ""}, nil
 	}
 
@@ -6,8 +6,10 @@
 package libwebsocketd
 
 import (
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -132,3 +134,19 @@ func TestGetRemoteInfo(t *testing.T) {
 		}
 	})
 }
+
+func TestNewWebsocketdHandlerUsesReloadedConfig(t *testing.T) {
+	s := &WebsocketdServer{Config: &Config{CommandName: "/bin/old", Env: []string{"VERSION=1"}}}
+	s.Reload(&Config{CommandName: "/bin/new", Env: []string{"VERSION=2"}}, 0)
+
+	wsh, err := NewWebsocketdHandler(s, httptest.NewRequest("GET", "/", nil), quietLogScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wsh.command != "/bin/new" {
+		t.Errorf("command = %q, want the reloaded one", wsh.command)
+	}
+	if env := strings.Join(wsh.Env, " "); !strings.Contains(env, "VERSION=2") || strings.Contains(env, "VERSION=1") {
+		t.Errorf("env has %q, want the reloaded config's alone", env)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// basicAuth checks HTTP Basic credentials against an htpasswd file
+// (basic:FILE), as made by Apache's htpasswd with bcrypt (-B), the default
+// MD5 ($apr1$) or SHA-1 (-s).
+type basicAuth struct {
+	file  string
+	realm string
+	users atomic.Pointer[map[string]string] // user to password hash
+}
+
+func (a *basicAuth) reload() error {
+	users, err := readHtpasswd(a.file)
+	if err != nil {
+		return err
+	}
+	a.users.Store(&users)
+	return nil
+}
+
+// unknownUserHash is a bcrypt hash, at the default cost, that the password
+// of a user not in the file is checked against. The result is ignored.
+const unknownUserHash = "$2a$10$kj/eUHhAQsX45IVEpXtNa.UI0h9Ud02mn.dFDHq1ES1437AAoDyS2"
+
+func (a *basicAuth) Authenticate(req *http.Request) (Identity, error) {
+	refuse := func(reason string, args ...interface{}) (Identity, error) {
+		return Identity{}, &AuthError{
+			Status:    http.StatusUnauthorized,
+			Challenge: fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", a.realm),
+			Reason:    fmt.Sprintf(reason, args...),
+		}
+	}
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return refuse("no Basic credentials")
+	}
+	hash, known := (*a.users.Load())[user]
+	if !known {
+		// Take as long as a wrong password would, so the time to refuse
+		// does not tell which users exist.
+		checkHtpasswd(unknownUserHash, password)
+		return refuse("unknown user %q", user)
+	}
+	if !checkHtpasswd(hash, password) {
+		return refuse("wrong password for user %q", user)
+	}
+	return Identity{User: user, Type: "Basic"}, nil
+}
+
+// readHtpasswd reads the "user:hash" lines of an htpasswd file, refusing
+// any hash it cannot check rather than failing every login later.
+func readHtpasswd(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: not user:password-hash", path, n)
+		}
+		if !htpasswdHashSupported(hash) {
+			return nil, fmt.Errorf("%s:%d: unsupported password hash for %q; use bcrypt (htpasswd -B)", path, n, user)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+func htpasswdHashSupported(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$apr1$", "{SHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHtpasswd reports whether password matches an htpasswd hash.
+func checkHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(hash[len("$apr1$"):], "$")
+		return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		want := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// apr1Alphabet is the base 64 alphabet of crypt(3) hashes.
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 computes Apache's variant of the MD5-based crypt(3), "$apr1$salt$hash".
+// The algorithm (including its 1000 rounds) is fixed by compatibility.
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	ctx := md5.New()
+	ctx.Write([]byte(password + "$apr1$" + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		ctx.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		ctx := md5.New()
+		if i&1 != 0 {
+			ctx.Write(pw)
+		} else {
+			ctx.Write(sum)
+		}
+		if i%3 != 0 {
+			ctx.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			ctx.Write(pw)
+		}
+		if i&1 != 0 {
+			ctx.Write(sum)
+		} else {
+			ctx.Write(pw)
+		}
+		sum = ctx.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString("$apr1$" + salt + "$")
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(sum[g[0]])<<16|uint32(sum[g[1]])<<8|uint32(sum[g[2]]), 4)
+	}
+	encode(uint32(sum[11]), 2)
+	return out.String()
+}
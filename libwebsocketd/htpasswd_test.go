@@ -0,0 +1,83 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestApr1(t *testing.T) {
+	// Expected values from `openssl passwd -apr1 -salt SALT PASSWORD`.
+	tests := []struct{ password, salt, want string }{
+		{"secret", "abcdefgh", "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/"},
+		{"", "xy", "$apr1$xy$43..WIhbfuznGvwoCyUek/"},
+		{"a much longer password than sixteen bytes", "Zk/3QtaP", "$apr1$Zk/3QtaP$u2qcWY7iA1hwshAfRP8iz."},
+	}
+	for _, tt := range tests {
+		if got := apr1(tt.password, tt.salt); got != tt.want {
+			t.Errorf("apr1(%q, %q) = %s, want %s", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHtpasswd(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]string{
+		"bcrypt":     string(bcryptHash),
+		"bcrypt $2y": "$2y$" + string(bcryptHash[4:]), // as Apache's htpasswd -B writes it
+		"apr1":       "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/",
+		"sha":        "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+	}
+	for name, hash := range hashes {
+		if !checkHtpasswd(hash, "secret") {
+			t.Errorf("%s: right password refused", name)
+		}
+		if checkHtpasswd(hash, "Secret") {
+			t.Errorf("%s: wrong password accepted", name)
+		}
+	}
+}
+
+func TestUnknownUserHash(t *testing.T) {
+	// A malformed hash would be refused at once, and the time to refuse an
+	// unknown user would again differ from that of a wrong password.
+	if cost, err := bcrypt.Cost([]byte(unknownUserHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("unknownUserHash: cost %d, %v; want a bcrypt hash of cost %d", cost, err, bcrypt.DefaultCost)
+	}
+}
+
+func TestReadHtpasswd(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	os.WriteFile(good, []byte("# users\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n\nbob:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/\n"), 0600)
+	users, err := readHtpasswd(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users["bob"] != "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/" {
+		t.Errorf("got %v", users)
+	}
+
+	for name, content := range map[string]string{
+		"crypt":     "alice:rl0uE2t6K6RnM\n", // htpasswd -d, DES crypt(3)
+		"plaintext": "alice:secret\n",
+		"no colon":  "alice\n",
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_"))
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := readHtpasswd(path); err == nil {
+			t.Errorf("%s: readHtpasswd should fail", name)
+		}
+	}
+}
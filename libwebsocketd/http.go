@@ -19,6 +19,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
@@ -29,10 +31,26 @@ var upgradeRe = regexp.MustCompile(`(?i)(^|[,\s])Upgrade($|[,\s])`)
 
 // WebsocketdServer presents http.Handler interface for requests libwebsocketd is handling.
 type WebsocketdServer struct {
-	Config   *Config
+	Config   *Config // configuration the server was created with (Reload does not modify it)
 	Log      *LogScope
-	forks    chan byte
 	hostname string // cached os.Hostname(), computed once at startup
+
+	current atomic.Pointer[Config] // replacement for Config set by Reload, if any
+	metrics *metrics               // counters for MetricsHandler
+	clients *clientLimiter         // per-client session limits
+	pools   processPools           // pre-forked processes, with PoolMin
+	muxes   multiplexers           // shared processes, with Multiplex
+	casts   broadcasters           // shared processes, with Broadcast
+	resumes resumables             // processes kept for their clients, with ResumeMs
+
+	forksMu  sync.Mutex
+	forks    int // processes running for WebSocket sessions and CGI requests
+	maxForks int // limit on forks; zero or negative means unlimited
+
+	sessionsMu sync.Mutex
+	sessions   map[*session]struct{} // live WebSocket sessions, for Shutdown
+	sessionsWG sync.WaitGroup        // counts the entries in sessions
+	draining   bool                  // set by Shutdown; no new sessions after that
 }
 
 // NewWebsocketdServer creates WebsocketdServer struct with pre-determined config, logscope and maxforks limit
@@ -45,13 +63,34 @@ func NewWebsocketdServer(config *Config, log *LogScope, maxforks int) *Websocket
 		Config:   config,
 		Log:      log,
 		hostname: hostname,
+		maxForks: maxforks,
+		metrics:  newMetrics(),
+		clients:  newClientLimiter(),
 	}
-	if maxforks > 0 {
-		mux.forks = make(chan byte, maxforks)
-	}
+	mux.pools.warm(config, log)
+	mux.casts.warm(config, log, mux.metrics)
 	return mux
 }
 
+// Reload replaces the configuration and fork limit seen by requests that
+// arrive from now on. Sessions already running keep the configuration they
+// started with, and keep their fork slots even if the new limit is lower.
+func (h *WebsocketdServer) Reload(config *Config, maxforks int) {
+	h.current.Store(config)
+	h.forksMu.Lock()
+	h.maxForks = maxforks
+	h.forksMu.Unlock()
+}
+
+// config returns the configuration in effect for a new request: the one
+// passed to the latest Reload, or Config if there has been none.
+func (h *WebsocketdServer) config() *Config {
+	if c := h.current.Load(); c != nil {
+		return c
+	}
+	return h.Config
+}
+
 func splitMimeHeader(s string) (string, string) {
 	p := strings.IndexByte(s, ':')
 	if p < 0 {
@@ -82,15 +121,20 @@ func isWebSocketUpgrade(req *http.Request) bool {
 
 // ServeHTTP muxes between WebSocket handler, CGI handler, DevConsole, Static HTML or 404.
 func (h *WebsocketdServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req = fromTrustedProxy(req, h.config().TrustedProxies)
 	log := h.Log.NewLevel(h.Log.LogFunc)
-	log.Associate("url", h.TellURL("http", req.Host, req.RequestURI))
+	log.Associate("url", redactSecrets(h.tellURL("http", req)))
+	if proxy := proxyAddr(req); proxy != "" {
+		log.Associate("proxy", proxy)
+	}
 
 	if h.serveWebSocket(w, req, log) {
 		return
 	}
 
-	pushHeaders(w.Header(), h.Config.Headers)
-	pushHeaders(w.Header(), h.Config.HeadersHTTP)
+	config := h.config()
+	pushHeaders(w.Header(), config.Headers)
+	pushHeaders(w.Header(), config.HeadersHTTP)
 
 	if h.serveDevConsole(w, req, log) {
 		return
@@ -103,19 +147,39 @@ func (h *WebsocketdServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	log.Access("http", "NOT FOUND")
+	h.metrics.countHTTP("not_found")
 	http.NotFound(w, req)
 }
 
 // serveWebSocket handles WebSocket upgrade requests. Returns true if handled.
 func (h *WebsocketdServer) serveWebSocket(w http.ResponseWriter, req *http.Request, log *LogScope) bool {
-	if h.Config.CommandName == "" && !h.Config.UsingScriptDir {
+	config := h.config()
+	if config.CommandName == "" && !config.UsingScriptDir && !hasCommandRoutes(config.Routes) {
 		return false
 	}
 	if !isWebSocketUpgrade(req) {
 		return false
 	}
 
+	if h.isDraining() {
+		h.metrics.countUpgrade(upgradeShuttingDown)
+		rejectDraining(w, log)
+		return true
+	}
+
+	release, admitted := h.admitClient(w, req, config, log)
+	if !admitted {
+		return true
+	}
+	defer release()
+
+	req, authorized := h.authenticate(w, req, config, log)
+	if !authorized {
+		return true
+	}
+
 	if h.noteForkCreated() != nil {
+		h.metrics.countUpgrade(upgradeTooMany)
 		log.Error("http", "Max of possible forks already active, upgrade rejected")
 		http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
 		return true
@@ -125,34 +189,52 @@ func (h *WebsocketdServer) serveWebSocket(w http.ResponseWriter, req *http.Reque
 	handler, err := NewWebsocketdHandler(h, req, log)
 	if err != nil {
 		if err == ErrScriptNotFound {
+			h.metrics.countUpgrade(upgradeNotFound)
 			log.Access("session", "NOT FOUND: %s", err)
 			http.Error(w, "404 Not Found", 404)
 		} else {
+			h.metrics.countUpgrade(upgradeError)
 			log.Access("session", "INTERNAL ERROR: %s", err)
 			http.Error(w, "500 Internal Server Error", 500)
 		}
 		return true
 	}
 
+	if err := checkClientCert(req.TLS, handler.URLInfo.ScriptPath, config.CertRules); err != nil {
+		h.metrics.countUpgrade(upgradeForbidden)
+		log.Access("session", "FORBIDDEN: %s", err)
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return true
+	}
+
+	config = handler.config // with the script's route, if any
 	var headers http.Header
-	if len(h.Config.Headers)+len(h.Config.HeadersWs) > 0 {
+	if len(config.Headers)+len(config.HeadersWs) > 0 {
 		headers = http.Header(make(map[string][]string))
-		pushHeaders(headers, h.Config.Headers)
-		pushHeaders(headers, h.Config.HeadersWs)
+		pushHeaders(headers, config.Headers)
+		pushHeaders(headers, config.HeadersWs)
 	}
 
+	originRejected := false
 	upgrader := &websocket.Upgrader{
-		HandshakeTimeout: h.Config.HandshakeTimeout,
+		HandshakeTimeout: config.HandshakeTimeout,
 		CheckOrigin: func(r *http.Request) bool {
-			return checkOrigin(req, h.Config, log) == nil
+			originRejected = checkOrigin(req, config, log) != nil
+			return !originRejected
 		},
 	}
 	conn, err := upgrader.Upgrade(w, req, headers)
 	if err != nil {
+		if originRejected {
+			h.metrics.countUpgrade(upgradeOrigin)
+		} else {
+			h.metrics.countUpgrade(upgradeHandshake)
+		}
 		log.Access("session", "Unable to Upgrade: %s", err)
 		http.Error(w, "500 Internal Error", 500)
 		return true
 	}
+	h.metrics.countUpgrade(upgradeAccepted)
 
 	handler.accept(conn, log)
 	return true
@@ -164,11 +246,12 @@ func (h *WebsocketdServer) serveDevConsole(w http.ResponseWriter, req *http.Requ
 		return false
 	}
 	log.Access("http", "DEVCONSOLE")
+	h.metrics.countHTTP("devconsole")
 	// req.Host and req.RequestURI are attacker-controlled and echoed into
 	// the page inside a double-quoted HTML attribute. net/http surfaces a
 	// raw '"' in the request target verbatim, so escape before substituting
 	// to prevent the value from breaking out of the attribute (reflected XSS).
-	addr := html.EscapeString(h.TellURL("ws", req.Host, req.RequestURI))
+	addr := html.EscapeString(h.tellURL("ws", req))
 	content := strings.Replace(ConsoleContent, "{{addr}}", addr, -1)
 	http.ServeContent(w, req, ".html", h.Config.StartupTime, strings.NewReader(content))
 	return true
@@ -260,11 +343,17 @@ func (h *WebsocketdServer) serveCGI(w http.ResponseWriter, req *http.Request, lo
 		copy(cgienv, h.Config.ParentEnv)
 	}
 	cgienv[envlen] = "SERVER_SOFTWARE=" + h.Config.ServerSoftware
+	if req.TLS == nil && requestIsHTTPS(req, false) {
+		// cgi.Handler only sets HTTPS for TLS it terminated itself.
+		cgienv = append(cgienv, "HTTPS=on")
+	}
+	cgienv = appendSSLEnv(cgienv, req.TLS)
 	cgiHandler := &cgi.Handler{
 		Path: filePath,
 		Env:  cgienv,
 	}
 	log.Access("http", "CGI")
+	h.metrics.countHTTP("cgi")
 	cgiHandler.ServeHTTP(w, req)
 	return true
 }
@@ -302,6 +391,7 @@ func (h *WebsocketdServer) serveStatic(w http.ResponseWriter, req *http.Request,
 		return false
 	}
 	log.Access("http", "STATIC")
+	h.metrics.countHTTP("static")
 	fs := boundedDir{root: h.Config.StaticDir, fs: http.Dir(h.Config.StaticDir)}
 	http.FileServer(fs).ServeHTTP(w, req)
 	return true
@@ -309,41 +399,47 @@ func (h *WebsocketdServer) serveStatic(w http.ResponseWriter, req *http.Request,
 
 // TellURL is a helper function that changes http to https or ws to wss in case if SSL is used
 func (h *WebsocketdServer) TellURL(scheme, host, path string) string {
+	return h.formatURL(scheme, host, path, h.Config.Ssl)
+}
+
+// tellURL is TellURL for req, which may have come through a trusted proxy
+// that says whether the client used TLS.
+func (h *WebsocketdServer) tellURL(scheme string, req *http.Request) string {
+	return h.formatURL(scheme, req.Host, req.RequestURI, requestIsHTTPS(req, h.Config.Ssl))
+}
+
+func (h *WebsocketdServer) formatURL(scheme, host, path string, secure bool) string {
 	if len(host) > 0 && host[0] == ':' {
 		host = h.hostname + host
 	}
-	if h.Config.Ssl {
+	if secure {
 		return scheme + "s://" + host + path
 	}
 	return scheme + "://" + host + path
 }
 
 func (h *WebsocketdServer) noteForkCreated() error {
-	// note that forks can be nil since the construct could've been created by
-	// someone who is not using NewWebsocketdServer
-	if h.forks != nil {
-		select {
-		case h.forks <- 1:
-			return nil
-		default:
-			return ErrForkNotAllowed
-		}
-	}
+	h.forksMu.Lock()
+	defer h.forksMu.Unlock()
+	// A plain counter rather than a buffered channel of slots, because
+	// Reload can change the limit while forks are running.
+	if h.maxForks > 0 && h.forks >= h.maxForks {
+		return ErrForkNotAllowed
+	}
+	h.forks++
 	return nil
 }
 
 func (h *WebsocketdServer) noteForkCompleted() {
-	if h.forks != nil {
-		select {
-		case <-h.forks:
-			return
-		default:
-			// This should never happen — it means noteForkCompleted was called
-			// more times than noteForkCreated. Log rather than crash the server.
-			h.Log.Error("server", "noteForkCompleted called with no active forks")
-			return
-		}
+	h.forksMu.Lock()
+	defer h.forksMu.Unlock()
+	if h.forks == 0 {
+		// This should never happen — it means noteForkCompleted was called
+		// more times than noteForkCreated. Log rather than crash the server.
+		h.Log.Error("server", "noteForkCompleted called with no active forks")
+		return
 	}
+	h.forks--
 }
 
 func checkOrigin(req *http.Request, config *Config, log *LogScope) (err error) {
@@ -367,7 +463,7 @@ func checkOrigin(req *http.Request, config *Config, log *LogScope) (err error) {
 			return err
 		}
 		if config.SameOrigin {
-			localServer, localPort, err := tellHostPort(req.Host, req.TLS != nil)
+			localServer, localPort, err := tellHostPort(req.Host, requestIsHTTPS(req, req.TLS != nil))
 			if err != nil {
 				log.Access("session", "Request hostname parsing error: %s", err)
 				return err
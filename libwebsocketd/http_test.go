@@ -226,7 +226,7 @@ func TestTellURL(t *testing.T) {
 }
 
 func TestNoteForkCreatedAndCompleted(t *testing.T) {
-	t.Run("nil forks (unlimited)", func(t *testing.T) {
+	t.Run("zero maxForks (unlimited)", func(t *testing.T) {
 		s := &WebsocketdServer{}
 		if err := s.noteForkCreated(); err != nil {
 			t.Errorf("unlimited forks should never fail: %v", err)
@@ -235,7 +235,7 @@ func TestNoteForkCreatedAndCompleted(t *testing.T) {
 	})
 
 	t.Run("fork limit enforced", func(t *testing.T) {
-		s := &WebsocketdServer{forks: make(chan byte, 2)}
+		s := &WebsocketdServer{maxForks: 2}
 
 		// Fill up forks
 		if err := s.noteForkCreated(); err != nil {
@@ -260,6 +260,44 @@ func TestNoteForkCreatedAndCompleted(t *testing.T) {
 		s.noteForkCompleted()
 		s.noteForkCompleted()
 	})
+
+	t.Run("Reload changes the limit", func(t *testing.T) {
+		s := &WebsocketdServer{Config: &Config{}, maxForks: 2}
+		s.noteForkCreated()
+		s.noteForkCreated()
+
+		// Lowering the limit leaves running forks alone but admits no more
+		// until they drop below it.
+		s.Reload(&Config{}, 1)
+		if err := s.noteForkCreated(); err != ErrForkNotAllowed {
+			t.Errorf("expected ErrForkNotAllowed, got %v", err)
+		}
+		s.noteForkCompleted()
+		if err := s.noteForkCreated(); err != ErrForkNotAllowed {
+			t.Errorf("expected ErrForkNotAllowed at the new limit, got %v", err)
+		}
+
+		s.Reload(&Config{}, 3)
+		if err := s.noteForkCreated(); err != nil {
+			t.Errorf("fork should be available under the raised limit: %v", err)
+		}
+	})
+}
+
+func TestReloadConfig(t *testing.T) {
+	startup := &Config{Headers: []string{"X-Version: 1"}}
+	s := &WebsocketdServer{Config: startup}
+	if s.config() != startup {
+		t.Fatal("config() should return Config before any reload")
+	}
+	reloaded := &Config{Headers: []string{"X-Version: 2"}}
+	s.Reload(reloaded, 0)
+	if s.config() != reloaded {
+		t.Error("config() should return the reloaded config")
+	}
+	if s.Config != startup || startup.Headers[0] != "X-Version: 1" {
+		t.Error("Reload must not modify the startup Config")
+	}
 }
 
 func TestPushHeaders(t *testing.T) {
@@ -0,0 +1,381 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// jwtLeeway is how far the server's clock may disagree with the token
+// issuer's before "exp" and "nbf" are held against a token.
+const jwtLeeway = time.Minute
+
+// minHMACKeySize is the shortest "oct" key accepted: the size of HS256's
+// hash, which RFC 7518 section 3.2 sets as the least for HMAC keys.
+const minHMACKeySize = 32
+
+// jwtAuth admits clients bearing a JWT signed by one of the keys in a JWKS
+// file (jwt:FILE). The token is taken from an "Authorization: Bearer"
+// header or, as browsers cannot set headers on a WebSocket, from an
+// access_token query parameter. Its "sub" claim is the user. The token
+// reaches neither the process nor the log (see redactSecrets).
+type jwtAuth struct {
+	file     string
+	realm    string
+	issuer   string // required "iss", if not empty
+	audience string // required in "aud", if not empty
+	keys     atomic.Pointer[[]jsonWebKey]
+	now      func() time.Time
+}
+
+// jsonWebKey is a verification key from a JWKS.
+type jsonWebKey struct {
+	kid string
+	alg string      // the only algorithm the key may be used with, if not empty
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey or []byte for HMAC
+}
+
+func (a *jwtAuth) reload() error {
+	content, err := os.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.file, err)
+	}
+	a.keys.Store(&keys)
+	return nil
+}
+
+func (a *jwtAuth) Authenticate(req *http.Request) (Identity, error) {
+	token := req.URL.Query().Get("access_token")
+	if scheme, credentials, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		token = strings.TrimSpace(credentials)
+	}
+	if token == "" {
+		return Identity{}, &AuthError{
+			Status:    http.StatusUnauthorized,
+			Challenge: fmt.Sprintf("Bearer realm=%q", a.realm),
+			Reason:    "no bearer token",
+		}
+	}
+	user, err := a.verify(token)
+	if err != nil {
+		return Identity{}, &AuthError{
+			Status:    http.StatusUnauthorized,
+			Challenge: fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\"", a.realm),
+			Reason:    "bearer token: " + err.Error(),
+		}
+	}
+	return Identity{User: user, Type: "Bearer"}, nil
+}
+
+// jwtHeader is the JOSE header of a signed JWT.
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// jwtClaims are the registered claims websocketd checks.
+type jwtClaims struct {
+	Sub string          `json:"sub"`
+	Iss string          `json:"iss"`
+	Aud json.RawMessage `json:"aud"` // a string or an array of them
+	Exp *float64        `json:"exp"`
+	Nbf *float64        `json:"nbf"`
+}
+
+// verify checks a compact-serialized JWT's signature and claims and returns
+// its subject.
+func (a *jwtAuth) verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("not a signed JWT")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", fmt.Errorf("header: %w", err)
+	}
+	if len(header.Crit) > 0 {
+		return "", fmt.Errorf("unsupported critical header parameters %q", header.Crit)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range *a.keys.Load() {
+		if header.Kid != "" && k.kid != "" && k.kid != header.Kid {
+			continue
+		}
+		if k.alg != "" && k.alg != header.Alg {
+			continue
+		}
+		if verifyJWS(header.Alg, k.key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", fmt.Errorf("no key verifies its %s signature", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", fmt.Errorf("claims: %w", err)
+	}
+	now := a.now()
+	if claims.Exp != nil && now.After(unixTime(*claims.Exp).Add(jwtLeeway)) {
+		return "", errors.New("expired")
+	}
+	if claims.Nbf != nil && now.Add(jwtLeeway).Before(unixTime(*claims.Nbf)) {
+		return "", errors.New("not valid yet")
+	}
+	if a.issuer != "" && claims.Iss != a.issuer {
+		return "", fmt.Errorf("issuer %q is not %q", claims.Iss, a.issuer)
+	}
+	if a.audience != "" && !audienceIncludes(claims.Aud, a.audience) {
+		return "", fmt.Errorf("audience does not include %q", a.audience)
+	}
+	if claims.Sub == "" {
+		return "", errors.New("no subject")
+	}
+	return claims.Sub, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(seconds * float64(time.Second)))
+}
+
+func audienceIncludes(aud json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) == nil {
+		for _, a := range many {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyJWS checks a JWS signature made with alg. A key of the wrong type
+// for alg never verifies, so a token cannot pass off an RSA public key as
+// an HMAC secret. "none" is not an algorithm here.
+func verifyJWS(alg string, key interface{}, signed, signature []byte) bool {
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, signed, signature)
+	}
+	if len(alg) != 5 {
+		return false
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "HS":
+		k, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS":
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+	case "PS":
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || k.Curve != jwsCurve(alg) {
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// jwsCurve is the curve an ES algorithm signs with.
+func jwsCurve(alg string) elliptic.Curve {
+	switch alg {
+	case "ES256":
+		return elliptic.P256()
+	case "ES384":
+		return elliptic.P384()
+	case "ES512":
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// parseJWKS reads the signature verification keys of a JSON Web Key Set
+// (RFC 7517). Keys marked for encryption, and of types websocketd cannot
+// use, are skipped; a set left with none is an error.
+func parseJWKS(content []byte) ([]jsonWebKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("not a JWKS: %w", err)
+	}
+	var keys []jsonWebKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k.N, k.E)
+		case "EC":
+			key, err = ecKey(k.Crv, k.X, k.Y)
+		case "OKP":
+			if k.Crv != "Ed25519" {
+				continue
+			}
+			var x []byte
+			x, err = base64.RawURLEncoding.DecodeString(k.X)
+			if err == nil && len(x) != ed25519.PublicKeySize {
+				err = errors.New("wrong length")
+			}
+			key = ed25519.PublicKey(x)
+		case "oct":
+			var secret []byte
+			secret, err = base64.RawURLEncoding.DecodeString(k.K)
+			if err == nil && len(secret) < minHMACKeySize {
+				// An empty secret would let anyone sign a token.
+				err = fmt.Errorf("HMAC key of %d bytes is under %d", len(secret), minHMACKeySize)
+			}
+			key = secret
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%q): %w", i, k.Kid, err)
+		}
+		keys = append(keys, jsonWebKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signature verification keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if len(nb) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("malformed RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exponent.Int64())}, nil
+}
+
+// ecKey decodes an EC public key, checking that it is a point on its curve.
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	switch crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, check = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(xb) != size || len(yb) != size {
+		return nil, errors.New("malformed EC key")
+	}
+	point := append(append([]byte{4}, xb...), yb...)
+	if _, err := check.NewPublicKey(point); err != nil {
+		return nil, errors.New("EC key is not on its curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}, nil
+}
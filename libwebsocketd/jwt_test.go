@@ -0,0 +1,247 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var b64 = base64.RawURLEncoding
+
+// testKeys are a signing key of each kind, and the JWKS publishing them.
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	ed   ed25519.PrivateKey
+	hmac []byte
+	jwks []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	k := &testKeys{hmac: []byte("shared secret of thirty-two byte")}
+	var err error
+	if k.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	var edPub ed25519.PublicKey
+	if edPub, k.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	k.jwks, _ = json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64.EncodeToString(k.rsa.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64.EncodeToString(k.ec.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(k.ec.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(edPub)},
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": b64.EncodeToString(k.hmac)},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	return k
+}
+
+// sign makes a JWT with the given header fields and claims.
+func (k *testKeys) sign(t *testing.T, header, claims map[string]interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch header["alg"] {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, k.rsa, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "EdDSA":
+		sig = ed25519.Sign(k.ed, []byte(signed))
+	case "HS256":
+		mac := hmac.New(sha256.New, k.hmac)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func newTestJWTAuth(t *testing.T, k *testKeys) *jwtAuth {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, k.jwks, 0600)
+	a, err := NewAuthenticator("jwt:"+path, AuthOptions{Realm: "test", Issuer: "https://issuer.example", Audience: "websocketd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.(*jwtAuth)
+}
+
+func TestJWTVerify(t *testing.T) {
+	k := newTestKeys(t)
+	a := newTestJWTAuth(t, k)
+	now := time.Now().Unix()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "https://issuer.example", "aud": []string{"other", "websocketd"}, "exp": now + 60}
+		for name, v := range changes {
+			if v == nil {
+				delete(c, name)
+			} else {
+				c[name] = v
+			}
+		}
+		return c
+	}
+	tests := []struct {
+		name   string
+		header map[string]interface{}
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{"RS256", map[string]interface{}{"alg": "RS256", "kid": "rsa"}, claims(nil), true},
+		{"PS256", map[string]interface{}{"alg": "PS256"}, claims(nil), true},
+		{"ES256", map[string]interface{}{"alg": "ES256", "kid": "ec"}, claims(nil), true},
+		{"EdDSA", map[string]interface{}{"alg": "EdDSA"}, claims(nil), true},
+		{"HS256", map[string]interface{}{"alg": "HS256", "kid": "hmac"}, claims(nil), true},
+		{"audience as a string", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"aud": "websocketd"}), true},
+		{"no exp", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"exp": nil}), true},
+		{"exp within leeway", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"exp": now - 30}), true},
+		{"wrong kid", map[string]interface{}{"alg": "RS256", "kid": "ec"}, claims(nil), false},
+		{"alg none", map[string]interface{}{"alg": "none"}, claims(nil), false},
+		{"unknown crit", map[string]interface{}{"alg": "HS256", "crit": []string{"exp"}}, claims(nil), false},
+		{"expired", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"exp": now - 120}), false},
+		{"not yet valid", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"nbf": now + 120}), false},
+		{"wrong issuer", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"iss": "https://evil.example"}), false},
+		{"wrong audience", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"aud": "other"}), false},
+		{"no subject", map[string]interface{}{"alg": "HS256"}, claims(map[string]interface{}{"sub": nil}), false},
+	}
+	for _, tt := range tests {
+		user, err := a.verify(k.sign(t, tt.header, tt.claims))
+		if tt.ok && (err != nil || user != "alice") {
+			t.Errorf("%s: verify = %q, %v; want alice", tt.name, user, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: verify accepted the token", tt.name)
+		}
+	}
+}
+
+func TestJWTTampered(t *testing.T) {
+	k := newTestKeys(t)
+	a := newTestJWTAuth(t, k)
+	token := k.sign(t, map[string]interface{}{"alg": "RS256"}, map[string]interface{}{"sub": "alice", "iss": "https://issuer.example", "aud": "websocketd"})
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(map[string]interface{}{"sub": "admin", "iss": "https://issuer.example", "aud": "websocketd"})
+	if _, err := a.verify(parts[0] + "." + b64.EncodeToString(forged) + "." + parts[2]); err == nil {
+		t.Error("token with altered claims was accepted")
+	}
+
+	// The classic key confusion: an HS256 token whose "secret" is the RSA
+	// public key. The RSA key must not be usable as an HMAC key.
+	k.hmac = k.rsa.N.Bytes()
+	confused := k.sign(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, map[string]interface{}{"sub": "admin", "iss": "https://issuer.example", "aud": "websocketd"})
+	if _, err := a.verify(confused); err == nil {
+		t.Error("HS256 token signed with the RSA public key was accepted")
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	k := newTestKeys(t)
+	a := newTestJWTAuth(t, k)
+	token := k.sign(t, map[string]interface{}{"alg": "ES256"}, map[string]interface{}{"sub": "alice", "iss": "https://issuer.example", "aud": "websocketd"})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if id, err := a.Authenticate(req); err != nil || id != (Identity{User: "alice", Type: "Bearer"}) {
+		t.Errorf("header: got %+v, %v", id, err)
+	}
+	req = httptest.NewRequest("GET", "/?access_token="+token, nil)
+	if id, err := a.Authenticate(req); err != nil || id.User != "alice" {
+		t.Errorf("query: got %+v, %v", id, err)
+	}
+
+	var refused *AuthError
+	_, err := a.Authenticate(httptest.NewRequest("GET", "/", nil))
+	if !errors.As(err, &refused) || refused.Status != http.StatusUnauthorized || refused.Challenge != `Bearer realm="test"` {
+		t.Errorf("no token: got %v", err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token[:len(token)-4])
+	_, err = a.Authenticate(req)
+	if !errors.As(err, &refused) || !strings.Contains(refused.Challenge, `error="invalid_token"`) {
+		t.Errorf("bad token: got %v", err)
+	}
+}
+
+func TestJWTTokenNotPassedOn(t *testing.T) {
+	k := newTestKeys(t)
+	h, url := newTestServer(t, "/bin/sh", "-c", `echo "$QUERY_STRING $REQUEST_URI ${HTTP_AUTHORIZATION:-hidden}"`)
+	h.Config.Auth = newTestJWTAuth(t, k)
+	token := k.sign(t, map[string]interface{}{"alg": "ES256"}, map[string]interface{}{"sub": "alice", "iss": "https://issuer.example", "aud": "websocketd"})
+
+	for _, tt := range []struct {
+		query  string
+		header http.Header
+		want   string
+	}{
+		{"?room=1&access_token=" + token, nil, "room=1&access_token=REDACTED /?room=1&access_token=REDACTED hidden"},
+		{"?room=1", http.Header{"Authorization": {"Bearer " + token}}, "room=1 /?room=1 hidden"},
+	} {
+		client, _, err := websocket.DefaultDialer.Dial(url+tt.query, tt.header)
+		if err != nil {
+			t.Fatalf("dial %s: %v", tt.query, err)
+		}
+		client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, msg, err := client.ReadMessage()
+		client.Close()
+		if err != nil || string(msg) != tt.want {
+			t.Errorf("process saw %q, %v; want %q", msg, err, tt.want)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	for name, content := range map[string]string{
+		"not json":      "keys",
+		"no usable key": `{"keys":[{"kty":"RSA","use":"enc","n":"AQAB","e":"AQAB"}]}`,
+		"off the curve": `{"keys":[{"kty":"EC","crv":"P-256","x":"` + b64.EncodeToString(make([]byte, 32)) + `","y":"` + b64.EncodeToString(make([]byte, 32)) + `"}]}`,
+		"bad exponent":  `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQ"}]}`,
+		"empty secret":  `{"keys":[{"kty":"oct","k":""}]}`,
+		"short secret":  `{"keys":[{"kty":"oct","k":"` + b64.EncodeToString([]byte("secret")) + `"}]}`,
+	} {
+		if _, err := parseJWKS([]byte(content)); err == nil {
+			t.Errorf("%s: parseJWKS should fail", name)
+		}
+	}
+}
@@ -46,6 +46,15 @@ func (l *LogScope) Associate(key string, value string) {
 	l.Associated = append(l.Associated, AssocPair{key, value})
 }
 
+// With returns a copy of this scope with pairs associated in addition, for
+// logging a one-off record (such as a session summary) with extra fields.
+// Unlike Associate, it is safe while other goroutines log on l.
+func (l *LogScope) With(pairs ...AssocPair) *LogScope {
+	c := *l
+	c.Associated = append(append(make([]AssocPair, 0, len(l.Associated)+len(pairs)), l.Associated...), pairs...)
+	return &c
+}
+
 func (l *LogScope) Debug(category string, msg string, args ...interface{}) {
 	l.LogFunc(l, LogDebug, "DEBUG", category, msg, args...)
 }
@@ -126,3 +126,22 @@ func TestTimestamp(t *testing.T) {
 		t.Errorf("Timestamp looks too short: %q", ts)
 	}
 }
+
+func TestWith(t *testing.T) {
+	var got []AssocPair
+	logFunc := func(l *LogScope, level LogLevel, levelName, category, msg string, args ...interface{}) {
+		got = l.Associated
+	}
+	scope := RootLogScope(LogDebug, logFunc)
+	scope.Associate("id", "1")
+
+	scope.With(AssocPair{"duration", "2s"}).Access("session", "DISCONNECT")
+	if len(got) != 2 || got[0] != (AssocPair{"id", "1"}) || got[1] != (AssocPair{"duration", "2s"}) {
+		t.Errorf("With scope logged %v, want id then duration", got)
+	}
+
+	scope.Info("test", "plain")
+	if len(got) != 1 {
+		t.Errorf("With modified the original scope: %v", got)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Results of a WebSocket upgrade request, as counted by
+// websocketd_upgrades_total.
+const (
+	upgradeAccepted     = "accepted"
+	upgradeOrigin       = "origin"        // 403, --origin or --sameorigin
+	upgradeTooMany      = "too_many"      // 429, --maxforks reached
+	upgradeClientConns  = "client_conns"  // 429, --maxclientconns reached
+	upgradeClientRate   = "client_rate"   // 429, --clientrate exceeded
+	upgradeUnauthorized = "unauthorized"  // 401, --auth wanted credentials
+	upgradeForbidden    = "forbidden"     // 403, --auth or --certrule refused the client
+	upgradeNotFound     = "not_found"     // 404, no script for the path
+	upgradeHandshake    = "handshake"     // malformed upgrade request
+	upgradeShuttingDown = "shutting_down" // 503, arrived during a drain
+	upgradeError        = "error"         // 500
+)
+
+// flow counts the messages and bytes relayed in one direction.
+type flow struct {
+	messages atomic.Uint64
+	bytes    atomic.Uint64
+	total    *flow         // server-wide flow to count into as well, if any
+	limit    *sessionLimit // rate limit on this direction of a session, if any
+}
+
+// wait holds a message of n bytes until the flow's limit allows it. See
+// sessionLimit.wait.
+func (f *flow) wait(n int, stop <-chan struct{}) bool {
+	if f == nil {
+		return true
+	}
+	return f.limit.wait(n, stop)
+}
+
+func (f *flow) add(n int) {
+	if f == nil {
+		return
+	}
+	f.messages.Add(1)
+	f.bytes.Add(uint64(n))
+	f.total.add(n)
+}
+
+// counterVec is a counter with a single label.
+type counterVec struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// newCounterVec returns a counterVec that reports each of labels, even
+// before it is first counted, so the series exist from the first scrape.
+func newCounterVec(labels ...string) *counterVec {
+	c := &counterVec{counts: make(map[string]uint64, len(labels))}
+	for _, l := range labels {
+		c.counts[l] = 0
+	}
+	return c
+}
+
+func (c *counterVec) inc(label string) {
+	c.mu.Lock()
+	c.counts[label]++
+	c.mu.Unlock()
+}
+
+// sorted returns the labels in order with their counts, for stable output.
+func (c *counterVec) sorted() ([]string, []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	labels := make([]string, 0, len(c.counts))
+	for l := range c.counts {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	counts := make([]uint64, len(labels))
+	for i, l := range labels {
+		counts[i] = c.counts[l]
+	}
+	return labels, counts
+}
+
+// metrics holds the counters behind MetricsHandler. Gauges (live sessions,
+// forks) are read from the server when scraped rather than kept here. All
+// methods are safe on a nil *metrics, which counts nothing; that keeps a
+// WebsocketdServer built as a struct literal (as tests do) working.
+type metrics struct {
+	upgrades     *counterVec // by upgradeXxx result
+	httpRequests *counterVec // by handler: cgi, static, devconsole, not_found
+	exits        *counterVec // by exit code, or signal name if killed by one
+	terminations *counterVec // by the Terminate step that ended the process
+	launches     *counterVec // by where the process came from: pool or spawn
+	fromClient   flow        // WebSocket messages relayed to processes
+	toClient     flow        // process output relayed to WebSocket clients
+
+	dropped atomic.Uint64 // --broadcast messages not sent to clients too far behind
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		upgrades: newCounterVec(upgradeAccepted, upgradeOrigin, upgradeTooMany,
+			upgradeNotFound, upgradeHandshake, upgradeShuttingDown, upgradeError),
+		httpRequests: newCounterVec("cgi", "static", "devconsole", "not_found"),
+		exits:        newCounterVec(),
+		terminations: newCounterVec("stdin_close", "sigint", "sigterm", "sigkill", "unkillable"),
+		launches:     newCounterVec("pool", "spawn"),
+	}
+}
+
+func (m *metrics) countUpgrade(result string) {
+	if m != nil {
+		m.upgrades.inc(result)
+	}
+}
+
+func (m *metrics) countHTTP(handler string) {
+	if m != nil {
+		m.httpRequests.inc(handler)
+	}
+}
+
+func (m *metrics) countTermination(step string) {
+	if m != nil {
+		m.terminations.inc(step)
+	}
+}
+
+func (m *metrics) countLaunch(source string) {
+	if m != nil {
+		m.launches.inc(source)
+	}
+}
+
+func (m *metrics) countDropped() {
+	if m != nil {
+		m.dropped.Add(1)
+	}
+}
+
+// countExit records how a process ended, as exitStatus describes it.
+func (m *metrics) countExit(state *os.ProcessState) {
+	if m != nil {
+		m.exits.inc(exitStatus(state))
+	}
+}
+
+// flows returns the counters for each direction of a session, or nils.
+func (m *metrics) flows() (fromClient, toClient *flow) {
+	if m == nil {
+		return nil, nil
+	}
+	return &m.fromClient, &m.toClient
+}
+
+// MetricsHandler returns an http.Handler that reports the server's metrics
+// in the Prometheus text exposition format. It is meant to be served on its
+// own listener (--metrics), away from the WebSocket traffic.
+func (h *WebsocketdServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		out := bufio.NewWriter(w)
+		h.writeMetrics(out)
+		out.Flush()
+	})
+}
+
+func (h *WebsocketdServer) writeMetrics(w *bufio.Writer) {
+	m := h.metrics
+	if m == nil {
+		m = newMetrics()
+	}
+
+	h.sessionsMu.Lock()
+	sessions := len(h.sessions)
+	h.sessionsMu.Unlock()
+	h.forksMu.Lock()
+	forks, maxForks := h.forks, h.maxForks
+	h.forksMu.Unlock()
+	if maxForks < 0 {
+		maxForks = 0
+	}
+
+	writeMetric(w, "websocketd_sessions_active", "gauge", "WebSocket sessions currently piped to a process.", sessions)
+	writeMetric(w, "websocketd_forks_active", "gauge", "Processes running for WebSocket sessions and CGI requests.", forks)
+	writeMetric(w, "websocketd_forks_max", "gauge", "The --maxforks limit; 0 means unlimited.", maxForks)
+	writeMetric(w, "websocketd_pool_idle", "gauge", "Pre-forked processes waiting for a session (--poolmin).", h.pools.idleCount())
+	writeCounterVec(w, "websocketd_upgrades_total", "WebSocket upgrade requests, by result.", "result", m.upgrades)
+	writeCounterVec(w, "websocketd_http_requests_total", "Plain HTTP requests, by handler.", "handler", m.httpRequests)
+	writeMetric(w, "websocketd_messages_received_total", "counter", "WebSocket messages relayed from clients to processes.", m.fromClient.messages.Load())
+	writeMetric(w, "websocketd_bytes_received_total", "counter", "Bytes relayed from clients to processes.", m.fromClient.bytes.Load())
+	writeMetric(w, "websocketd_messages_sent_total", "counter", "Messages relayed from processes to clients.", m.toClient.messages.Load())
+	writeMetric(w, "websocketd_bytes_sent_total", "counter", "Bytes relayed from processes to clients.", m.toClient.bytes.Load())
+	writeMetric(w, "websocketd_broadcast_dropped_total", "counter", "Messages of --broadcast processes not sent to clients too far behind.", m.dropped.Load())
+	writeCounterVec(w, "websocketd_process_exits_total", "Ended WebSocket processes, by exit code or the signal that killed them.", "status", m.exits)
+	writeCounterVec(w, "websocketd_process_launches_total", "WebSocket processes handed to sessions, by source: a pre-forked pool, or started for the session.", "source", m.launches)
+	writeCounterVec(w, "websocketd_process_terminations_total", "Ended WebSocket processes, by the termination step that ended them.", "step", m.terminations)
+}
+
+func writeMetric(w *bufio.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func writeCounterVec(w *bufio.Writer, name, help, label string, c *counterVec) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	labels, counts := c.sorted()
+	for i, l := range labels {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(l), counts[i])
+	}
+}
+
+// labelEscaper escapes a label value as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,104 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// scrape returns the server's metrics as text.
+func scrape(t *testing.T, h *WebsocketdServer) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	return rec.Body.String()
+}
+
+func expectMetric(t *testing.T, body, line string) {
+	t.Helper()
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return
+		}
+	}
+	t.Errorf("missing %q in metrics:\n%s", line, body)
+}
+
+func TestMetricsSession(t *testing.T) {
+	h, url := newTestServer(t, "/bin/cat")
+	h.Config.AllowOrigins = []string{"http://allowed.example"}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a 403 for a bad origin, got %v, %v", resp, err)
+	}
+
+	client, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://allowed.example"}})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	waitForSessions(t, h, 1)
+	client.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if _, msg, err := client.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatalf("expected echo, got %q, %v", msg, err)
+	}
+
+	body := scrape(t, h)
+	expectMetric(t, body, "websocketd_sessions_active 1")
+	expectMetric(t, body, "websocketd_forks_active 1")
+	expectMetric(t, body, `websocketd_upgrades_total{result="accepted"} 1`)
+	expectMetric(t, body, `websocketd_upgrades_total{result="origin"} 1`)
+	expectMetric(t, body, "websocketd_messages_received_total 1")
+	expectMetric(t, body, "websocketd_bytes_received_total 6") // "hello\n"
+	expectMetric(t, body, "websocketd_messages_sent_total 1")
+	expectMetric(t, body, "websocketd_bytes_sent_total 5")
+
+	client.Close()
+	waitForSessions(t, h, 0)
+	// cat exits on stdin close, the first termination step.
+	deadline := time.Now().Add(3 * time.Second)
+	for !strings.Contains(scrape(t, h), `websocketd_process_exits_total{status="0"} 1`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("exit not counted:\n%s", scrape(t, h))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	body = scrape(t, h)
+	expectMetric(t, body, `websocketd_process_terminations_total{step="stdin_close"} 1`)
+	expectMetric(t, body, "websocketd_sessions_active 0")
+}
+
+func TestMetricsNilSafe(t *testing.T) {
+	// A server built as a literal has no counters; scraping and counting
+	// must still work.
+	h := &WebsocketdServer{Config: &Config{}}
+	h.metrics.countUpgrade(upgradeAccepted)
+	body := scrape(t, h)
+	expectMetric(t, body, `websocketd_upgrades_total{result="accepted"} 0`)
+	expectMetric(t, body, "# TYPE websocketd_sessions_active gauge")
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	c := newCounterVec()
+	c.inc("a\"b\\c\nd")
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	writeCounterVec(w, "x_total", "help", "l", c)
+	w.Flush()
+	if !strings.Contains(b.String(), `x_total{l="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped:\n%s", b.String())
+	}
+}
@@ -0,0 +1,416 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MultiplexEnvVar is set in the environment of a --multiplex process: the
+// one process of a command that serves all of its sessions. It reads and
+// writes one JSON object per line, each about the session named by id:
+//
+//	{"event":"connect","id":"1","env":{"REMOTE_ADDR":"10.0.0.1",...}}
+//	{"event":"message","id":"1","data":"hello"}
+//	{"event":"pause","id":"1"}
+//	{"event":"resume","id":"1"}
+//	{"event":"disconnect","id":"1","code":1001,"reason":"bye"}
+//
+// connect carries the variables a process started for the session would
+// have had on top of the shared process's own environment, and disconnect
+// the client's close code and reason (code 0 if it sent none). pause asks
+// the process to hold a session's messages while its client catches up,
+// resume to carry on. The process writes message to send to a session's
+// client, and disconnect, with an optional close code and reason, to end
+// the session. With --binary, data is base64.
+const MultiplexEnvVar = "WEBSOCKETD_MULTIPLEX"
+
+// A session's queue holds process messages its client has yet to take. At
+// muxPauseAt of them the process is sent pause, and resume once the client
+// is down to muxResumeAt. A process that writes on until the queue is full
+// has that session closed, rather than holding up everyone else's.
+const (
+	muxQueueSize = 256
+	muxPauseAt   = muxQueueSize / 2
+	muxResumeAt  = muxQueueSize / 8
+)
+
+// muxFrame is one line of the --multiplex protocol, in either direction.
+type muxFrame struct {
+	Event  string            `json:"event"`
+	ID     string            `json:"id"`
+	Env    map[string]string `json:"env,omitempty"`
+	Data   *string           `json:"data,omitempty"`
+	Code   int               `json:"code,omitempty"`
+	Reason string            `json:"reason,omitempty"`
+}
+
+// multiplexer runs one process of a command for all of its sessions
+// (--multiplex). The process is started for the first session, and again
+// for the next one after it exits.
+type multiplexer struct {
+	command string
+	args    []string
+	env     []string // the shared process's environment
+	config  *Config
+	log     *LogScope
+	metrics *metrics
+
+	wmu sync.Mutex // held while a line is written to the process
+
+	mu       sync.Mutex
+	process  *sharedProcess // nil while none is running
+	sessions map[string]*muxSession
+	lastID   int
+	closed   bool
+}
+
+// attach adds a session with the environment env, starting the process if
+// none is running, and tells the process it connected.
+func (m *multiplexer) attach(env []string, bin bool, log *LogScope) (*muxSession, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	if m.process == nil {
+		p, err := startSharedProcess(m.command, m.args, m.env, m.config, false, false, nil, m.log, m.metrics)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		m.process = p
+		go m.read(p)
+	}
+	m.lastID++
+	s := &muxSession{
+		mux:     m,
+		process: m.process,
+		id:      strconv.Itoa(m.lastID),
+		bin:     bin,
+		log:     log,
+		queue:   make(chan []byte, muxQueueSize),
+		output:  make(chan []byte),
+		done:    make(chan struct{}),
+	}
+	if m.sessions == nil {
+		m.sessions = make(map[string]*muxSession)
+	}
+	m.sessions[s.id] = s
+	m.mu.Unlock()
+
+	// Should the process be gone already, read ends the session.
+	m.write(s.process, muxFrame{Event: "connect", ID: s.id, Env: sessionVars(env, m.env)})
+	return s, nil
+}
+
+// write sends the process one line.
+func (m *multiplexer) write(p *sharedProcess, f muxFrame) bool {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	return m.writeLocked(p, f)
+}
+
+func (m *multiplexer) writeLocked(p *sharedProcess, f muxFrame) bool {
+	// json.Marshal cannot fail on the frame's plain fields, and escapes
+	// newlines in strings, so each frame is one line.
+	line, _ := json.Marshal(f)
+	return p.Send(append(line, '\n'))
+}
+
+// read hands the process's lines to their sessions until its stdout
+// closes, then stops it and ends the sessions it was serving.
+func (m *multiplexer) read(p *sharedProcess) {
+	for line := range p.Output() {
+		var f muxFrame
+		if err := json.Unmarshal(line, &f); err != nil {
+			m.log.Error("process", "Ignoring line that is not a multiplex frame: %s", err)
+			continue
+		}
+		m.dispatch(&f)
+	}
+	p.stop()
+	m.log.Info("process", "Shared process %d for %s ended (%s)", p.process.cmd.Process.Pid, m.command, p.ExitStatus())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.process == p {
+		m.process = nil
+	}
+	for _, s := range m.sessions {
+		if s.process == p {
+			m.endLocked(s)
+		}
+	}
+}
+
+func (m *multiplexer) dispatch(f *muxFrame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.sessions[f.ID]
+	if s == nil {
+		return // ended on our side; the process has or will have its disconnect
+	}
+	switch f.Event {
+	case "message":
+		msg, err := s.decode(f.Data)
+		if err != nil {
+			s.log.Error("process", "Ignoring message that is not base64: %s", err)
+			return
+		}
+		if len(s.queue) == cap(s.queue) {
+			s.log.Error("process", "Closing session %s, %d messages behind after pause", s.id, len(s.queue))
+			s.closeCode, s.closeReason = websocket.CloseTryAgainLater, "too far behind"
+			m.endLocked(s)
+			return
+		}
+		s.queue <- msg
+		if len(s.queue) >= muxPauseAt && !s.paused {
+			s.paused = true
+			go m.syncFlow(s)
+		}
+	case "disconnect":
+		s.closeCode, s.closeReason = f.Code, truncateReason(f.Reason)
+		if !sendableCloseCode(s.closeCode) {
+			s.closeCode = websocket.CloseNormalClosure
+		}
+		s.byProcess = true
+		m.endLocked(s)
+	default:
+		s.log.Debug("process", "Ignoring multiplex event %q", f.Event)
+	}
+}
+
+// endLocked ends the process's side of s: its client gets what is queued,
+// then the session closes.
+func (m *multiplexer) endLocked(s *muxSession) {
+	if !s.ended {
+		s.ended = true
+		close(s.queue)
+		delete(m.sessions, s.id)
+	}
+}
+
+// syncFlow tells the process whether to hold s's messages, unless the last
+// pause or resume it was sent says so already.
+func (m *multiplexer) syncFlow(s *muxSession) {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	m.mu.Lock()
+	paused, ended := s.paused, s.ended
+	m.mu.Unlock()
+	if ended || paused == s.sentPaused {
+		return
+	}
+	event := "resume"
+	if paused {
+		event = "pause"
+	}
+	if m.writeLocked(s.process, muxFrame{Event: event, ID: s.id}) {
+		s.sentPaused = paused
+	}
+}
+
+// close stops the process, if running, and refuses sessions from then on.
+func (m *multiplexer) close() {
+	m.mu.Lock()
+	m.closed = true
+	p := m.process
+	m.mu.Unlock()
+	if p != nil {
+		p.stop()
+	}
+}
+
+// kill ends the process, if running, immediately.
+func (m *multiplexer) kill() {
+	m.mu.Lock()
+	p := m.process
+	m.mu.Unlock()
+	if p != nil {
+		p.Kill()
+	}
+}
+
+// muxSession is the Endpoint of one session of a shared process.
+type muxSession struct {
+	mux      *multiplexer
+	process  *sharedProcess // the process serving the session
+	id       string
+	bin      bool
+	log      *LogScope
+	queue    chan []byte // sent to and closed with mux.mu held
+	output   chan []byte
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// guarded by mux.mu
+	ended       bool // the process's side is over; queue is closed
+	byProcess   bool // ended by the process's disconnect
+	paused      bool // the process should hold the session's messages
+	closeCode   int  // close frame for the client, when the process side ended it
+	closeReason string
+
+	sentPaused bool // what the process was last told; guarded by mux.wmu
+
+	// closeStatus, if set, returns the client's close code and reason for
+	// the disconnect line.
+	closeStatus func() (code int, reason string)
+}
+
+func (s *muxSession) StartReading() {
+	go s.forward()
+}
+
+// forward moves the queue to the output channel, asking the process to
+// resume once the client has caught up.
+func (s *muxSession) forward() {
+	defer close(s.output)
+	for msg := range s.queue {
+		select {
+		case s.output <- msg:
+		case <-s.done:
+			return
+		}
+		m := s.mux
+		m.mu.Lock()
+		resume := s.paused && len(s.queue) <= muxResumeAt
+		if resume {
+			s.paused = false
+		}
+		m.mu.Unlock()
+		if resume {
+			go m.syncFlow(s)
+		}
+	}
+}
+
+func (s *muxSession) Output() chan []byte {
+	return s.output
+}
+
+func (s *muxSession) Send(msg []byte) bool {
+	s.mux.mu.Lock()
+	ended := s.ended
+	s.mux.mu.Unlock()
+	if ended {
+		return false
+	}
+	var data string
+	if s.bin {
+		data = base64.StdEncoding.EncodeToString(msg)
+	} else {
+		data = string(bytes.TrimSuffix(msg, []byte{'\n'})) // added by the WebSocketEndpoint
+	}
+	return s.mux.write(s.process, muxFrame{Event: "message", ID: s.id, Data: &data})
+}
+
+// Terminate ends the session, telling the process unless it was the one to
+// end it.
+func (s *muxSession) Terminate() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+		m := s.mux
+		m.mu.Lock()
+		byProcess := s.byProcess
+		m.endLocked(s)
+		m.mu.Unlock()
+		if byProcess {
+			return
+		}
+		f := muxFrame{Event: "disconnect", ID: s.id}
+		if s.closeStatus != nil {
+			f.Code, f.Reason = s.closeStatus()
+		}
+		m.write(s.process, f)
+	})
+}
+
+// closedWith returns the close code and reason for the client when the
+// process's side ended the session, or 0 if it did not.
+func (s *muxSession) closedWith() (code int, reason string) {
+	s.mux.mu.Lock()
+	defer s.mux.mu.Unlock()
+	return s.closeCode, s.closeReason
+}
+
+func (s *muxSession) decode(data *string) ([]byte, error) {
+	if data == nil {
+		return []byte{}, nil
+	}
+	if s.bin {
+		return base64.StdEncoding.DecodeString(*data)
+	}
+	return []byte(*data), nil
+}
+
+// multiplexers holds a multiplexer for each command that has been used,
+// while Config.Multiplex is set.
+type multiplexers struct {
+	mu     sync.Mutex
+	muxes  map[string]*multiplexer
+	closed bool
+}
+
+// get returns the multiplexer for command and args, creating it on first
+// use, or nil if multiplexing is off or the server is shutting down.
+func (mm *multiplexers) get(command string, args []string, config *Config, log *LogScope, metrics *metrics) *multiplexer {
+	if !config.Multiplex {
+		return nil
+	}
+	key := commandKey(command, args)
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if mm.closed {
+		return nil
+	}
+	m := mm.muxes[key]
+	if m == nil {
+		if mm.muxes == nil {
+			mm.muxes = make(map[string]*multiplexer)
+		}
+		m = &multiplexer{command: command, args: args, env: sharedEnv(config, MultiplexEnvVar), config: config, log: log, metrics: metrics}
+		mm.muxes[key] = m
+	}
+	return m
+}
+
+// close stops every shared process, returning once they have ended; get
+// returns nil from then on.
+func (mm *multiplexers) close() {
+	mm.mu.Lock()
+	mm.closed = true
+	muxes := make([]*multiplexer, 0, len(mm.muxes))
+	for _, m := range mm.muxes {
+		muxes = append(muxes, m)
+	}
+	mm.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, m := range muxes {
+		wg.Add(1)
+		go func(m *multiplexer) {
+			defer wg.Done()
+			m.close()
+		}(m)
+	}
+	wg.Wait()
+}
+
+// kill ends every shared process immediately.
+func (mm *multiplexers) kill() {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	for _, m := range mm.muxes {
+		m.kill()
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// muxEcho is a shared process that sends each message back to its session,
+// and disconnects a session that sends "bye".
+const muxEcho = `while read -r line; do
+	id=${line#*\"id\":\"}; id=${id%%\"*}
+	case $line in
+	*'"data":"bye"'*) echo "{\"event\":\"disconnect\",\"id\":\"$id\",\"code\":4000,\"reason\":\"bye\"}" ;;
+	*'"event":"connect"'*) echo "{\"event\":\"message\",\"id\":\"$id\",\"data\":\"pid $$\"}" ;;
+	*'"event":"message"'*) echo "$line" ;;
+	esac
+done`
+
+func TestMultiplexedSessions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	config := &Config{
+		CommandName:      "/bin/sh",
+		CommandArgs:      []string{"-c", muxEcho},
+		HandshakeTimeout: time.Second,
+		CloseCodes:       CloseCodes{"*": 1011},
+		Multiplex:        true,
+	}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/"
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return conn
+	}
+	read := func(conn *websocket.Conn) string {
+		t.Helper()
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(msg)
+	}
+
+	a, b := dial(), dial()
+	if pa, pb := read(a), read(b); pa != pb {
+		t.Errorf("sessions got processes %q and %q, want one shared", pa, pb)
+	}
+	a.WriteMessage(websocket.TextMessage, []byte("to a"))
+	b.WriteMessage(websocket.TextMessage, []byte("to b"))
+	if got := read(b); got != "to b" {
+		t.Errorf("b got %q", got)
+	}
+	if got := read(a); got != "to a" {
+		t.Errorf("a got %q", got)
+	}
+
+	// The process ends a, with its own close code; b carries on.
+	a.WriteMessage(websocket.TextMessage, []byte("bye"))
+	_, _, err := a.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != 4000 || ce.Text != "bye" {
+		t.Errorf("a closed with %v, want 4000 bye", err)
+	}
+	b.WriteMessage(websocket.TextMessage, []byte("still here"))
+	if got := read(b); got != "still here" {
+		t.Errorf("b got %q", got)
+	}
+
+	// Shutdown ends b, then the process; the next session is refused.
+	if err := h.Shutdown(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if m := h.muxes.get(config.CommandName, config.CommandArgs, config, h.Log, h.metrics); m != nil {
+		t.Errorf("multiplexer still handed out after Shutdown")
+	}
+}
+
+func TestMultiplexRoute(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	yes, closeMs := true, uint(300)
+	config := &Config{
+		HandshakeTimeout: time.Second,
+		Multiplex:        true,
+		Routes:           []Route{{Path: "/bin", Command: "/bin/sh", Args: []string{"-c", muxEcho}, Binary: &yes, CloseMs: &closeMs}},
+	}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	srv := httptest.NewServer(h)
+	t.Cleanup(func() {
+		h.Shutdown(context.Background(), false)
+		srv.Close()
+	})
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	// The route's settings apply: its messages are binary (so the pid,
+	// not being base64, is dropped), and its process waits its --closems.
+	conn.WriteMessage(websocket.BinaryMessage, []byte{0, 1, 0xff})
+	if mtype, msg, err := conn.ReadMessage(); err != nil || mtype != websocket.BinaryMessage || string(msg) != "\x00\x01\xff" {
+		t.Errorf("got message of type %d: %q, %v; want the binary echo", mtype, msg, err)
+	}
+	m := h.muxes.muxes[commandKey("/bin/sh", []string{"-c", muxEcho})]
+	if m == nil || m.config.CloseMs != closeMs {
+		t.Errorf("multiplexer did not get the route's settings")
+	}
+}
+
+// muxFlood sends 'count' messages to each session that connects, and logs
+// every line it is sent to 'log'.
+const muxFlood = `log=$1 count=$2
+while read -r line; do
+	echo "$line" >>"$log"
+	case $line in
+	*'"event":"connect"'*)
+		id=${line#*\"id\":\"}; id=${id%%\"*}
+		i=0
+		while [ $i -lt "$count" ]; do
+			echo "{\"event\":\"message\",\"id\":\"$id\",\"data\":\"$i\"}"
+			i=$((i + 1))
+		done ;;
+	esac
+done`
+
+func TestMultiplexFlowControl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	attach := func(t *testing.T, count int) (*muxSession, string) {
+		log := filepath.Join(t.TempDir(), "lines")
+		config := &Config{Multiplex: true}
+		m := (&multiplexers{}).get("/bin/sh", []string{"-c", muxFlood, "sh", log, strconv.Itoa(count)}, config, quietLogScope(), nil)
+		t.Cleanup(m.close)
+		s, err := m.attach(nil, false, quietLogScope())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.StartReading()
+		return s, log
+	}
+	waitForLine := func(t *testing.T, log, event string) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			lines, _ := os.ReadFile(log)
+			if strings.Contains(string(lines), `"event":"`+event+`"`) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("process was not sent %s; got:\n%s", event, lines)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("pause and resume", func(t *testing.T) {
+		s, log := attach(t, muxPauseAt+10)
+		waitForLine(t, log, "pause")
+		for i := 0; i < muxPauseAt+10; i++ {
+			if msg := <-s.Output(); string(msg) != strconv.Itoa(i) {
+				t.Fatalf("message %d is %q", i, msg)
+			}
+		}
+		waitForLine(t, log, "resume")
+		s.Terminate()
+		waitForLine(t, log, "disconnect")
+	})
+
+	t.Run("too far behind", func(t *testing.T) {
+		s, log := attach(t, muxQueueSize+10)
+		deadline := time.Now().Add(3 * time.Second)
+		for code, _ := s.closedWith(); code == 0; code, _ = s.closedWith() {
+			if time.Now().After(deadline) {
+				t.Fatal("session was not closed")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		n := 0
+		for range s.Output() {
+			n++
+		}
+		// A full queue, and the message forward was holding for the client.
+		if n != muxQueueSize+1 {
+			t.Errorf("got %d messages before the session closed, want %d", n, muxQueueSize+1)
+		}
+		if code, _ := s.closedWith(); code != websocket.CloseTryAgainLater {
+			t.Errorf("close code %d, want %d", code, websocket.CloseTryAgainLater)
+		}
+		s.Terminate()
+		waitForLine(t, log, "disconnect")
+	})
+}
+
+func TestMultiplexFrames(t *testing.T) {
+	data := "two\nlines"
+	line, _ := json.Marshal(muxFrame{Event: "message", ID: "7", Data: &data})
+	if strings.Contains(string(line), "\n") {
+		t.Errorf("frame %s spans lines", line)
+	}
+	s := &muxSession{bin: true}
+	bad := "not base64!"
+	if msg, err := s.decode(&bad); err == nil {
+		t.Errorf("decode of non-base64 in binary mode = %q, want an error", msg)
+	}
+	encoded := "AAEC"
+	if msg, err := s.decode(&encoded); err != nil || string(msg) != "\x00\x01\x02" {
+		t.Errorf("decode(%q) = %q, %v", encoded, msg, err)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolEnvVar is set in the environment of a pre-forked process (--poolmin).
+// Such a process starts before its session exists, so instead of the
+// session's CGI variables in its environment it gets them as the first line
+// on stdin: a JSON object of the variables (REMOTE_ADDR, QUERY_STRING,
+// HTTP_*, ...) a process started for the session would have had on top of
+// its own environment.
+const PoolEnvVar = "WEBSOCKETD_POOL"
+
+// poolShrinkAfter is how long a pool must go without running dry before it
+// lowers its number of spares again, one at a time.
+const poolShrinkAfter = 30 * time.Second
+
+// processPool keeps spare processes of one command started and waiting for
+// sessions. It aims for target spares, which starts at minIdle, grows by
+// one each time a session finds the pool empty (up to maxIdle), and shrinks
+// back while it does not.
+type processPool struct {
+	command string
+	args    []string
+	env     []string // the environment every process of the pool starts with
+	minIdle int
+	maxIdle int
+	log     *LogScope
+
+	mu       sync.Mutex
+	idle     []*LaunchedProcess
+	starting int // processes being started for idle
+	target   int
+	lastMiss time.Time
+	closed   bool
+}
+
+func newProcessPool(command string, args, env []string, minIdle, maxIdle int, log *LogScope) *processPool {
+	p := &processPool{command: command, args: args, env: env, minIdle: minIdle, maxIdle: maxIdle, log: log, target: minIdle}
+	p.mu.Lock()
+	p.refillLocked()
+	p.mu.Unlock()
+	return p
+}
+
+// take returns a spare process, or nil if there is none ready; either way
+// the pool starts more in the background.
+func (p *processPool) take() *LaunchedProcess {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var launched *LaunchedProcess
+	if n := len(p.idle); n > 0 {
+		launched = p.idle[0]
+		p.idle = p.idle[1:]
+	} else {
+		p.lastMiss = time.Now()
+		if p.target < p.maxIdle {
+			p.target++
+		}
+	}
+	if p.target > p.minIdle && time.Since(p.lastMiss) > poolShrinkAfter {
+		p.target--
+		p.lastMiss = time.Now() // one step per quiet period
+	}
+	p.refillLocked()
+	return launched
+}
+
+// refillLocked starts processes until the pool has target of them, counting
+// those already starting, and stops extra ones.
+func (p *processPool) refillLocked() {
+	if p.closed {
+		return
+	}
+	for len(p.idle) > p.target {
+		go discardProcess(p.idle[len(p.idle)-1])
+		p.idle = p.idle[:len(p.idle)-1]
+	}
+	for len(p.idle)+p.starting < p.target {
+		p.starting++
+		go p.start()
+	}
+}
+
+func (p *processPool) start() {
+	launched, err := launchCmd(p.command, p.args, p.env)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starting--
+	if err != nil {
+		// Not retried until the next take, so a missing command cannot
+		// spin; that session will launch (and fail) the usual way.
+		p.log.Error("process", "Could not pre-fork %s %s (%s)", p.command, strings.Join(p.args, " "), err)
+		return
+	}
+	if p.closed {
+		go discardProcess(launched)
+		return
+	}
+	p.idle = append(p.idle, launched)
+}
+
+// close stops the pool's spare processes, and any still starting.
+func (p *processPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, launched := range p.idle {
+		go discardProcess(launched)
+	}
+	p.idle = nil
+}
+
+func (p *processPool) idleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// discardProcess ends a pooled process no session will use.
+func discardProcess(launched *LaunchedProcess) {
+	launched.stdin.Close()
+	launched.cmd.Process.Kill()
+	launched.cmd.Wait()
+}
+
+// poolEnv is the environment a pooled process starts with: that of any
+// process except for the session's own variables, which come in the
+// handshake.
+func poolEnv(config *Config) []string {
+	return sharedEnv(config, PoolEnvVar)
+}
+
+// sharedEnv is the environment of a process started before, or for more
+// than, one session: SERVER_SOFTWARE, ParentEnv and Env, and envVar set to
+// 1 to tell the process how it is being run.
+func sharedEnv(config *Config, envVar string) []string {
+	env := make([]string, 0, len(config.ParentEnv)+len(config.Env)+2)
+	env = appendEnv(env, "SERVER_SOFTWARE", config.ServerSoftware)
+	env = append(env, config.ParentEnv...)
+	env = append(env, config.Env...)
+	return appendEnv(env, envVar, "1")
+}
+
+// sessionVars returns the variables of the session's environment env that a
+// process which started with started does not have.
+func sessionVars(env, started []string) map[string]string {
+	have := make(map[string]bool, len(started))
+	for _, kv := range started {
+		have[kv] = true
+	}
+	vars := make(map[string]string)
+	for _, kv := range env {
+		if !have[kv] {
+			k, v, _ := strings.Cut(kv, "=")
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// poolHandshake is the line a pooled process reads first: the variables of
+// the session's environment env that the process did not start with.
+func poolHandshake(env, started []string) []byte {
+	// json.Marshal cannot fail on a map of strings, and escapes any
+	// newline in a value, so the object stays on one line.
+	line, _ := json.Marshal(sessionVars(env, started))
+	return append(line, '\n')
+}
+
+// commandKey identifies command and args among the pools or multiplexers.
+func commandKey(command string, args []string) string {
+	return command + "\x00" + strings.Join(args, "\x00")
+}
+
+// processPools holds a pool for each command that has been used, while
+// Config.PoolMin is set.
+type processPools struct {
+	mu     sync.Mutex
+	pools  map[string]*processPool
+	closed bool
+}
+
+// get returns the pool for command and args, creating it on first use, or
+// nil if pooling is off or the server is shutting down. The pool logs to
+// log, which should outlive any one session.
+func (pp *processPools) get(command string, args []string, config *Config, log *LogScope) *processPool {
+	if config.PoolMin <= 0 {
+		return nil
+	}
+	key := commandKey(command, args)
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if pp.closed {
+		return nil
+	}
+	p := pp.pools[key]
+	if p == nil {
+		if pp.pools == nil {
+			pp.pools = make(map[string]*processPool)
+		}
+		maxIdle := config.PoolMax
+		if maxIdle < config.PoolMin {
+			maxIdle = config.PoolMin
+		}
+		p = newProcessPool(command, args, poolEnv(config), config.PoolMin, maxIdle, log)
+		pp.pools[key] = p
+	}
+	return p
+}
+
+// close stops every pool; get returns nil from then on.
+func (pp *processPools) close() {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.closed = true
+	for _, p := range pp.pools {
+		p.close()
+	}
+}
+
+// warm creates the pools for the commands known up front, COMMAND and those
+// of --route, so that even their first sessions find spares.
+func (pp *processPools) warm(config *Config, log *LogScope) {
+	if config.CommandName != "" {
+		pp.get(config.CommandName, config.CommandArgs, config, log)
+	}
+	for _, r := range config.Routes {
+		if r.Command != "" {
+			pp.get(r.Command, r.Args, config, log)
+		}
+	}
+}
+
+// idleCount is the number of spare processes across the pools.
+func (pp *processPools) idleCount() int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	n := 0
+	for _, p := range pp.pools {
+		n += p.idleCount()
+	}
+	return n
+}
@@ -0,0 +1,194 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPoolHandshake(t *testing.T) {
+	started := []string{"SERVER_SOFTWARE=websocketd/test", "PATH=/bin", "WEBSOCKETD_POOL=1"}
+	env := []string{"SERVER_SOFTWARE=websocketd/test", "PATH=/bin", "REMOTE_ADDR=10.0.0.1", "QUERY_STRING=a=b", "REMOTE_USER=", "HTTP_X=multi\nline"}
+	line := poolHandshake(env, started)
+	if !strings.HasSuffix(string(line), "}\n") || strings.Count(string(line), "\n") != 1 {
+		t.Fatalf("handshake %q should be one line", line)
+	}
+	var vars map[string]string
+	if err := json.Unmarshal(line, &vars); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"REMOTE_ADDR": "10.0.0.1", "QUERY_STRING": "a=b", "REMOTE_USER": "", "HTTP_X": "multi\nline"}
+	if len(vars) != len(want) {
+		t.Errorf("handshake = %v, want %v", vars, want)
+	}
+	for k, v := range want {
+		if got, ok := vars[k]; !ok || got != v {
+			t.Errorf("%s = %q (set %v), want %q", k, got, ok, v)
+		}
+	}
+}
+
+// waitForIdle polls until the pool has n spares.
+func waitForIdle(t *testing.T, p *processPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for p.idleCount() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool has %d spares, want %d", p.idleCount(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessPoolSizing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	p := newProcessPool("/bin/cat", nil, nil, 1, 3, quietLogScope())
+	t.Cleanup(p.close)
+	waitForIdle(t, p, 1)
+
+	var taken []*LaunchedProcess
+	for i := 0; i < 3; i++ {
+		if launched := p.take(); launched != nil {
+			taken = append(taken, launched)
+		}
+	}
+	for _, launched := range taken {
+		discardProcess(launched)
+	}
+	// Two of the takes found the pool empty, so it keeps three spares now.
+	waitForIdle(t, p, 3)
+
+	p.mu.Lock()
+	p.lastMiss = time.Now().Add(-2 * poolShrinkAfter)
+	p.mu.Unlock()
+	if launched := p.take(); launched != nil {
+		discardProcess(launched)
+	}
+	waitForIdle(t, p, 2) // one step down after a quiet period
+}
+
+func TestPooledSession(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	config := &Config{
+		CommandName:      "/bin/sh",
+		CommandArgs:      []string{"-c", `read -r vars; echo "$WEBSOCKETD_POOL $vars"; exec cat`},
+		ParentEnv:        []string{"PATH=/bin:/usr/bin"},
+		HandshakeTimeout: time.Second,
+		PoolMin:          2,
+	}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	t.Cleanup(h.pools.close)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	pool := h.pools.get(config.CommandName, config.CommandArgs, config, h.Log)
+	waitForIdle(t, pool, 2)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/room?id=7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag, handshake, _ := strings.Cut(string(msg), " ")
+	if flag != "1" {
+		t.Errorf("WEBSOCKETD_POOL = %q, want 1", flag)
+	}
+	var vars map[string]string
+	if err := json.Unmarshal([]byte(handshake), &vars); err != nil {
+		t.Fatalf("handshake %q: %v", handshake, err)
+	}
+	if vars["QUERY_STRING"] != "id=7" || vars["PATH_INFO"] != "/room" || vars["REMOTE_ADDR"] != "127.0.0.1" {
+		t.Errorf("handshake = %v, want the session's variables", vars)
+	}
+	if _, ok := vars["PATH"]; ok {
+		t.Errorf("handshake has PATH, which the process started with")
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "ping" {
+		t.Errorf("echo: %q, %v", msg, err)
+	}
+	waitForIdle(t, pool, 2) // replaced
+	body := scrape(t, h)
+	expectMetric(t, body, `websocketd_process_launches_total{source="pool"} 1`)
+	expectMetric(t, body, `websocketd_process_launches_total{source="spawn"} 0`)
+	expectMetric(t, body, `websocketd_pool_idle 2`)
+}
+
+func TestPoolSkipsExitedSpares(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	config := &Config{
+		CommandName:      "/bin/sh",
+		CommandArgs:      []string{"-c", `[ -n "$WEBSOCKETD_POOL" ] && exit 1; echo fresh`},
+		HandshakeTimeout: time.Second,
+		PoolMin:          1,
+	}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	t.Cleanup(h.pools.close)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	waitForIdle(t, h.pools.get(config.CommandName, config.CommandArgs, config, h.Log), 1)
+	time.Sleep(100 * time.Millisecond) // let the spare exit
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "fresh" {
+		t.Errorf("got %q, %v; want a process started for the session", msg, err)
+	}
+	expectMetric(t, scrape(t, h), `websocketd_process_launches_total{source="spawn"} 1`)
+}
+
+func TestPoolFollowsReload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	config := &Config{CommandName: "/bin/cat", HandshakeTimeout: time.Second}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	t.Cleanup(h.pools.close)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	reloaded := *config
+	reloaded.PoolMin = 1
+	h.Reload(&reloaded, 0)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "ping" {
+		t.Fatalf("echo: %q, %v", msg, err)
+	}
+	h.pools.mu.Lock()
+	n := len(h.pools.pools)
+	h.pools.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d pools after a session under a reloaded --poolmin=1, want 1", n)
+	}
+}
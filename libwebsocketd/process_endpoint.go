@@ -7,10 +7,13 @@ package libwebsocketd
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"io"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -24,7 +27,22 @@ type ProcessEndpoint struct {
 	log        *LogScope
 	bin        bool
 	passStderr bool
+	framing    Framing // records of stdin and stdout; nil for lines, or chunks with bin
+	streamByte bool    // with bin and passStderr, tag messages with StreamStdout or StreamStderr
+	extended   bool    // with passStderr, number and time each envelope and end with an exit one
+	rawStdout  bool    // with passStderr, send stdout as it is and wrap only stderr
 	wg         sync.WaitGroup
+	metrics    *metrics // counts how the process ended; may be nil
+	state      atomic.Pointer[os.ProcessState]
+	lastStderr atomic.Pointer[string] // last line the process wrote to stderr
+	waitOnce   sync.Once
+	exited     chan struct{} // closed once the process has been reaped
+	emitMu     sync.Mutex    // held sending a tagged message, so seq follows the order sent
+	seq        uint64        // of the last envelope sent (--envelope=extended)
+
+	// closeNotice, if set, returns a final message to write to stdin
+	// before Terminate closes it (--notifyclose), or nil for none.
+	closeNotice func() []byte
 }
 
 func NewProcessEndpoint(process *LaunchedProcess, bin bool, log *LogScope, passStderr bool) *ProcessEndpoint {
@@ -35,6 +53,7 @@ func NewProcessEndpoint(process *LaunchedProcess, bin bool, log *LogScope, passS
 		log:        log,
 		bin:        bin,
 		passStderr: passStderr,
+		exited:     make(chan struct{}),
 	}
 }
 
@@ -44,15 +63,13 @@ func (pe *ProcessEndpoint) Terminate() {
 	// its buffer) leaks whenever the relay stopped draining Output().
 	pe.doneOnce.Do(func() { close(pe.done) })
 
-	// Buffered so the waiter goroutine can exit even if the process never
-	// gets reaped and this method gives up after SIGKILL.
-	terminated := make(chan struct{}, 1)
-	go func() {
-		if err := pe.process.cmd.Wait(); err != nil {
-			pe.log.Debug("process", "Process exit: %s", err)
+	terminated := pe.wait()
+
+	if pe.closeNotice != nil {
+		if notice := pe.closeNotice(); notice != nil {
+			pe.sendCloseNotice(notice)
 		}
-		terminated <- struct{}{}
-	}()
+	}
 
 	// for some processes this is enough to finish them...
 	if err := pe.process.stdin.Close(); err != nil {
@@ -65,12 +82,13 @@ func (pe *ProcessEndpoint) Terminate() {
 	signals := []struct {
 		signal  os.Signal
 		name    string
+		step    string // label for websocketd_process_terminations_total
 		timeout time.Duration
 	}{
-		{nil, "stdin was closed", 100*time.Millisecond + pe.closetime},
-		{syscall.SIGINT, "SIGINT", 250*time.Millisecond + pe.closetime},
-		{syscall.SIGTERM, "SIGTERM", 500*time.Millisecond + pe.closetime},
-		{syscall.SIGKILL, "SIGKILL", 1000 * time.Millisecond},
+		{nil, "stdin was closed", "stdin_close", 100*time.Millisecond + pe.closetime},
+		{syscall.SIGINT, "SIGINT", "sigint", 250*time.Millisecond + pe.closetime},
+		{syscall.SIGTERM, "SIGTERM", "sigterm", 500*time.Millisecond + pe.closetime},
+		{syscall.SIGKILL, "SIGKILL", "sigkill", 1000 * time.Millisecond},
 	}
 
 	for _, step := range signals {
@@ -82,12 +100,93 @@ func (pe *ProcessEndpoint) Terminate() {
 		select {
 		case <-terminated:
 			pe.log.Debug("process", "Process %v terminated after %s", pid, step.name)
+			pe.metrics.countTermination(step.step)
 			return
 		case <-time.After(step.timeout):
 		}
 	}
 
 	pe.log.Error("process", "SIGKILL did not terminate %v!", pid)
+	pe.metrics.countTermination("unkillable")
+}
+
+// wait starts reaping the process, once, and returns a channel closed when
+// it has been. It must not be called before stdout and stderr have been read
+// to the end, or the process terminated: reaping closes them.
+func (pe *ProcessEndpoint) wait() <-chan struct{} {
+	pe.waitOnce.Do(func() {
+		go func() {
+			if err := pe.process.cmd.Wait(); err != nil {
+				pe.log.Debug("process", "Process exit: %s", err)
+			}
+			if state := pe.process.cmd.ProcessState; state != nil {
+				pe.state.Store(state)
+				pe.metrics.countExit(state)
+			}
+			close(pe.exited)
+		}()
+	})
+	return pe.exited
+}
+
+// closeNoticeTimeout bounds how long Terminate waits to write the close
+// notice to a process that has stopped reading its stdin.
+const closeNoticeTimeout = time.Second
+
+func (pe *ProcessEndpoint) sendCloseNotice(notice []byte) {
+	if pe.framing != nil {
+		framed, err := pe.framing.frame(bytes.TrimSuffix(notice, []byte{'\n'}))
+		if err != nil {
+			pe.log.Error("process", "Cannot frame close notice: %s", err)
+			return
+		}
+		notice = framed
+	}
+	if d, ok := pe.process.stdin.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		d.SetWriteDeadline(time.Now().Add(closeNoticeTimeout))
+	}
+	if _, err := pe.process.stdin.Write(notice); err != nil {
+		pe.log.Debug("process", "Cannot write close notice to STDIN: %s", err)
+	}
+}
+
+// Kill ends the process immediately, skipping Terminate's escalation. It is
+// the last resort once a shutdown deadline has passed.
+func (pe *ProcessEndpoint) Kill() {
+	if err := pe.process.cmd.Process.Kill(); err != nil {
+		pe.log.Debug("process", "Kill %v: %s", pe.process.cmd.Process.Pid, err)
+	}
+}
+
+// ExitStatus describes how the process ended (see exitStatus), or returns ""
+// if it has not been reaped yet.
+func (pe *ProcessEndpoint) ExitStatus() string {
+	if state := pe.ExitState(); state != nil {
+		return exitStatus(state)
+	}
+	return ""
+}
+
+// ExitState returns the process's state once it has been reaped, or nil.
+func (pe *ProcessEndpoint) ExitState() *os.ProcessState {
+	return pe.state.Load()
+}
+
+// LastStderr returns the last line the process wrote to stderr, if any.
+func (pe *ProcessEndpoint) LastStderr() string {
+	if line := pe.lastStderr.Load(); line != nil {
+		return *line
+	}
+	return ""
+}
+
+// exitStatus is the process's exit code, or the name of the signal that
+// killed it (e.g. "killed" for SIGKILL).
+func exitStatus(state *os.ProcessState) string {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return strconv.Itoa(state.ExitCode())
 }
 
 func (pe *ProcessEndpoint) Output() chan []byte {
@@ -95,6 +194,17 @@ func (pe *ProcessEndpoint) Output() chan []byte {
 }
 
 func (pe *ProcessEndpoint) Send(msg []byte) bool {
+	if pe.framing != nil {
+		if !pe.bin {
+			msg = bytes.TrimSuffix(msg, []byte{'\n'}) // added by the WebSocketEndpoint
+		}
+		framed, err := pe.framing.frame(msg)
+		if err != nil {
+			pe.log.Error("process", "Dropping message: %s", err)
+			return true
+		}
+		msg = framed
+	}
 	_, err := pe.process.stdin.Write(msg)
 	if err != nil {
 		pe.log.Debug("process", "Cannot write to STDIN: %s", err)
@@ -107,9 +217,7 @@ func (pe *ProcessEndpoint) StartReading() {
 	if pe.passStderr {
 		// Both streams feed the same output channel, tagged by source, so
 		// it must close only once both readers are done - never while
-		// either might still send. (--binary is rejected together with
-		// --passstderr at config-validation time, so only line-based
-		// reading is needed here.)
+		// either might still send.
 		pe.wg.Add(2)
 		go pe.readStdoutTagged()
 		go pe.readStderrTagged()
@@ -117,7 +225,7 @@ func (pe *ProcessEndpoint) StartReading() {
 		return
 	}
 	go pe.logStderr()
-	if pe.bin {
+	if pe.bin && pe.framing == nil {
 		go pe.readBinaryOutput()
 	} else {
 		go pe.readTextOutput()
@@ -126,14 +234,38 @@ func (pe *ProcessEndpoint) StartReading() {
 
 func (pe *ProcessEndpoint) closeOutputWhenDone() {
 	pe.wg.Wait()
+	if pe.extended && !pe.bin {
+		// Both streams are read to the end, so the process can be reaped
+		// for its exit message. One that closed them and carries on ends
+		// the session only when it exits, or the client leaves.
+		select {
+		case <-pe.wait():
+			pe.emitExit()
+		case <-pe.done:
+		}
+	}
 	close(pe.output)
 }
 
+// nextMessage reads the next message from stdout: a line, or a record of
+// pe.framing.
+func (pe *ProcessEndpoint) nextMessage(bufin *bufio.Reader) ([]byte, error) {
+	if pe.framing != nil {
+		return pe.framing.read(bufin)
+	}
+	buf, err := bufin.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return trimEOL(buf), nil
+}
+
+// readTextOutput sends each line of stdout, or each record with a framing.
 func (pe *ProcessEndpoint) readTextOutput() {
 	defer close(pe.output)
 	bufin := bufio.NewReader(pe.process.stdout)
 	for {
-		buf, err := bufin.ReadBytes('\n')
+		msg, err := pe.nextMessage(bufin)
 		if err != nil {
 			if err != io.EOF {
 				pe.log.Error("process", "Unexpected error while reading STDOUT from process: %s", err)
@@ -143,7 +275,7 @@ func (pe *ProcessEndpoint) readTextOutput() {
 			break
 		}
 		select {
-		case pe.output <- trimEOL(buf):
+		case pe.output <- msg:
 		case <-pe.done:
 			return
 		}
@@ -171,25 +303,130 @@ func (pe *ProcessEndpoint) readBinaryOutput() {
 	}
 }
 
+// With Config.StreamByte, each message of a binary --passstderr session
+// starts with the stream it came from.
+const (
+	StreamStdout = 1
+	StreamStderr = 2
+)
+
+// tag marks data as from stdout or stderr (--passstderr): in a JSON
+// envelope, or with --binary by the type of message the WebSocketEndpoint
+// sends it as, stderr being text, or with streamByte by a leading
+// StreamStdout or StreamStderr. With rawStdout, stdout is left as it is.
+// It is called with emitMu held.
+func (pe *ProcessEndpoint) tag(stream string, data []byte) []byte {
+	if !pe.bin {
+		if pe.rawStdout && stream == "stdout" {
+			return append([]byte(nil), data...)
+		}
+		if !pe.extended {
+			return tagMessage(stream, data)
+		}
+		pe.seq++
+		return marshalTagged(taggedMessage{Stream: stream, Data: string(data), Seq: pe.seq, Time: envelopeTime()})
+	}
+	switch {
+	case pe.streamByte:
+		b := byte(StreamStdout)
+		if stream == "stderr" {
+			b = StreamStderr
+		}
+		return append([]byte{MixedBinary, b}, data...)
+	case stream == "stderr":
+		// A text message must be UTF-8, or the browser drops the connection.
+		return append([]byte{MixedText}, bytes.ToValidUTF8(data, []byte("\uFFFD"))...)
+	default:
+		return append([]byte{MixedBinary}, data...)
+	}
+}
+
 // taggedMessage is the JSON envelope sent to WebSocket clients when
-// --passstderr is enabled, so they can distinguish the two streams.
+// --passstderr is enabled, so they can distinguish the two streams. With
+// --envelope=extended each is numbered from 1 and timed.
 type taggedMessage struct {
 	Stream string `json:"stream"`
 	Data   string `json:"data"`
+	Seq    uint64 `json:"seq,omitempty"`
+	Time   string `json:"time,omitempty"`
+}
+
+// exitMessage is the last envelope of an --envelope=extended session,
+// saying how the process ended: its exit code, or -1 and the signal that
+// killed it, named as in the access log's exit field.
+type exitMessage struct {
+	Stream string `json:"stream"` // "exit"
+	Seq    uint64 `json:"seq"`
+	Time   string `json:"time"`
+	Code   int    `json:"code"`
+	Signal string `json:"signal,omitempty"`
 }
 
 func tagMessage(stream string, data []byte) []byte {
-	// json.Marshal cannot fail here: the struct holds only plain strings
-	// (invalid UTF-8 is replaced, not rejected).
-	msg, _ := json.Marshal(taggedMessage{Stream: stream, Data: string(data)})
+	return marshalTagged(taggedMessage{Stream: stream, Data: string(data)})
+}
+
+func marshalTagged(v any) []byte {
+	// json.Marshal cannot fail here: the structs hold only plain strings
+	// and numbers (invalid UTF-8 is replaced, not rejected).
+	msg, _ := json.Marshal(v)
 	return msg
 }
 
+// envelopeTime is when an extended envelope is sent, in UTC.
+func envelopeTime() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// emit sends data from stream to the client, tagged, and reports false
+// once the endpoint has been terminated.
+func (pe *ProcessEndpoint) emit(stream string, data []byte) bool {
+	pe.emitMu.Lock()
+	defer pe.emitMu.Unlock()
+	select {
+	case pe.output <- pe.tag(stream, data):
+		return true
+	case <-pe.done:
+		return false
+	}
+}
+
+// emitExit sends the exit envelope of a reaped process.
+func (pe *ProcessEndpoint) emitExit() {
+	state := pe.ExitState()
+	if state == nil {
+		return // Wait failed before the process started
+	}
+	pe.emitMu.Lock()
+	defer pe.emitMu.Unlock()
+	pe.seq++
+	msg := exitMessage{Stream: "exit", Seq: pe.seq, Time: envelopeTime(), Code: state.ExitCode()}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		msg.Signal = ws.Signal().String()
+	}
+	select {
+	case pe.output <- marshalTagged(msg):
+	case <-pe.done:
+	}
+}
+
 func (pe *ProcessEndpoint) readStdoutTagged() {
 	defer pe.wg.Done()
 	bufin := bufio.NewReader(pe.process.stdout)
+	var chunk []byte // with --binary and no framing, whatever one read returns
+	if pe.bin && pe.framing == nil {
+		chunk = make([]byte, 10*1024*1024)
+	}
 	for {
-		buf, err := bufin.ReadBytes('\n')
+		var msg []byte
+		var err error
+		if chunk != nil {
+			var n int
+			n, err = bufin.Read(chunk)
+			msg = chunk[:n]
+		} else {
+			msg, err = pe.nextMessage(bufin)
+		}
 		if err != nil {
 			if err != io.EOF {
 				pe.log.Error("process", "Unexpected error while reading STDOUT from process: %s", err)
@@ -198,9 +435,7 @@ func (pe *ProcessEndpoint) readStdoutTagged() {
 			}
 			break
 		}
-		select {
-		case pe.output <- tagMessage("stdout", trimEOL(buf)):
-		case <-pe.done:
+		if !pe.emit("stdout", msg) { // tag copies msg, as chunk is reused
 			return
 		}
 	}
@@ -220,10 +455,9 @@ func (pe *ProcessEndpoint) readStderrTagged() {
 			break
 		}
 		line := trimEOL(buf)
+		pe.noteStderr(line)
 		pe.log.Error("stderr", "%s", string(line)) // still logged server-side, same as without --passstderr
-		select {
-		case pe.output <- tagMessage("stderr", line):
-		case <-pe.done:
+		if !pe.emit("stderr", line) {
 			return
 		}
 	}
@@ -241,7 +475,18 @@ func (pe *ProcessEndpoint) logStderr() {
 			}
 			break
 		}
-		pe.log.Error("stderr", "%s", string(trimEOL(buf)))
+		line := trimEOL(buf)
+		pe.noteStderr(line)
+		pe.log.Error("stderr", "%s", string(line))
+	}
+}
+
+// noteStderr remembers line as the last stderr line, for --closereason.
+// Blank lines are skipped: they would make an empty reason.
+func (pe *ProcessEndpoint) noteStderr(line []byte) {
+	if len(bytes.TrimSpace(line)) > 0 {
+		s := string(line)
+		pe.lastStderr.Store(&s)
 	}
 }
 
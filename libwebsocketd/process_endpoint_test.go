@@ -7,7 +7,9 @@ package libwebsocketd
 
 import (
 	"encoding/json"
+	"fmt"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
@@ -168,3 +170,84 @@ func TestPassStderrTagMessageEscaping(t *testing.T) {
 		t.Errorf("data = %q, want %q", envelope.Data, want)
 	}
 }
+
+func TestPassStderrBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses /bin/sh")
+	}
+	for _, tt := range []struct {
+		streamByte     bool
+		stdout, stderr string
+	}{
+		{false, "b\x00\x01", "tfailed �"},
+		{true, "b\x01\x00\x01", "b\x02failed \xff"},
+	} {
+		launched, err := launchCmd("/bin/sh", []string{"-c", `printf '\000\001'; printf 'failed \377\n' >&2`}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pe := NewProcessEndpoint(launched, true, quietLogScope(), true)
+		pe.streamByte = tt.streamByte
+		pe.StartReading()
+
+		got := map[string]bool{}
+		for msg := range pe.Output() {
+			got[string(msg)] = true
+		}
+		pe.Terminate()
+		if len(got) != 2 || !got[tt.stdout] || !got[tt.stderr] {
+			t.Errorf("streamByte %v: got %v, want %q and %q", tt.streamByte, got, tt.stdout, tt.stderr)
+		}
+	}
+}
+
+func TestPassStderrExtendedEnvelope(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses /bin/sh")
+	}
+	for _, tt := range []struct {
+		script    string
+		rawStdout bool
+		want      []string // the messages in order, less seq and time, keys sorted
+		exit      string
+	}{
+		{`echo out; sleep 0.1; echo err >&2; exit 3`, false,
+			[]string{`{"data":"out","stream":"stdout"}`, `{"data":"err","stream":"stderr"}`}, `{"code":3,"stream":"exit"}`},
+		{`echo out; sleep 0.1; echo err >&2; kill -9 $$`, true,
+			[]string{`out`, `{"data":"err","stream":"stderr"}`}, `{"code":-1,"signal":"killed","stream":"exit"}`},
+	} {
+		launched, err := launchCmd("/bin/sh", []string{"-c", tt.script}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pe := NewProcessEndpoint(launched, false, quietLogScope(), true)
+		pe.extended, pe.rawStdout = true, tt.rawStdout
+		pe.StartReading()
+
+		var got []string
+		seq := uint64(0)
+		for msg := range pe.Output() {
+			var m map[string]any
+			if json.Unmarshal(msg, &m) != nil {
+				got = append(got, string(msg)) // raw stdout
+				continue
+			}
+			seq++
+			if m["seq"] != float64(seq) {
+				t.Errorf("%s: seq of %s is not %d", tt.script, msg, seq)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(m["time"])); err != nil {
+				t.Errorf("%s: time of %s: %v", tt.script, msg, err)
+			}
+			delete(m, "seq")
+			delete(m, "time")
+			b, _ := json.Marshal(m)
+			got = append(got, string(b))
+		}
+		pe.Terminate()
+		want := append(tt.want, tt.exit)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: got %q, want %q", tt.script, got, want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses the --trustedproxy list: comma-separated CIDR
+// networks, or single addresses standing for themselves.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR network", entry)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR network", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IsTrustedProxy reports whether ip is in one of the trusted networks.
+func IsTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedKey is the context key of the *forwarded a trusted proxy's
+// headers described.
+type forwardedKey struct{}
+
+// forwarded is what a trusted proxy said about the client's connection to it.
+type forwarded struct {
+	proxy string // the proxy's own address, which req.RemoteAddr was
+	proto string // "http" or "https", or "" if not given
+}
+
+// fromTrustedProxy returns req as the client sent it to the first trusted
+// proxy, if req came from a trusted proxy: RemoteAddr becomes the client's
+// address, Host the host it asked for, and the scheme it used is kept for
+// requestIsHTTPS. The RFC 7239 Forwarded header is used if present, else
+// X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto. Requests from
+// anywhere else are returned untouched, headers and all: only a trusted proxy
+// can vouch for a client.
+func fromTrustedProxy(req *http.Request, trusted []*net.IPNet) *http.Request {
+	if len(trusted) == 0 {
+		return req
+	}
+	peer := remoteIP(req.RemoteAddr)
+	if peer == nil || !IsTrustedProxy(peer, trusted) {
+		return req
+	}
+
+	var hops []forwardedHop
+	if values := req.Header.Values("Forwarded"); len(values) > 0 {
+		hops = parseForwarded(values)
+	} else {
+		hops = parseXForwarded(req.Header)
+	}
+	if len(hops) == 0 {
+		return req
+	}
+
+	// Walk back from the nearest hop, skipping our own trusted proxies; the
+	// first address they did not add themselves is the client. Anything
+	// further left was written by the client and cannot be believed.
+	client := hops[0]
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = hops[i]
+		ip := net.ParseIP(client.host)
+		if ip == nil || !IsTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+
+	fwd := &forwarded{proxy: req.RemoteAddr, proto: strings.ToLower(client.proto)}
+	r := req.WithContext(context.WithValue(req.Context(), forwardedKey{}, fwd))
+	if client.host != "" {
+		r.RemoteAddr = net.JoinHostPort(client.host, client.port)
+	}
+	if client.requestHost != "" {
+		r.Host = client.requestHost
+	}
+	return r
+}
+
+// requestIsHTTPS reports whether the client used TLS: as a trusted proxy
+// said, or else direct, which the caller passes in.
+func requestIsHTTPS(req *http.Request, direct bool) bool {
+	if fwd, ok := req.Context().Value(forwardedKey{}).(*forwarded); ok && fwd.proto != "" {
+		return fwd.proto == "https" || fwd.proto == "wss"
+	}
+	return direct
+}
+
+// proxyAddr returns the address of the trusted proxy req came through, or "".
+func proxyAddr(req *http.Request) string {
+	if fwd, ok := req.Context().Value(forwardedKey{}).(*forwarded); ok {
+		return fwd.proxy
+	}
+	return ""
+}
+
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedHop is one proxy's account of the connection it received.
+type forwardedHop struct {
+	host, port  string // the connecting client's address, if an IP
+	proto       string // scheme of that connection
+	requestHost string // Host it asked for
+}
+
+// parseForwarded parses RFC 7239 Forwarded header values into hops, nearest
+// last. Obfuscated and "unknown" nodes are kept as hops without an address,
+// so they still stop the walk in fromTrustedProxy.
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			var hop forwardedHop
+			for _, pair := range splitQuoted(element, ';') {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = unquote(strings.TrimSpace(val))
+				switch strings.ToLower(strings.TrimSpace(key)) {
+				case "for":
+					hop.host, hop.port = splitNode(val)
+				case "proto":
+					hop.proto = val
+				case "host":
+					hop.requestHost = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitNode splits an RFC 7239 node ("192.0.2.1", "192.0.2.1:80",
+// "[2001:db8::1]:80") into an IP address and port, or returns "" for an
+// obfuscated or unknown node.
+func splitNode(node string) (host, port string) {
+	if h, p, err := net.SplitHostPort(node); err == nil {
+		node, port = h, p
+	} else {
+		node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	}
+	if net.ParseIP(node) == nil {
+		return "", ""
+	}
+	if strings.HasPrefix(port, "_") { // obfuscated port
+		port = ""
+	}
+	return node, port
+}
+
+// parseXForwarded turns X-Forwarded-For into hops, nearest last. The
+// X-Forwarded-Host and X-Forwarded-Proto headers carry no chain, and describe
+// the client's original request, so they go with the client's hop, whichever
+// that turns out to be.
+func parseXForwarded(h http.Header) []forwardedHop {
+	var hops []forwardedHop
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, node := range strings.Split(value, ",") {
+			var hop forwardedHop
+			hop.host, hop.port = splitNode(strings.TrimSpace(node))
+			hops = append(hops, hop)
+		}
+	}
+	proto := firstListItem(h.Get("X-Forwarded-Proto"))
+	host := firstListItem(h.Get("X-Forwarded-Host"))
+	if len(hops) == 0 && (proto != "" || host != "") {
+		hops = append(hops, forwardedHop{})
+	}
+	for i := range hops {
+		hops[i].proto, hops[i].requestHost = proto, host
+	}
+	return hops
+}
+
+func firstListItem(value string) string {
+	item, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(item)
+}
+
+// splitQuoted splits s at sep, except inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes and backslash escapes of an RFC 7230
+// quoted-string, or returns s if it is not one.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1,2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32"}
+	if len(nets) != len(want) {
+		t.Fatalf("got %v, want %v", nets, want)
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("entry %d = %s, want %s", i, n, want[i])
+		}
+	}
+	for _, bad := range []string{"proxy.example", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) should fail", bad)
+		}
+	}
+}
+
+func TestFromTrustedProxy(t *testing.T) {
+	trusted, _ := ParseTrustedProxies("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		peer       string
+		headers    map[string]string
+		wantRemote string
+		wantHost   string
+		wantHTTPS  bool
+	}{
+		{
+			name:       "untrusted peer is believed as is",
+			peer:       "198.51.100.7:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"},
+			wantRemote: "198.51.100.7:4000",
+			wantHost:   "ws.example",
+		},
+		{
+			name:       "x-forwarded",
+			peer:       "10.0.0.2:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "public.example"},
+			wantRemote: "203.0.113.9:",
+			wantHost:   "public.example",
+			wantHTTPS:  true,
+		},
+		{
+			name:       "spoofed entries left of the client are ignored",
+			peer:       "10.0.0.2:4000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.9, 10.0.0.3"},
+			wantRemote: "203.0.113.9:",
+			wantHost:   "ws.example",
+		},
+		{
+			name:       "forwarded",
+			peer:       "10.0.0.2:4000",
+			headers:    map[string]string{"Forwarded": `for="[2001:db8::9]:5000";proto=https;host=public.example, for=10.0.0.3`},
+			wantRemote: "[2001:db8::9]:5000",
+			wantHost:   "public.example",
+			wantHTTPS:  true,
+		},
+		{
+			name:       "forwarded wins over x-forwarded",
+			peer:       "10.0.0.2:4000",
+			headers:    map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "198.51.100.1"},
+			wantRemote: "203.0.113.9:",
+			wantHost:   "ws.example",
+		},
+		{
+			name:       "obfuscated client keeps the proxy's address",
+			peer:       "10.0.0.2:4000",
+			headers:    map[string]string{"Forwarded": "for=_hidden;proto=https"},
+			wantRemote: "10.0.0.2:4000",
+			wantHost:   "ws.example",
+			wantHTTPS:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://ws.example/", nil)
+			req.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			got := fromTrustedProxy(req, trusted)
+			if got.RemoteAddr != tt.wantRemote {
+				t.Errorf("RemoteAddr = %q, want %q", got.RemoteAddr, tt.wantRemote)
+			}
+			if got.Host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", got.Host, tt.wantHost)
+			}
+			if https := requestIsHTTPS(got, false); https != tt.wantHTTPS {
+				t.Errorf("requestIsHTTPS = %v, want %v", https, tt.wantHTTPS)
+			}
+		})
+	}
+}
+
+func TestRemoteInfoFromForwardedAddress(t *testing.T) {
+	// A forwarded address has no port; REMOTE_PORT is then empty.
+	info, err := GetRemoteInfo("203.0.113.9:", false)
+	if err != nil || info.Addr != "203.0.113.9" || info.Port != "" {
+		t.Errorf("GetRemoteInfo = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientSweepInterval is how often idle clients are forgotten.
+const clientSweepInterval = time.Minute
+
+// clientLimiter enforces the per-client limits: --maxclientconns on
+// concurrent sessions and a --clientrate/--clientburst token bucket on new
+// ones. A client is an address, or the network holding it when
+// --clientprefix4/--clientprefix6 group addresses together. All methods are
+// safe on a nil *clientLimiter, which admits everything.
+type clientLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*clientState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type clientState struct {
+	active int // live sessions
+	bucket tokenBucket
+}
+
+// tokenBucket holds the tokens of a token bucket whose rate and size are
+// kept by its owner, as they may change with the configuration.
+type tokenBucket struct {
+	tokens  float64   // may go negative, as debt, if taken beyond the size
+	updated time.Time // when tokens was last brought up to date
+}
+
+// refill adds the tokens earned since the last refill, up to burst.
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if now.After(b.updated) {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+		b.updated = now
+	}
+}
+
+// deficit is how long the bucket needs at rate to get back to zero tokens.
+func (b *tokenBucket) deficit(rate float64) time.Duration {
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func newClientLimiter() *clientLimiter {
+	return &clientLimiter{clients: make(map[string]*clientState), now: time.Now}
+}
+
+// clientKey returns the client remoteAddr belongs to under the configured
+// prefixes, or false if it has no IP address (a Unix socket peer), which
+// the per-client limits do not apply to.
+func clientKey(remoteAddr string, config *Config) (string, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return "", false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+	bits, prefix := 128, config.ClientPrefix6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits, prefix = ip4, 32, config.ClientPrefix4
+	}
+	if prefix <= 0 || prefix >= bits {
+		return ip.String(), true
+	}
+	network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+	return network.String(), true
+}
+
+// clientBurst is the token bucket's size: ClientBurst, or enough for one
+// second at ClientRate (and at least one) if it is unset.
+func clientBurst(config *Config) float64 {
+	if config.ClientBurst > 0 {
+		return float64(config.ClientBurst)
+	}
+	return math.Max(1, math.Ceil(config.ClientRate))
+}
+
+// admit decides whether key may open another session. If so it counts the
+// session, which release must later uncount. If not, it returns the
+// upgradeXxx result to report and how long the client should wait.
+func (l *clientLimiter) admit(key string, config *Config) (ok bool, result string, retryAfter time.Duration) {
+	if l == nil || (config.MaxClientConns <= 0 && config.ClientRate <= 0) {
+		return true, "", 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now, config)
+
+	burst := clientBurst(config)
+	c := l.clients[key]
+	if c == nil {
+		c = &clientState{bucket: tokenBucket{tokens: burst, updated: now}}
+		l.clients[key] = c
+	}
+	if config.MaxClientConns > 0 && c.active >= config.MaxClientConns {
+		return false, upgradeClientConns, time.Second
+	}
+	if config.ClientRate > 0 {
+		c.bucket.refill(now, config.ClientRate, burst)
+		if c.bucket.tokens < 1 {
+			wait := time.Duration((1 - c.bucket.tokens) / config.ClientRate * float64(time.Second))
+			return false, upgradeClientRate, wait
+		}
+		c.bucket.tokens--
+	}
+	c.active++
+	return true, "", 0
+}
+
+// release uncounts a session that admit let through.
+func (l *clientLimiter) release(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c := l.clients[key]; c != nil && c.active > 0 {
+		c.active--
+	}
+}
+
+// sweep forgets clients with no sessions whose bucket has refilled, as they
+// are indistinguishable from clients never seen. It runs at most once per
+// clientSweepInterval, so the map stays bounded by the recently active.
+func (l *clientLimiter) sweep(now time.Time, config *Config) {
+	if now.Sub(l.lastSweep) < clientSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	burst := clientBurst(config)
+	for key, c := range l.clients {
+		if c.active > 0 {
+			continue
+		}
+		if config.ClientRate <= 0 || c.bucket.tokens+now.Sub(c.bucket.updated).Seconds()*config.ClientRate >= burst {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// sessionLimit limits one direction of one session to a number of messages
+// and of bytes per second (--inmsgrate and friends). Each is a token bucket
+// holding one second's worth, the bytes' at least the largest message the
+// direction carries, so that one message alone is never over. What happens
+// to a message over the limit is up to RateClose: it is held back until the
+// buckets allow it, which stalls the sender through the usual backpressure,
+// or the session is ended. All methods are safe on a nil *sessionLimit,
+// which does not limit.
+type sessionLimit struct {
+	msgRate, byteRate float64 // per second; 0 does not limit
+	byteBurst         float64 // size of the bytes bucket
+	close             bool    // end the session rather than throttle
+	exceeded          atomic.Bool
+
+	mu          sync.Mutex
+	msgs, bytes tokenBucket
+	now         func() time.Time
+}
+
+// newSessionLimit returns a limit of msgRate messages and byteRate bytes a
+// second, or nil if both are 0. maxMessage is the largest message the
+// direction carries, or 0 if that is unbounded.
+func newSessionLimit(msgRate, byteRate float64, maxMessage int64, close bool) *sessionLimit {
+	if msgRate <= 0 && byteRate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	byteBurst := math.Max(byteRate, float64(maxMessage))
+	return &sessionLimit{
+		msgRate:   msgRate,
+		byteRate:  byteRate,
+		byteBurst: byteBurst,
+		close:     close,
+		msgs:      tokenBucket{tokens: math.Max(1, msgRate), updated: now},
+		bytes:     tokenBucket{tokens: byteBurst, updated: now},
+		now:       time.Now,
+	}
+}
+
+// wait takes a message of n bytes from the buckets. If that puts either in
+// debt it either sleeps until the debt is repaid, or marks the limit
+// exceeded when closing. It reports whether the message may be relayed:
+// false if the limit was exceeded or stop was closed while waiting.
+func (l *sessionLimit) wait(n int, stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	now := l.now()
+	var delay time.Duration
+	if l.msgRate > 0 {
+		l.msgs.refill(now, l.msgRate, math.Max(1, l.msgRate))
+		l.msgs.tokens--
+		delay = l.msgs.deficit(l.msgRate)
+	}
+	if l.byteRate > 0 {
+		l.bytes.refill(now, l.byteRate, l.byteBurst)
+		l.bytes.tokens -= float64(n)
+		if d := l.bytes.deficit(l.byteRate); d > delay {
+			delay = d
+		}
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return true
+	}
+	if l.close {
+		l.exceeded.Store(true)
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Exceeded reports whether the session was ended for going over the limit.
+func (l *sessionLimit) Exceeded() bool {
+	return l != nil && l.exceeded.Load()
+}
+
+// admitClient applies the per-client limits to a WebSocket upgrade. If the
+// client is over one it answers 429 with a Retry-After header and returns
+// false; otherwise the returned func must be called when the session ends.
+func (h *WebsocketdServer) admitClient(w http.ResponseWriter, req *http.Request, config *Config, log *LogScope) (release func(), admitted bool) {
+	key, ok := clientKey(req.RemoteAddr, config)
+	if !ok {
+		return func() {}, true
+	}
+	admitted, result, retryAfter := h.clients.admit(key, config)
+	if !admitted {
+		h.metrics.countUpgrade(result)
+		if result == upgradeClientConns {
+			log.Access("session", "REJECTED: %s already has %d session(s)", key, config.MaxClientConns)
+		} else {
+			log.Access("session", "REJECTED: %s is connecting too fast", key)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+		http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() { h.clients.release(key) }, true
+}
+
+// retryAfterSeconds formats a wait for the Retry-After header, which takes
+// whole seconds; it rounds up so a client that obeys it is admitted.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,261 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientKey(t *testing.T) {
+	config := &Config{ClientPrefix4: 24, ClientPrefix6: 64}
+	tests := []struct {
+		remote string
+		want   string
+		ok     bool
+	}{
+		{"192.0.2.7:5000", "192.0.2.0/24", true},
+		{"[2001:db8::1]:5000", "2001:db8::/64", true},
+		{"[::ffff:192.0.2.7]:5000", "192.0.2.0/24", true},
+		{"@", "", false}, // Unix socket peer
+	}
+	for _, tt := range tests {
+		got, ok := clientKey(tt.remote, config)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("clientKey(%q) = %q, %v; want %q, %v", tt.remote, got, ok, tt.want, tt.ok)
+		}
+	}
+	if got, _ := clientKey("192.0.2.7:5000", &Config{}); got != "192.0.2.7" {
+		t.Errorf("clientKey without prefixes = %q, want the address itself", got)
+	}
+}
+
+func TestClientLimiterConns(t *testing.T) {
+	l := newClientLimiter()
+	config := &Config{MaxClientConns: 2}
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.admit("a", config); !ok {
+			t.Fatalf("session %d refused under the limit", i+1)
+		}
+	}
+	if ok, result, _ := l.admit("a", config); ok || result != upgradeClientConns {
+		t.Errorf("third session: ok=%v result=%q, want a client_conns refusal", ok, result)
+	}
+	if ok, _, _ := l.admit("b", config); !ok {
+		t.Error("another client was refused")
+	}
+	l.release("a")
+	if ok, _, _ := l.admit("a", config); !ok {
+		t.Error("session refused after one ended")
+	}
+}
+
+func TestClientLimiterRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newClientLimiter()
+	l.now = func() time.Time { return now }
+	config := &Config{ClientRate: 0.5, ClientBurst: 2} // one every 2s, two at once
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := l.admit("a", config); !ok {
+			t.Fatalf("burst session %d refused", i+1)
+		}
+		l.release("a")
+	}
+	ok, result, retry := l.admit("a", config)
+	if ok || result != upgradeClientRate || retry != 2*time.Second {
+		t.Fatalf("over the rate: ok=%v result=%q retry=%v, want client_rate after 2s", ok, result, retry)
+	}
+	now = now.Add(time.Second)
+	if ok, _, retry := l.admit("a", config); ok || retry != time.Second {
+		t.Errorf("half a token: ok=%v retry=%v, want a refusal for 1s", ok, retry)
+	}
+	now = now.Add(time.Second)
+	if ok, _, _ := l.admit("a", config); !ok {
+		t.Error("refused after the bucket refilled")
+	}
+}
+
+func TestClientLimiterSweep(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newClientLimiter()
+	l.now = func() time.Time { return now }
+	config := &Config{MaxClientConns: 1}
+	l.admit("idle", config)
+	l.release("idle")
+	l.admit("busy", config)
+
+	now = now.Add(2 * clientSweepInterval)
+	l.admit("new", config)
+	if _, ok := l.clients["idle"]; ok {
+		t.Error("idle client was not forgotten")
+	}
+	if _, ok := l.clients["busy"]; !ok {
+		t.Error("client with a live session was forgotten")
+	}
+}
+
+func TestClientLimitUpgrade(t *testing.T) {
+	h, url := newTestServer(t, "/bin/cat")
+	h.Config.MaxClientConns = 1
+
+	first, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	waitForSessions(t, h, 1)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for a second session, got %v, %v", resp, err)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("429 has no Retry-After header")
+	}
+	expectMetric(t, scrape(t, h), `websocketd_upgrades_total{result="client_conns"} 1`)
+
+	// The slot is released just after the session is unregistered, so
+	// allow a moment for it.
+	first.Close()
+	waitForSessions(t, h, 0)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		second, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			second.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial after the first session ended failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionLimitWait(t *testing.T) {
+	if newSessionLimit(0, 0, 0, false) != nil {
+		t.Error("a limit with no rates should be nil")
+	}
+
+	now := time.Unix(1000, 0)
+	l := newSessionLimit(2, 0, 0, true)
+	l.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if !l.wait(10, nil) {
+			t.Fatalf("message %d refused within the burst", i+1)
+		}
+	}
+	if l.wait(10, nil) || !l.Exceeded() {
+		t.Error("third message in the same instant should exceed a close limit")
+	}
+
+	// Throttling sleeps off the debt, unless stopped.
+	l = newSessionLimit(0, 10, 0, false)
+	l.now = func() time.Time { return now }
+	stop := make(chan struct{})
+	close(stop)
+	if l.wait(1000, stop) {
+		t.Error("a stopped wait should refuse the message")
+	}
+	if l.Exceeded() {
+		t.Error("throttling should not mark the limit exceeded")
+	}
+
+	// A message bigger than a second's worth of bytes, but within the
+	// largest message, is let through on an idle session.
+	l = newSessionLimit(0, 10, 1000, true)
+	l.now = func() time.Time { return now }
+	if !l.wait(1000, nil) {
+		t.Error("a message of the largest size should fit the burst")
+	}
+	if l.wait(1, nil) || !l.Exceeded() {
+		t.Error("a message right after should exceed a close limit")
+	}
+}
+
+func TestSessionLimitThrottlesClient(t *testing.T) {
+	h, url := newTestServer(t, "/bin/cat")
+	h.Config.InMsgRate = 20 // a burst of 20, then one every 50ms
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		client.WriteMessage(websocket.TextMessage, []byte("x"))
+	}
+	for i := 0; i < 30; i++ {
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := client.ReadMessage(); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("30 messages relayed in %v; the limit should take about 500ms", elapsed)
+	}
+}
+
+func TestSessionLimitClosesClient(t *testing.T) {
+	h, url := newTestServer(t, "/bin/cat")
+	h.Config.InMsgRate = 1
+	h.Config.RateClose = true
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		client.WriteMessage(websocket.TextMessage, []byte("x"))
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := client.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("expected a 1008 close, got %v", err)
+		}
+		return
+	}
+}
+
+func TestSessionLimitSummary(t *testing.T) {
+	for _, inbound := range []bool{true, false} {
+		h, url := newTestServer(t, "/bin/sh", "-c", "seq 3; cat")
+		rec := &logRecorder{}
+		h.Log = RootLogScope(LogAccess, rec.logFunc)
+		h.Config.RateClose = true
+		if inbound {
+			h.Config.InMsgRate = 1
+		} else {
+			h.Config.OutMsgRate = 1
+		}
+
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		if inbound {
+			for i := 0; i < 3; i++ {
+				client.WriteMessage(websocket.TextMessage, []byte("x"))
+			}
+		}
+		got := rec.summary(t)
+		client.Close()
+		if got["closed_by"] != "limit" || got["close_code"] != "1008" {
+			t.Errorf("inbound %v: summary = %v, want closed_by=limit close_code=1008", inbound, got)
+		}
+	}
+}
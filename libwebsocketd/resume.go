@@ -0,0 +1,420 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ResumeParam is the query parameter a client reconnects with to resume its
+// session (Config.ResumeMs). The first message of every resumable session is
+// a text frame naming the token to use, whatever --binary says:
+//
+//	{"resume":"TOKEN"}
+//	{"resume":"TOKEN","resumed":true,"missed":0}
+//
+// the second form on a resumed connection, with how many messages were
+// dropped while the client was away. A token is good for one reconnect; the
+// resumed connection is given a new one. An unknown or expired token starts
+// a new session.
+const ResumeParam = "websocketd_resume"
+
+// DefaultResumeBuffer is how many messages are kept for a client that is
+// away when Config.ResumeBuffer is not set.
+const DefaultResumeBuffer = 256
+
+// resumeGreeting is the first message of a resumable session.
+type resumeGreeting struct {
+	Token   string `json:"resume"`
+	Resumed bool   `json:"resumed,omitempty"`
+	Missed  int    `json:"missed"`
+}
+
+// resumable is a session's process that outlives its connection
+// (Config.ResumeMs). A connection that drops without a close frame leaves it
+// running for the grace period, holding what it writes meanwhile, the oldest
+// dropped beyond the buffer; a connection with its token takes it up again.
+// A detached process holds a --maxforks slot of its own, as it has no
+// connection to hold one for it.
+type resumable struct {
+	server  *WebsocketdServer
+	process *ProcessEndpoint
+	owner   string // whose token it is: the command and authenticated client
+	grace   time.Duration
+	size    int
+	log     *LogScope
+
+	broken atomic.Bool // the process's stdin could not be written
+
+	token string // guarded by server.resumes.mu
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signalled when queue, attached or exited change
+	queue    [][]byte   // output the client has yet to take
+	missed   int        // messages dropped since the client went away
+	attached *resumeAttachment
+	lastWS   *WebSocketEndpoint // the client's latest connection
+	exited   bool               // the process's output has closed
+	ended    bool
+	held     bool // a --maxforks slot is held for the detached process
+	timer    *time.Timer
+}
+
+// newResumable takes over a newly started process, registering it with a
+// token.
+func newResumable(h *WebsocketdServer, process *ProcessEndpoint, owner string, config *Config, log *LogScope) *resumable {
+	size := config.ResumeBuffer
+	if size <= 0 {
+		size = DefaultResumeBuffer
+	}
+	r := &resumable{
+		server:  h,
+		process: process,
+		owner:   owner,
+		grace:   time.Duration(config.ResumeMs) * time.Millisecond,
+		size:    size,
+		log:     log,
+	}
+	r.cond = sync.NewCond(&r.mu)
+	if config.NotifyClose {
+		process.closeNotice = func() []byte {
+			r.mu.Lock()
+			ws := r.lastWS
+			r.mu.Unlock()
+			if ws == nil {
+				return nil
+			}
+			code, reason := ws.CloseStatus()
+			if code == 0 {
+				return nil // the process ended first
+			}
+			return formatCloseNotice(code, reason)
+		}
+	}
+	h.resumes.add(r)
+	process.StartReading()
+	go r.pump()
+	return r
+}
+
+// pump queues the process's output for the client. While the client is
+// there it holds the process back once the queue is full, as an unbuffered
+// session would; while it is away it drops the oldest message instead.
+func (r *resumable) pump() {
+	for msg := range r.process.Output() {
+		r.mu.Lock()
+		for r.attached != nil && len(r.queue) >= r.size && !r.ended {
+			r.cond.Wait()
+		}
+		if len(r.queue) >= r.size {
+			r.queue = r.queue[1:]
+			r.missed++
+		}
+		r.queue = append(r.queue, msg)
+		r.cond.Broadcast()
+		r.mu.Unlock()
+	}
+	r.mu.Lock()
+	r.exited = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+}
+
+// attach connects ws to the process, taking it from the connection it had,
+// if any. It returns nil if the process has been ended.
+func (r *resumable) attach(ws *WebSocketEndpoint, keep func() bool) (a *resumeAttachment, missed int) {
+	r.mu.Lock()
+	if r.ended {
+		r.mu.Unlock()
+		return nil, 0
+	}
+	if r.timer != nil {
+		r.timer.Stop() // should it have fired, expire finds the process attached
+		r.timer = nil
+	}
+	held := r.held
+	r.held = false
+	prev := r.attached
+	a = &resumeAttachment{
+		r:       r,
+		ws:      ws,
+		keep:    keep,
+		prev:    prev,
+		output:  make(chan []byte),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	r.attached, r.lastWS = a, ws
+	missed, r.missed = r.missed, 0
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	if held {
+		r.server.noteForkCompleted() // the new connection holds one
+	}
+	if prev != nil {
+		r.log.Info("session", "Session taken up by a new connection before the last was seen to drop")
+		prev.ws.Terminate()
+	}
+	return a, missed
+}
+
+// detach is called as a's connection ends: the process is kept for the
+// client to come back to if a says so, or ended.
+func (r *resumable) detach(a *resumeAttachment) {
+	r.mu.Lock()
+	if r.attached != a {
+		r.mu.Unlock()
+		return // taken up by another connection
+	}
+	r.attached = nil
+	r.cond.Broadcast()
+	over := r.ended || (r.exited && len(r.queue) == 0) || r.broken.Load() || !a.keep() || r.server.resumes.isClosed()
+	if !over {
+		if err := r.server.noteForkCreated(); err != nil {
+			r.log.Error("session", "Max of possible forks already active, not keeping process for the client to resume")
+			over = true
+		}
+	}
+	if over {
+		r.mu.Unlock()
+		r.end()
+		return
+	}
+	r.held = true
+	r.timer = time.AfterFunc(r.grace, r.expire)
+	r.mu.Unlock()
+	r.log.Info("session", "Client went away, keeping process %d for %s for it to resume", r.process.process.cmd.Process.Pid, r.grace)
+}
+
+// expire ends the process once the grace period is over, unless the client
+// came back just in time.
+func (r *resumable) expire() {
+	r.mu.Lock()
+	expired := r.attached == nil && !r.ended
+	r.mu.Unlock()
+	if expired {
+		r.log.Info("session", "Client did not resume within %s, ending process", r.grace)
+		r.end()
+	}
+}
+
+// end terminates the process and forgets its token; it can no longer be
+// resumed.
+func (r *resumable) end() {
+	r.mu.Lock()
+	if r.ended {
+		r.mu.Unlock()
+		return
+	}
+	r.ended = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	held := r.held
+	r.held = false
+	r.cond.Broadcast()
+	r.mu.Unlock()
+
+	r.server.resumes.remove(r)
+	if held {
+		r.server.noteForkCompleted()
+	}
+	r.process.Terminate()
+}
+
+// detached reports whether the process is waiting for its client.
+func (r *resumable) detached() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attached == nil && !r.ended
+}
+
+// resumeAttachment is the Endpoint of one connection's turn with a
+// resumable process.
+type resumeAttachment struct {
+	r        *resumable
+	ws       *WebSocketEndpoint
+	keep     func() bool       // whether to keep the process when the connection ends
+	prev     *resumeAttachment // the connection it was taken from, if any
+	output   chan []byte
+	done     chan struct{}
+	doneOnce sync.Once
+	stopped  chan struct{} // closed once forward has returned
+}
+
+func (a *resumeAttachment) StartReading() {
+	go a.forward()
+}
+
+// forward moves the queue to the output channel until the process's output
+// is drained or the connection is over. A message the connection could not
+// take goes back on the queue.
+func (a *resumeAttachment) forward() {
+	defer close(a.stopped)
+	defer close(a.output)
+	if a.prev != nil {
+		<-a.prev.stopped // so it has put back what it was holding
+	}
+	r := a.r
+	for {
+		r.mu.Lock()
+		for r.attached == a && len(r.queue) == 0 && !r.exited {
+			r.cond.Wait()
+		}
+		if r.attached != a || len(r.queue) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		msg := r.queue[0]
+		r.queue = r.queue[1:]
+		r.cond.Broadcast()
+		r.mu.Unlock()
+
+		select {
+		case a.output <- msg:
+		case <-a.done:
+			r.mu.Lock()
+			r.queue = append([][]byte{msg}, r.queue...)
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (a *resumeAttachment) Output() chan []byte {
+	return a.output
+}
+
+func (a *resumeAttachment) Send(msg []byte) bool {
+	if !a.r.process.Send(msg) {
+		a.r.broken.Store(true)
+		return false
+	}
+	return true
+}
+
+// Terminate ends the connection's turn; the process is kept for the client
+// to resume, or ended (see resumable.detach).
+func (a *resumeAttachment) Terminate() {
+	a.doneOnce.Do(func() {
+		close(a.done)
+		a.r.detach(a)
+	})
+}
+
+// greetResumable sends the client the token to resume with.
+func greetResumable(ws *websocket.Conn, token string, resumed bool, missed int) error {
+	// json.Marshal cannot fail on the greeting's plain fields.
+	msg, _ := json.Marshal(resumeGreeting{Token: token, Resumed: resumed, Missed: missed})
+	return ws.WriteMessage(websocket.TextMessage, msg)
+}
+
+// resumables holds the resumable processes by token.
+type resumables struct {
+	mu      sync.Mutex
+	byToken map[string]*resumable
+	closed  atomic.Bool
+}
+
+func (rr *resumables) add(r *resumable) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.byToken == nil {
+		rr.byToken = make(map[string]*resumable)
+	}
+	r.token = newResumeToken()
+	rr.byToken[r.token] = r
+}
+
+// resume finds the process for token, if owner may have it, and gives it a
+// new token for the connection taking it up.
+func (rr *resumables) resume(token, owner string) (r *resumable, newToken string) {
+	if token == "" {
+		return nil, ""
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	r = rr.byToken[token]
+	if r == nil || r.owner != owner {
+		return nil, ""
+	}
+	delete(rr.byToken, token)
+	r.token = newResumeToken()
+	rr.byToken[r.token] = r
+	return r, r.token
+}
+
+// tokenOf returns the token r can be resumed with.
+func (rr *resumables) tokenOf(r *resumable) string {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return r.token
+}
+
+func (rr *resumables) remove(r *resumable) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.byToken[r.token] == r {
+		delete(rr.byToken, r.token)
+	}
+}
+
+func (rr *resumables) isClosed() bool {
+	return rr.closed.Load()
+}
+
+// all returns the registered processes.
+func (rr *resumables) all() []*resumable {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	all := make([]*resumable, 0, len(rr.byToken))
+	for _, r := range rr.byToken {
+		all = append(all, r)
+	}
+	return all
+}
+
+// close ends the processes waiting for their clients, returning once they
+// have ended; none is kept from then on.
+func (rr *resumables) close() {
+	rr.closed.Store(true)
+	var wg sync.WaitGroup
+	for _, r := range rr.all() {
+		if r.detached() {
+			wg.Add(1)
+			go func(r *resumable) {
+				defer wg.Done()
+				r.end()
+			}(r)
+		}
+	}
+	wg.Wait()
+}
+
+// kill ends every resumable process immediately.
+func (rr *resumables) kill() {
+	for _, r := range rr.all() {
+		r.process.Kill()
+	}
+}
+
+// newResumeToken returns an unguessable token.
+func newResumeToken() string {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,240 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// resumeEcho says its pid, then echoes each line; "later N" writes N lines
+// after a moment, for a client that has gone away.
+const resumeEcho = `echo "pid $$"
+while read -r line; do
+	case $line in
+	later*) (sleep 0.3; i=0; while [ $i -lt ${line#later } ]; do echo "late $i"; i=$((i + 1)); done) & ;;
+	*) echo "$line" ;;
+	esac
+done`
+
+func TestResume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	start := func(t *testing.T, resumeMs uint, buffer int) (*WebsocketdServer, string) {
+		config := &Config{
+			CommandName:      "/bin/sh",
+			CommandArgs:      []string{"-c", resumeEcho},
+			HandshakeTimeout: time.Second,
+			ResumeMs:         resumeMs,
+			ResumeBuffer:     buffer,
+		}
+		h := NewWebsocketdServer(config, quietLogScope(), 0)
+		srv := httptest.NewServer(h)
+		t.Cleanup(func() {
+			h.Shutdown(context.Background(), false)
+			srv.Close()
+		})
+		return h, "ws" + strings.TrimPrefix(srv.URL, "http") + "/"
+	}
+	dial := func(t *testing.T, url, token string) (*websocket.Conn, resumeGreeting) {
+		t.Helper()
+		if token != "" {
+			url += "?" + ResumeParam + "=" + token
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		var g resumeGreeting
+		if mtype, msg, err := conn.ReadMessage(); err != nil || mtype != websocket.TextMessage || json.Unmarshal(msg, &g) != nil || g.Token == "" {
+			t.Fatalf("greeting %q, %v; want a resume token", msg, err)
+		}
+		return conn, g
+	}
+	read := func(t *testing.T, conn *websocket.Conn) string {
+		t.Helper()
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(msg)
+	}
+	// drop goes away without a close frame, as a client that lost its
+	// network would, and waits for the server to notice.
+	drop := func(t *testing.T, h *WebsocketdServer, conn *websocket.Conn) {
+		t.Helper()
+		conn.UnderlyingConn().Close()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			all := h.resumes.all()
+			if len(all) == 1 && all[0].detached() {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("process was not kept for the client")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		h, url := start(t, 3000, 0)
+		a, g := dial(t, url, "")
+		pid := read(t, a)
+		a.WriteMessage(websocket.TextMessage, []byte("later 2"))
+		drop(t, h, a)
+
+		b, resumed := dial(t, url, g.Token)
+		if !resumed.Resumed || resumed.Missed != 0 || resumed.Token == g.Token {
+			t.Errorf("resumed with %+v, want resumed with a new token and nothing missed", resumed)
+		}
+		for _, want := range []string{"late 0", "late 1"} {
+			if got := read(t, b); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}
+		b.WriteMessage(websocket.TextMessage, []byte("still here"))
+		if got := read(t, b); got != "still here" {
+			t.Errorf("got %q", got)
+		}
+
+		// A token is good once.
+		c, fresh := dial(t, url, g.Token)
+		if fresh.Resumed {
+			t.Errorf("used token resumed the session")
+		}
+		if got := read(t, c); got == pid {
+			t.Errorf("new session got the old process, %s", got)
+		}
+	})
+
+	t.Run("taken over", func(t *testing.T) {
+		// The client is back before its old connection was seen to drop.
+		_, url := start(t, 3000, 0)
+		a, g := dial(t, url, "")
+		read(t, a)
+		b, resumed := dial(t, url, g.Token)
+		if !resumed.Resumed {
+			t.Fatalf("did not resume: %+v", resumed)
+		}
+		if _, _, err := a.ReadMessage(); err == nil {
+			t.Errorf("old connection still open")
+		}
+		b.WriteMessage(websocket.TextMessage, []byte("moved"))
+		if got := read(t, b); got != "moved" {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("closed by the client", func(t *testing.T) {
+		h, url := start(t, 3000, 0)
+		a, _ := dial(t, url, "")
+		read(t, a)
+		a.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		deadline := time.Now().Add(3 * time.Second)
+		for len(h.resumes.all()) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("process was kept after the client closed the session")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("missed", func(t *testing.T) {
+		h, url := start(t, 3000, 2)
+		a, g := dial(t, url, "")
+		read(t, a)
+		a.WriteMessage(websocket.TextMessage, []byte("later 5"))
+		drop(t, h, a)
+		time.Sleep(500 * time.Millisecond) // for all five
+
+		b, resumed := dial(t, url, g.Token)
+		if resumed.Missed != 3 {
+			t.Errorf("missed %d messages, want 3", resumed.Missed)
+		}
+		for _, want := range []string{"late 3", "late 4"} {
+			if got := read(t, b); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		h, url := start(t, 100, 0)
+		a, g := dial(t, url, "")
+		read(t, a)
+		drop(t, h, a)
+		deadline := time.Now().Add(3 * time.Second)
+		for len(h.resumes.all()) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("process was kept past the grace period")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, fresh := dial(t, url, g.Token); fresh.Resumed {
+			t.Errorf("expired token resumed the session")
+		}
+	})
+}
+
+// TestResumeTokenRedacted checks that a resume token on the URL, which would
+// let whoever saw it take over the session, reaches neither the log nor the
+// process.
+func TestResumeTokenRedacted(t *testing.T) {
+	h, url := newTestServer(t, "/bin/sh", "-c", `echo "$QUERY_STRING $REQUEST_URI"`)
+	h.Config.ResumeMs = 1000
+	t.Cleanup(func() { h.Shutdown(context.Background(), false) })
+	var mu sync.Mutex
+	var logged []string
+	h.Log = RootLogScope(LogDebug, func(l *LogScope, level LogLevel, levelName, category, msg string, args ...interface{}) {
+		line := fmt.Sprintf(msg, args...)
+		for s := l; s != nil; s = s.Parent {
+			for _, p := range s.Associated {
+				line += " " + p.Key + "=" + p.Value
+			}
+		}
+		mu.Lock()
+		logged = append(logged, line)
+		mu.Unlock()
+	})
+
+	const token = "s3cr3t-token"
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?room=1&"+ResumeParam+"="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	conn.ReadMessage() // the greeting, with a token of its own
+	_, msg, err := conn.ReadMessage()
+	want := "room=1&" + ResumeParam + "=REDACTED /?room=1&" + ResumeParam + "=REDACTED"
+	if err != nil || string(msg) != want {
+		t.Errorf("process saw %q, %v; want %q", msg, err, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logged) == 0 {
+		t.Fatal("nothing was logged")
+	}
+	for _, line := range logged {
+		if strings.Contains(line, token) {
+			t.Errorf("token logged: %s", line)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"strings"
+	"time"
+)
+
+// Route overrides settings for the WebSocket scripts at and below Path, so
+// that scripts under one --dir can differ (say, a binary video feed among
+// line-based chat scripts). Nil fields keep the server-wide setting. A route
+// with a Command (--route) serves the URLs at and below Path itself, ahead
+// of --dir or CommandName.
+type Route struct {
+	Path         string         // a script path, as URLInfo.ScriptPath
+	Command      string         // run for this path instead of a script or CommandName ("" = none)
+	Args         []string       // arguments to Command
+	Binary       *bool          // overrides Config.Binary
+	PassStderr   *bool          // overrides Config.PassStderr
+	CloseMs      *uint          // overrides Config.CloseMs
+	PingInterval *time.Duration // overrides Config.PingInterval
+	MaxFrameSize *int64         // overrides Config.MaxFrameSize
+	Headers      []string       // added to the upgrade response, after Headers and HeadersWs
+}
+
+// routeFor returns the route covering scriptPath, the one with the longest
+// path at or above it, or nil if none does.
+func routeFor(scriptPath string, routes []Route) *Route {
+	var best *Route
+	for i, r := range routes {
+		if pathCovers(r.Path, scriptPath) && (best == nil || len(r.Path) > len(best.Path)) {
+			best = &routes[i]
+		}
+	}
+	return best
+}
+
+// commandRouteFor returns the route with a Command that covers path, the
+// one with the longest path at or above it, or nil if none does.
+func commandRouteFor(path string, routes []Route) *Route {
+	var best *Route
+	for i, r := range routes {
+		if r.Command != "" && pathCovers(r.Path, path) && (best == nil || len(r.Path) > len(best.Path)) {
+			best = &routes[i]
+		}
+	}
+	return best
+}
+
+// hasCommandRoutes reports whether any route has a Command.
+func hasCommandRoutes(routes []Route) bool {
+	for _, r := range routes {
+		if r.Command != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// pathCovers reports whether the script path prefix (as given to
+// --certrule, or a route) covers scriptPath: it is the same path, a
+// directory above it, or "/".
+func pathCovers(prefix, scriptPath string) bool {
+	return prefix == "/" || scriptPath == prefix || strings.HasPrefix(scriptPath, prefix+"/")
+}
+
+// forScript returns the settings that apply to the script at scriptPath:
+// config itself, or a copy with the covering route's overrides applied.
+func (config *Config) forScript(scriptPath string) *Config {
+	r := routeFor(scriptPath, config.Routes)
+	if r == nil {
+		return config
+	}
+	c := *config
+	if r.Binary != nil {
+		c.Binary = *r.Binary
+	}
+	if r.PassStderr != nil {
+		c.PassStderr = *r.PassStderr
+	}
+	if r.CloseMs != nil {
+		c.CloseMs = *r.CloseMs
+	}
+	if r.PingInterval != nil {
+		c.PingInterval = *r.PingInterval
+	}
+	if r.MaxFrameSize != nil {
+		c.MaxFrameSize = *r.MaxFrameSize
+	}
+	if len(r.Headers) > 0 {
+		c.HeadersWs = append(append([]string(nil), config.HeadersWs...), r.Headers...)
+	}
+	return &c
+}
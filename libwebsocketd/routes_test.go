@@ -0,0 +1,102 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouteFor(t *testing.T) {
+	routes := []Route{{Path: "/"}, {Path: "/video/live"}, {Path: "/video"}}
+	tests := []struct{ path, want string }{
+		{"/chat", "/"},
+		{"/video", "/video"},
+		{"/video/archive", "/video"},
+		{"/video/live", "/video/live"},
+		{"/videos", "/"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if r := routeFor(tt.path, routes); r != nil {
+			got = r.Path
+		}
+		if got != tt.want {
+			t.Errorf("routeFor(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if r := routeFor("/chat", routes[1:]); r != nil {
+		t.Errorf("routeFor(/chat) = %q, want none", r.Path)
+	}
+}
+
+func TestForScript(t *testing.T) {
+	binary := true
+	ping := 10 * time.Second
+	config := &Config{
+		CloseMs:      100,
+		MaxFrameSize: 1 << 20,
+		HeadersWs:    []string{"X-Server: 1"},
+		Routes: []Route{
+			{Path: "/video", Binary: &binary, PingInterval: &ping, Headers: []string{"X-Route: video"}},
+		},
+	}
+
+	if got := config.forScript("/chat"); got != config {
+		t.Errorf("forScript(/chat) should be the server's own config")
+	}
+
+	video := config.forScript("/video/hd")
+	if !video.Binary || video.PingInterval != ping {
+		t.Errorf("video: Binary = %v, PingInterval = %v; want the route's", video.Binary, video.PingInterval)
+	}
+	if video.CloseMs != 100 || video.MaxFrameSize != 1<<20 {
+		t.Errorf("video: CloseMs = %d, MaxFrameSize = %d; want the server's", video.CloseMs, video.MaxFrameSize)
+	}
+	if len(video.HeadersWs) != 2 || video.HeadersWs[0] != "X-Server: 1" || video.HeadersWs[1] != "X-Route: video" {
+		t.Errorf("video: HeadersWs = %v, want the server's then the route's", video.HeadersWs)
+	}
+	if config.Binary || len(config.HeadersWs) != 1 {
+		t.Errorf("forScript changed the server's config: %+v", config)
+	}
+}
+
+func TestCommandRoutes(t *testing.T) {
+	config := &Config{Routes: []Route{
+		{Path: "/chat", Command: "/bin/cat"},
+		{Path: "/feed", Command: "/usr/bin/tail", Args: []string{"-F", "log"}},
+		{Path: "/feed/raw", Binary: new(bool)}, // settings only
+	}}
+	tests := []struct {
+		path                 string
+		scriptPath, pathInfo string
+		notFound             bool
+	}{
+		{"/chat", "/chat", "", false},
+		{"/chat/room/1", "/chat", "/room/1", false},
+		{"/feed/raw", "/feed", "/raw", false},
+		{"/chatter", "", "", true},
+		{"/", "", "", true},
+	}
+	for _, tt := range tests {
+		info, err := GetURLInfo(tt.path, config)
+		if tt.notFound {
+			if err != ErrScriptNotFound {
+				t.Errorf("GetURLInfo(%q) = %+v, %v; want not found", tt.path, info, err)
+			}
+			continue
+		}
+		if err != nil || info.ScriptPath != tt.scriptPath || info.PathInfo != tt.pathInfo || info.FilePath != "" {
+			t.Errorf("GetURLInfo(%q) = %+v, %v; want script %q, path info %q", tt.path, info, err, tt.scriptPath, tt.pathInfo)
+		}
+	}
+
+	// With a COMMAND as well, it serves every other path.
+	config.CommandName = "/bin/echo"
+	if info, err := GetURLInfo("/chatter", config); err != nil || info.ScriptPath != "/" || info.PathInfo != "/chatter" {
+		t.Errorf("GetURLInfo(/chatter) with COMMAND = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// session is one live WebSocket connection piped to its process. The server
+// keeps a registry of them so a shutdown can reach every connection that was
+// hijacked away from net/http (http.Server.Shutdown cannot see those).
+type session struct {
+	ws      *WebSocketEndpoint
+	process *ProcessEndpoint // nil for a session of a shared process (--multiplex)
+
+	start      time.Time
+	fromClient flow        // WebSocket messages relayed to the process
+	toClient   flow        // process output relayed to the client
+	drained    atomic.Bool // ended by Shutdown
+}
+
+// Values of the closed_by field in a session's summary.
+const (
+	closedByClient  = "client"  // the client closed, went away or stopped accepting messages
+	closedByProcess = "process" // the process closed its stdout (usually by exiting) or its stdin
+	closedByServer  = "server"  // websocketd ended the session, e.g. shutting down
+	closedByLimit   = "limit"   // either direction went over a rate with --ratemode=close
+)
+
+// logSummary writes the session's DISCONNECT access record: how long it
+// lasted, what was relayed each way, how the process exited, which side
+// ended it, and the WebSocket close code and reason, where known.
+func (s *session) logSummary(log *LogScope, closedBy string) {
+	pairs := []AssocPair{
+		{"duration", time.Since(s.start).Round(time.Millisecond).String()},
+		{"msgs_in", strconv.FormatUint(s.fromClient.messages.Load(), 10)},
+		{"bytes_in", strconv.FormatUint(s.fromClient.bytes.Load(), 10)},
+		{"msgs_out", strconv.FormatUint(s.toClient.messages.Load(), 10)},
+		{"bytes_out", strconv.FormatUint(s.toClient.bytes.Load(), 10)},
+	}
+	if s.process != nil {
+		if status := s.process.ExitStatus(); status != "" {
+			pairs = append(pairs, AssocPair{"exit", status})
+		}
+	}
+	pairs = append(pairs, AssocPair{"closed_by", closedBy})
+	if s.ws != nil {
+		if code, reason := s.ws.CloseStatus(); code != 0 {
+			pairs = append(pairs, AssocPair{"close_code", strconv.Itoa(code)})
+			if reason != "" {
+				pairs = append(pairs, AssocPair{"close_reason", reason})
+			}
+		}
+	}
+	log.With(pairs...).Access("session", "DISCONNECT")
+}
+
+// limitClose returns the close code and reason for a session that went over
+// a rate limit set to close it, or 0 if it did not.
+func (s *session) limitClose() (code int, reason string) {
+	if s.fromClient.limit.Exceeded() {
+		return websocket.ClosePolicyViolation, "inbound rate limit exceeded"
+	}
+	if s.toClient.limit.Exceeded() {
+		return websocket.ClosePolicyViolation, "outbound rate limit exceeded"
+	}
+	return 0, ""
+}
+
+// addSession registers a session that is about to be piped. It refuses once
+// Shutdown has begun, so a connection accepted just before the drain started
+// cannot slip past it.
+func (h *WebsocketdServer) addSession(s *session) bool {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	if h.draining {
+		return false
+	}
+	if h.sessions == nil {
+		h.sessions = make(map[*session]struct{})
+	}
+	h.sessions[s] = struct{}{}
+	h.sessionsWG.Add(1)
+	return true
+}
+
+func (h *WebsocketdServer) removeSession(s *session) {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	if _, ok := h.sessions[s]; ok {
+		delete(h.sessions, s)
+		h.sessionsWG.Done()
+	}
+}
+
+// isDraining reports whether Shutdown has begun.
+func (h *WebsocketdServer) isDraining() bool {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	return h.draining
+}
+
+// rejectDraining answers an upgrade request that arrived after Shutdown began.
+func rejectDraining(w http.ResponseWriter, log *LogScope) {
+	log.Access("session", "REJECTED: %s", ErrShuttingDown)
+	http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+}
+
+// Shutdown stops the server accepting new WebSocket sessions and ends the live
+// ones. Each client is optionally sent a 1001 (going away) close frame, then
+// its connection is closed, which terminates its process through the usual
+// ProcessEndpoint.Terminate escalation; shared processes (--multiplex,
+// --broadcast) and those kept for clients to resume (--resumems) are
+// terminated the same way once the sessions are gone. Shutdown returns once
+// every session and process has ended, or when ctx is done — in which case
+// the processes still running are killed outright and ctx's error is
+// returned.
+//
+// Shutdown does not stop listeners or in-flight CGI requests; that is the job
+// of the http.Server the handler is mounted on.
+func (h *WebsocketdServer) Shutdown(ctx context.Context, goingAway bool) error {
+	h.pools.close()
+	h.resumes.closed.Store(true)
+	h.sessionsMu.Lock()
+	h.draining = true
+	live := make([]*session, 0, len(h.sessions))
+	for s := range h.sessions {
+		live = append(live, s)
+	}
+	h.sessionsMu.Unlock()
+
+	h.Log.Info("server", "Draining %d session(s)", len(live))
+	for _, s := range live {
+		s.drained.Store(true)
+		if goingAway {
+			go s.ws.Close(websocket.CloseGoingAway, "server shutting down")
+		} else {
+			go s.ws.Terminate()
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		h.sessionsWG.Wait()
+		h.resumes.close()
+		h.muxes.close()
+		h.casts.close()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	h.sessionsMu.Lock()
+	h.Log.Error("server", "Drain deadline passed, killing %d remaining process(es)", len(h.sessions))
+	for s := range h.sessions {
+		if s.process != nil {
+			s.process.Kill()
+		}
+	}
+	h.sessionsMu.Unlock()
+	h.resumes.kill()
+	h.muxes.kill()
+	h.casts.kill()
+	return ctx.Err()
+}
@@ -0,0 +1,221 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves a WebsocketdServer running command over httptest and
+// returns it with its ws:// URL.
+func newTestServer(t *testing.T, command string, args ...string) (*WebsocketdServer, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX commands")
+	}
+	config := &Config{CommandName: command, CommandArgs: args, HandshakeTimeout: time.Second}
+	h := NewWebsocketdServer(config, quietLogScope(), 0)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// waitForSessions polls until the server has registered n live sessions.
+func waitForSessions(t *testing.T, h *WebsocketdServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		h.sessionsMu.Lock()
+		got := len(h.sessions)
+		h.sessionsMu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server never registered %d session(s)", n)
+}
+
+// logRecorder collects the associated pairs of every DISCONNECT record.
+type logRecorder struct {
+	mu          sync.Mutex
+	disconnects []map[string]string
+}
+
+func (r *logRecorder) logFunc(l *LogScope, level LogLevel, levelName, category, msg string, args ...interface{}) {
+	if msg != "DISCONNECT" {
+		return
+	}
+	fields := make(map[string]string)
+	for _, p := range l.Associated {
+		fields[p.Key] = p.Value
+	}
+	r.mu.Lock()
+	r.disconnects = append(r.disconnects, fields)
+	r.mu.Unlock()
+}
+
+// summary waits for the first DISCONNECT record and returns its fields.
+func (r *logRecorder) summary(t *testing.T) map[string]string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		n := len(r.disconnects)
+		r.mu.Unlock()
+		if n > 0 {
+			return r.disconnects[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no DISCONNECT record was logged")
+	return nil
+}
+
+// newRecordingServer is newTestServer with a logRecorder attached.
+func newRecordingServer(t *testing.T, command string, args ...string) (*logRecorder, string) {
+	t.Helper()
+	h, url := newTestServer(t, command, args...)
+	rec := &logRecorder{}
+	h.Log = RootLogScope(LogAccess, rec.logFunc)
+	return rec, url
+}
+
+func TestSessionSummaryClientClose(t *testing.T) {
+	rec, url := newRecordingServer(t, "/bin/cat")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	client.WriteMessage(websocket.TextMessage, []byte("hi"))
+	if _, msg, err := client.ReadMessage(); err != nil || string(msg) != "hi" {
+		t.Fatalf("expected echo, got %q, %v", msg, err)
+	}
+	client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	client.Close()
+
+	got := rec.summary(t)
+	want := map[string]string{
+		"msgs_in": "1", "bytes_in": "3", // "hi\n"
+		"msgs_out": "1", "bytes_out": "2",
+		"exit": "0", "closed_by": "client",
+		"close_code": "1000", "close_reason": "bye",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q (summary %v)", k, got[k], v, got)
+		}
+	}
+	if _, err := time.ParseDuration(got["duration"]); err != nil {
+		t.Errorf("duration %q: %v", got["duration"], err)
+	}
+}
+
+func TestSessionSummaryProcessExit(t *testing.T) {
+	rec, url := newRecordingServer(t, "/bin/sh", "-c", "echo bye; exit 3")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+
+	got := rec.summary(t)
+	if got["closed_by"] != "process" || got["exit"] != "3" || got["msgs_out"] != "1" {
+		t.Errorf("summary = %v, want closed_by=process exit=3 msgs_out=1", got)
+	}
+}
+
+func TestShutdownSendsGoingAway(t *testing.T) {
+	h, url := newTestServer(t, "/bin/cat")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	waitForSessions(t, h, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx, true); err != nil {
+		t.Fatalf("Shutdown = %v, want nil (cat exits on stdin close)", err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("client read error = %v, want a 1001 close", err)
+	}
+
+	// New upgrades are refused once draining.
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Fatal("upgrade succeeded after Shutdown")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after Shutdown, got %v", resp)
+	}
+}
+
+func TestShutdownWithoutGoingAway(t *testing.T) {
+	h, url := newTestServer(t, "/bin/cat")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	waitForSessions(t, h, 1)
+
+	if err := h.Shutdown(context.Background(), false); err != nil {
+		t.Fatalf("Shutdown = %v, want nil", err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err = client.ReadMessage()
+	if err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseGoingAway {
+		t.Error("got a 1001 close frame with goingAway disabled")
+	}
+}
+
+func TestShutdownDeadlineKillsProcesses(t *testing.T) {
+	// Ignores SIGINT and SIGTERM, so only the deadline's SIGKILL ends it.
+	h, url := newTestServer(t, "/bin/sh", "-c", "trap '' INT TERM; echo up; while :; do sleep 1; done")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	if _, msg, err := client.ReadMessage(); err != nil || string(msg) != "up" {
+		t.Fatalf("expected \"up\", got %q, %v", msg, err)
+	}
+	waitForSessions(t, h, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := h.Shutdown(ctx, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Shutdown took %v; it should give up at the deadline", elapsed)
+	}
+
+	// The killed session unregisters itself promptly.
+	waitForSessions(t, h, 0)
+}
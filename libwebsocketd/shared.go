@@ -0,0 +1,42 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"sync"
+	"time"
+)
+
+// sharedProcess is a process serving many sessions (--multiplex or
+// --broadcast), stopped at most once however it ends.
+type sharedProcess struct {
+	*ProcessEndpoint
+	stopOnce sync.Once
+}
+
+// startSharedProcess launches command and starts reading its output, with
+// the given --binary, --passstderr and --framing settings.
+func startSharedProcess(command string, args, env []string, config *Config, bin, passStderr bool, framing Framing, log *LogScope, metrics *metrics) (*sharedProcess, error) {
+	launched, err := launchCmd(command, args, env)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("process", "Started shared process %d for %s", launched.cmd.Process.Pid, command)
+	p := &sharedProcess{ProcessEndpoint: NewProcessEndpoint(launched, bin, log, passStderr)}
+	p.closetime += time.Duration(config.CloseMs) * time.Millisecond
+	p.metrics = metrics
+	p.framing = framing
+	p.streamByte = config.StreamByte
+	p.extended, p.rawStdout = config.ExtEnvelope, config.RawStdout
+	p.StartReading()
+	return p, nil
+}
+
+// stop ends the process with the usual Terminate escalation and reaps it.
+// Concurrent callers all return once it is done.
+func (p *sharedProcess) stop() {
+	p.stopOnce.Do(p.Terminate)
+}
@@ -6,6 +6,7 @@
 package libwebsocketd
 
 import (
+	"errors"
 	"io"
 	"sync"
 	"time"
@@ -13,6 +14,14 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// With Config.Mixed, each message to and from the process starts with one
+// of these bytes, saying whether the client sends or is sent it as a text or
+// a binary message.
+const (
+	MixedText   = 't'
+	MixedBinary = 'b'
+)
+
 type WebSocketEndpoint struct {
 	ws           *websocket.Conn
 	output       chan []byte
@@ -20,7 +29,18 @@ type WebSocketEndpoint struct {
 	doneOnce     sync.Once
 	log          *LogScope
 	mtype        int
+	mixed        bool // messages of either type, starting with MixedText or MixedBinary
+	sendTyped    bool // as mixed for messages sent, but not for those received
 	pingInterval time.Duration
+
+	closeMu     sync.Mutex
+	closeCode   int // first close code received from or sent to the client; 0 if none
+	closeReason string
+
+	// closeFrame, if set, is asked by the first Terminate for a close code
+	// and reason to send when no close frame has been exchanged yet. A zero
+	// code sends none and the connection is just dropped.
+	closeFrame func() (code int, reason string)
 }
 
 func NewWebSocketEndpoint(ws *websocket.Conn, bin bool, log *LogScope, pingInterval time.Duration, maxFrameSize int64) *WebSocketEndpoint {
@@ -47,17 +67,79 @@ func NewWebSocketEndpoint(ws *websocket.Conn, bin bool, log *LogScope, pingInter
 func (we *WebSocketEndpoint) Terminate() {
 	// Unblock a readFrames goroutine parked on the output channel send;
 	// closing the connection below only unblocks NextReader.
-	we.doneOnce.Do(func() { close(we.done) })
+	we.doneOnce.Do(func() {
+		close(we.done)
+		if we.closeFrame == nil {
+			return
+		}
+		if code, _ := we.CloseStatus(); code != 0 {
+			return // already closed by the client or by Close
+		}
+		if code, reason := we.closeFrame(); code != 0 {
+			we.sendClose(code, reason)
+		}
+	})
 	we.ws.Close() // unblocks readFrames goroutine
 	we.log.Trace("websocket", "Terminated websocket connection")
 }
 
+// closeFrameTimeout bounds how long Close waits to write its close frame to a
+// client that has stopped reading.
+const closeFrameTimeout = time.Second
+
+// Close sends the client a close frame carrying code and reason, then
+// terminates the endpoint. Unlike Terminate alone, which just drops the
+// connection, this tells the client why the session ended.
+func (we *WebSocketEndpoint) Close(code int, reason string) {
+	we.sendClose(code, reason)
+	we.Terminate()
+}
+
+func (we *WebSocketEndpoint) sendClose(code int, reason string) {
+	we.noteClose(code, reason)
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := we.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeFrameTimeout)); err != nil {
+		we.log.Trace("websocket", "Cannot send close frame: %s", err)
+	}
+}
+
+// noteClose records the session's close code and reason, unless one was
+// already recorded: whichever side closed first explains the session's end.
+func (we *WebSocketEndpoint) noteClose(code int, reason string) {
+	we.closeMu.Lock()
+	defer we.closeMu.Unlock()
+	if we.closeCode == 0 {
+		we.closeCode, we.closeReason = code, reason
+	}
+}
+
+// CloseStatus returns the close code and reason the client sent, or that was
+// sent to it, whichever came first. The code is 1006 if the client went away
+// without a close frame, and 0 if the server ended the connection without one.
+func (we *WebSocketEndpoint) CloseStatus() (code int, reason string) {
+	we.closeMu.Lock()
+	defer we.closeMu.Unlock()
+	return we.closeCode, we.closeReason
+}
+
 func (we *WebSocketEndpoint) Output() chan []byte {
 	return we.output
 }
 
 func (we *WebSocketEndpoint) Send(msg []byte) bool {
-	w, err := we.ws.NextWriter(we.mtype)
+	mtype := we.mtype
+	if we.mixed || we.sendTyped {
+		if len(msg) == 0 || (msg[0] != MixedText && msg[0] != MixedBinary) {
+			we.log.Error("websocket", "Dropping message that does not start with %q or %q", MixedText, MixedBinary)
+			return true
+		}
+		mtype = websocket.TextMessage
+		if msg[0] == MixedBinary {
+			mtype = websocket.BinaryMessage
+		}
+		msg = msg[1:]
+	}
+	w, err := we.ws.NextWriter(mtype)
 	if err != nil {
 		we.log.Trace("websocket", "Cannot send: %s", err)
 		return false
@@ -121,20 +203,44 @@ func (we *WebSocketEndpoint) readFrames() {
 	for {
 		mtype, rd, err := we.ws.NextReader()
 		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				we.noteClose(closeErr.Code, closeErr.Text)
+			} else {
+				select {
+				case <-we.done:
+					// Our own Terminate closed the connection.
+				default:
+					// The client vanished without a close frame: what
+					// RFC 6455 calls an abnormal closure.
+					we.noteClose(websocket.CloseAbnormalClosure, "")
+				}
+			}
 			we.log.Debug("websocket", "Cannot receive: %s", err)
 			break
 		}
-		if mtype != we.mtype {
+		if mtype != we.mtype && !we.mixed {
 			we.log.Debug("websocket", "Ignoring message of unexpected type %d (want %d)", mtype, we.mtype)
 			continue
 		}
 
 		p, err := io.ReadAll(rd)
 		if err != nil { // io.ReadAll never returns io.EOF
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// gorilla has already sent the client a 1009 close frame.
+				we.noteClose(websocket.CloseMessageTooBig, "")
+			}
 			we.log.Debug("websocket", "Cannot read received message: %s", err)
 			break
 		}
-		if we.mtype == websocket.TextMessage {
+		switch {
+		case we.mixed:
+			kind := byte(MixedText)
+			if mtype == websocket.BinaryMessage {
+				kind = MixedBinary
+			}
+			p = append([]byte{kind}, p...)
+		case we.mtype == websocket.TextMessage:
 			p = append(p, '\n')
 		}
 		select {
@@ -132,3 +132,104 @@ func TestWebSocketReadLimit(t *testing.T) {
 		t.Fatal("timed out; oversized frame neither delivered nor rejected")
 	}
 }
+
+// TestWebSocketCloseStatusAbnormal checks that 1006 is recorded only for a
+// client that vanished without a close frame, not for a connection the
+// server itself terminated.
+func TestWebSocketCloseStatusAbnormal(t *testing.T) {
+	for _, serverEnds := range []bool{false, true} {
+		endpoints := make(chan *WebSocketEndpoint, 1)
+		upgrader := websocket.Upgrader{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			we := NewWebSocketEndpoint(conn, false, quietLogScope(), 0, 0)
+			we.StartReading()
+			endpoints <- we
+		}))
+
+		client, _, err := websocket.DefaultDialer.Dial(
+			"ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		we := <-endpoints
+
+		want := websocket.CloseAbnormalClosure
+		if serverEnds {
+			we.Terminate()
+			want = 0
+		} else {
+			client.UnderlyingConn().Close()
+		}
+		select {
+		case <-we.Output(): // closed once readFrames gives up
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the reader to stop")
+		}
+		if code, _ := we.CloseStatus(); code != want {
+			t.Errorf("server ends %v: close code %d, want %d", serverEnds, code, want)
+		}
+		client.Close()
+		srv.Close()
+	}
+}
+
+// TestWebSocketMixed checks that a mixed endpoint takes and sends messages
+// of both types, each starting with its type byte.
+func TestWebSocketMixed(t *testing.T) {
+	endpoints := make(chan *WebSocketEndpoint, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		we := NewWebSocketEndpoint(conn, false, quietLogScope(), 0, 0)
+		we.mixed = true
+		we.StartReading()
+		endpoints <- we
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	we := <-endpoints
+	defer we.Terminate()
+
+	client.WriteMessage(websocket.TextMessage, []byte(`{"op":"start"}`))
+	client.WriteMessage(websocket.BinaryMessage, []byte{0, '\n'})
+	for _, want := range []string{`t{"op":"start"}`, "b\x00\n"} {
+		select {
+		case msg := <-we.Output():
+			if string(msg) != want {
+				t.Errorf("got %q, want %q", msg, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+
+	// One without a type is dropped.
+	for _, msg := range []string{"b\x01\x02", "x", "tdone"} {
+		if !we.Send([]byte(msg)) {
+			t.Fatalf("Send(%q) failed", msg)
+		}
+	}
+	for _, want := range []struct {
+		mtype int
+		data  string
+	}{{websocket.BinaryMessage, "\x01\x02"}, {websocket.TextMessage, "done"}} {
+		mtype, data, err := client.ReadMessage()
+		if err != nil || mtype != want.mtype || string(data) != want.data {
+			t.Errorf("got %d %q, %v; want %d %q", mtype, data, err, want.mtype, want.data)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+// logLine is one log event, ready to be rendered by a formatFunc.
+type logLine struct {
+	time       time.Time
+	levelName  string
+	category   string
+	message    string
+	associated []libwebsocketd.AssocPair
+}
+
+// formatFunc renders a log line, including its trailing newline, into b.
+type formatFunc func(b *bytes.Buffer, line *logLine)
+
+// logFormats maps each --logformat value to its formatter.
+var logFormats = map[string]formatFunc{
+	"text":   formatText,
+	"json":   formatJSON,
+	"logfmt": formatLogfmt,
+}
+
+// newLogfunc returns the LogFunc that every scope logs through: it drops
+// events below the scope's level, renders the rest with format and writes
+// each line to out in a single Write — or to access, if it is not nil and
+// the event is at the access level (CONNECT, DISCONNECT and the like).
+func newLogfunc(format formatFunc, out, access io.Writer) libwebsocketd.LogFunc {
+	return func(l *libwebsocketd.LogScope, level libwebsocketd.LogLevel, levelName string, category string, msg string, args ...interface{}) {
+		if level < l.MinLevel {
+			return
+		}
+		var b bytes.Buffer
+		format(&b, &logLine{
+			time:       time.Now(),
+			levelName:  levelName,
+			category:   category,
+			message:    fmt.Sprintf(msg, args...),
+			associated: l.Associated,
+		})
+
+		dest := out
+		if access != nil && level == libwebsocketd.LogAccess {
+			dest = access
+		}
+		l.Mutex.Lock()
+		dest.Write(b.Bytes())
+		l.Mutex.Unlock()
+	}
+}
+
+// formatText is the original human-readable format:
+// timestamp | LEVEL | category | key:'value' ... | message
+func formatText(b *bytes.Buffer, line *logLine) {
+	assocDump := ""
+	for index, pair := range line.associated {
+		if index > 0 {
+			assocDump += " "
+		}
+		assocDump += fmt.Sprintf("%s:'%s'", pair.Key, pair.Value)
+	}
+	fmt.Fprintf(b, "%s | %-6s | %-10s | %s | %s\n", line.time.Format(time.RFC1123Z), line.levelName, line.category, assocDump, line.message)
+}
+
+// formatJSON writes one JSON object per line. The fixed fields come first,
+// then each associated pair (id, remote, url, ...) as a field of its own,
+// in the order they were associated.
+func formatJSON(b *bytes.Buffer, line *logLine) {
+	b.WriteString(`{"timestamp":`)
+	writeJSONString(b, line.time.Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSONString(b, strings.ToLower(line.levelName))
+	b.WriteString(`,"category":`)
+	writeJSONString(b, line.category)
+	b.WriteString(`,"message":`)
+	writeJSONString(b, line.message)
+	for _, pair := range line.associated {
+		b.WriteByte(',')
+		writeJSONString(b, pair.Key)
+		b.WriteByte(':')
+		writeJSONString(b, pair.Value)
+	}
+	b.WriteString("}\n")
+}
+
+func writeJSONString(b *bytes.Buffer, s string) {
+	// Marshaling a string cannot fail; invalid UTF-8 is replaced.
+	encoded, _ := json.Marshal(s)
+	b.Write(encoded)
+}
+
+// formatLogfmt writes key=value pairs, quoting values that need it.
+func formatLogfmt(b *bytes.Buffer, line *logLine) {
+	b.WriteString("timestamp=")
+	b.WriteString(line.time.Format(time.RFC3339Nano))
+	b.WriteString(" level=")
+	writeLogfmtValue(b, strings.ToLower(line.levelName))
+	b.WriteString(" category=")
+	writeLogfmtValue(b, line.category)
+	b.WriteString(" message=")
+	writeLogfmtValue(b, line.message)
+	for _, pair := range line.associated {
+		b.WriteByte(' ')
+		b.WriteString(pair.Key)
+		b.WriteByte('=')
+		writeLogfmtValue(b, pair.Value)
+	}
+	b.WriteByte('\n')
+}
+
+func writeLogfmtValue(b *bytes.Buffer, s string) {
+	if s == "" || !utf8.ValidString(s) || strings.ContainsAny(s, " =\"\\") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+		b.WriteString(strconv.Quote(s))
+		return
+	}
+	b.WriteString(s)
+}
@@ -0,0 +1,110 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+func testLogLine() *logLine {
+	return &logLine{
+		time:      time.Date(2026, 10, 17, 9, 30, 0, 123456789, time.UTC),
+		levelName: "ACCESS",
+		category:  "session",
+		message:   `CONNECT "quoted"`,
+		associated: []libwebsocketd.AssocPair{
+			{Key: "url", Value: "ws://localhost:8080/"},
+			{Key: "id", Value: "1234"},
+			{Key: "remote", Value: ""},
+		},
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	var b bytes.Buffer
+	formatText(&b, testLogLine())
+	want := "Sat, 17 Oct 2026 09:30:00 +0000 | ACCESS | session    | url:'ws://localhost:8080/' id:'1234' remote:'' | CONNECT \"quoted\"\n"
+	if b.String() != want {
+		t.Errorf("got  %q\nwant %q", b.String(), want)
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	var b bytes.Buffer
+	formatJSON(&b, testLogLine())
+	want := `{"timestamp":"2026-10-17T09:30:00.123456789Z","level":"access","category":"session","message":"CONNECT \"quoted\"","url":"ws://localhost:8080/","id":"1234","remote":""}` + "\n"
+	if b.String() != want {
+		t.Errorf("got  %s\nwant %s", b.String(), want)
+	}
+	var fields map[string]string
+	if err := json.Unmarshal(b.Bytes(), &fields); err != nil {
+		t.Fatalf("not valid JSON: %v", err)
+	}
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	var b bytes.Buffer
+	formatLogfmt(&b, testLogLine())
+	want := `timestamp=2026-10-17T09:30:00.123456789Z level=access category=session message="CONNECT \"quoted\"" url=ws://localhost:8080/ id=1234 remote=""` + "\n"
+	if b.String() != want {
+		t.Errorf("got  %s\nwant %s", b.String(), want)
+	}
+}
+
+func TestWriteLogfmtValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"", `""`},
+		{"two words", `"two words"`},
+		{"a=b", `"a=b"`},
+		{"line\nbreak", `"line\nbreak"`},
+		{`back\slash`, `"back\\slash"`},
+		{"\xff", `"\xff"`},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		writeLogfmtValue(&b, tt.in)
+		if b.String() != tt.want {
+			t.Errorf("writeLogfmtValue(%q) = %s, want %s", tt.in, b.String(), tt.want)
+		}
+	}
+}
+
+func TestNewLogfuncFiltersLevel(t *testing.T) {
+	var out bytes.Buffer
+	log := libwebsocketd.RootLogScope(libwebsocketd.LogInfo, newLogfunc(formatJSON, &out, nil))
+	log.Access("session", "dropped")
+	log.Error("server", "kept %d", 1)
+	var fields map[string]string
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatalf("expected exactly one JSON line, got %q: %v", out.String(), err)
+	}
+	if fields["message"] != "kept 1" || fields["level"] != "error" {
+		t.Errorf("unexpected line: %v", fields)
+	}
+}
+
+func TestNewLogfuncAccessLog(t *testing.T) {
+	var out, access bytes.Buffer
+	log := libwebsocketd.RootLogScope(libwebsocketd.LogDebug, newLogfunc(formatText, &out, &access))
+	log.Access("session", "CONNECT")
+	log.Error("process", "oops")
+	log.Info("server", "started")
+	if got := strings.Count(access.String(), "\n"); got != 1 || !strings.Contains(access.String(), "CONNECT") {
+		t.Errorf("access log = %q, want only the CONNECT line", access.String())
+	}
+	if strings.Contains(out.String(), "CONNECT") || strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("main log = %q, want the error and info lines only", out.String())
+	}
+}
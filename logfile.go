@@ -0,0 +1,190 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the suffix added to a rotated log file's name. It sorts
+// chronologically and contains no characters that are awkward on Windows.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// logRotations maps each --logrotate value to the period it rotates on.
+var logRotations = map[string]time.Duration{
+	"":       0,
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+}
+
+// openLogs opens the --logfile and --accesslog files. It returns the
+// destinations for newLogfunc — stdout without --logfile, and a nil access
+// writer without --accesslog — and the files, for reopening on SIGHUP.
+func openLogs(config *Config) (out, access io.Writer, files []*logFile, err error) {
+	out = os.Stdout
+	period := logRotations[config.LogRotate]
+	if config.LogFile != "" {
+		lf, err := openLogFile(config.LogFile, config.LogMaxSize, period, config.LogBackups)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		out = lf
+		files = append(files, lf)
+	}
+	if config.AccessLog != "" {
+		lf, err := openLogFile(config.AccessLog, config.LogMaxSize, period, config.LogBackups)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		access = lf
+		files = append(files, lf)
+	}
+	return out, access, files, nil
+}
+
+// logFile is an append-only log destination (--logfile, --accesslog) that
+// rotates itself by size and/or time, and can be reopened after an external
+// tool such as logrotate has moved it away.
+type logFile struct {
+	path    string
+	maxSize int64         // rotate before a write would pass this size; 0 disables
+	period  time.Duration // rotate when the hour or day changes; 0 disables
+	backups int           // rotated files to keep; 0 keeps them all
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time // start of the period the current file belongs to
+	now    func() time.Time
+}
+
+func openLogFile(path string, maxSize int64, period time.Duration, backups int) (*logFile, error) {
+	lf := &logFile{path: path, maxSize: maxSize, period: period, backups: backups, now: time.Now}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *logFile) open() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	lf.file = f
+	lf.size = info.Size()
+	lf.opened = lf.periodStart(lf.now())
+	return nil
+}
+
+// periodStart truncates t to the start of its rotation period, in local time
+// so "daily" turns over at local midnight.
+func (lf *logFile) periodStart(t time.Time) time.Time {
+	switch lf.period {
+	case time.Hour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case 24 * time.Hour:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// Write appends p, rotating first if p would take the file past maxSize or
+// the rotation period has changed. A single line is never split across files.
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file == nil {
+		// An earlier reopen failed; try again rather than lose every line.
+		if err := lf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if lf.needsRotation(len(p)) {
+		if err := lf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "websocketd: %s\n", err)
+		}
+	}
+	n, err := lf.file.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+func (lf *logFile) needsRotation(next int) bool {
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(next) > lf.maxSize {
+		return true
+	}
+	return lf.period > 0 && !lf.periodStart(lf.now()).Equal(lf.opened)
+}
+
+// rotate renames the current file aside with a timestamp suffix, opens a
+// fresh one and prunes old backups. If the rename fails the current file is
+// reopened and kept, so logging carries on either way.
+func (lf *logFile) rotate() error {
+	lf.file.Close()
+	backup := lf.path + "." + lf.now().Format(backupTimeFormat)
+	renameErr := os.Rename(lf.path, backup)
+	if err := lf.open(); err != nil {
+		lf.file = nil
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("could not rotate log file: %w", renameErr)
+	}
+	return lf.prune()
+}
+
+// prune removes the oldest rotated files beyond the number to keep.
+func (lf *logFile) prune() error {
+	if lf.backups <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(lf.path + ".*")
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, lf.path+".")); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups) // the suffix sorts chronologically
+	for len(backups) > lf.backups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("could not remove old log file: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Reopen closes and reopens the file at the same path, picking up a new file
+// if the old one was moved away. It is what SIGHUP does for logrotate.
+func (lf *logFile) Reopen() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.file != nil {
+		lf.file.Close()
+	}
+	if err := lf.open(); err != nil {
+		lf.file = nil
+		return err
+	}
+	return nil
+}
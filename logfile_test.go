@@ -0,0 +1,163 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupsOf returns the rotated copies of path, oldest first.
+func backupsOf(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// fakeClock returns a clock for logFile.now and a function advancing it.
+func fakeClock() (func() time.Time, func(time.Duration)) {
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.Local)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ws.log")
+	lf, err := openLogFile(path, 10, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, advance := fakeClock()
+	lf.now = now
+
+	lf.Write([]byte("first\n"))  // 6 bytes
+	lf.Write([]byte("second\n")) // would make 13 > 10: rotate first
+	advance(time.Second)
+	lf.Write([]byte("third\n")) // 7+6 > 10: rotate again
+
+	backups := backupsOf(t, path)
+	if len(backups) != 2 {
+		t.Fatalf("got backups %v, want 2", backups)
+	}
+	if readFile(t, backups[0]) != "first\n" || readFile(t, backups[1]) != "second\n" {
+		t.Errorf("backups hold %q and %q; lines must not be split or reordered", readFile(t, backups[0]), readFile(t, backups[1]))
+	}
+	if got := readFile(t, path); got != "third\n" {
+		t.Errorf("current file = %q, want the last line", got)
+	}
+}
+
+func TestLogFileOversizedLineIsNotRotatedAlone(t *testing.T) {
+	// A line bigger than maxSize goes into an empty file rather than
+	// rotating forever.
+	path := filepath.Join(t.TempDir(), "ws.log")
+	lf, err := openLogFile(path, 4, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.Write([]byte("a long line\n"))
+	if got := len(backupsOf(t, path)); got != 0 {
+		t.Errorf("got %d backups, want 0", got)
+	}
+}
+
+func TestLogFileRotatesByPeriod(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ws.log")
+	now, advance := fakeClock()
+	lf := &logFile{path: path, period: time.Hour, now: now}
+	if err := lf.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	lf.Write([]byte("9am\n"))
+	advance(30 * time.Minute)
+	lf.Write([]byte("9:30am\n"))
+	if got := len(backupsOf(t, path)); got != 0 {
+		t.Fatalf("rotated within the hour: %d backups", got)
+	}
+	advance(30 * time.Minute)
+	lf.Write([]byte("10am\n"))
+
+	backups := backupsOf(t, path)
+	if len(backups) != 1 || readFile(t, backups[0]) != "9am\n9:30am\n" {
+		t.Fatalf("want one backup holding the 9 o'clock lines, got %v", backups)
+	}
+	if got := readFile(t, path); got != "10am\n" {
+		t.Errorf("current file = %q", got)
+	}
+}
+
+func TestLogFilePrunesBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ws.log")
+	unrelated := filepath.Join(dir, "ws.log.gz")
+	os.WriteFile(unrelated, []byte("keep me"), 0644)
+
+	now, advance := fakeClock()
+	lf := &logFile{path: path, maxSize: 1, backups: 2, now: now}
+	if err := lf.open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		lf.Write([]byte(line))
+		advance(time.Second)
+	}
+
+	backups := backupsOf(t, path)
+	var rotated []string
+	for _, b := range backups {
+		if b != unrelated {
+			rotated = append(rotated, readFile(t, b))
+		}
+	}
+	if strings.Join(rotated, "") != "3\n4\n" {
+		t.Errorf("kept backups %q, want the two newest", rotated)
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Error("pruning removed a file that is not a rotated log")
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ws.log")
+	lf, err := openLogFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.Write([]byte("before\n"))
+
+	// What logrotate does before sending SIGHUP.
+	moved := filepath.Join(dir, "ws.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	lf.Write([]byte("after\n"))
+
+	if got := readFile(t, moved); got != "before\n" {
+		t.Errorf("moved file = %q", got)
+	}
+	if got := readFile(t, path); got != "after\n" {
+		t.Errorf("reopened file = %q", got)
+	}
+}
@@ -6,18 +6,23 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joewalnes/websocketd/libwebsocketd"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // readHeaderTimeout bounds how long a client may take to send its request
@@ -26,71 +31,76 @@ import (
 // safe on every server and defends against slowloris-style header dribbling.
 const readHeaderTimeout = 10 * time.Second
 
-func logfunc(l *libwebsocketd.LogScope, level libwebsocketd.LogLevel, levelName string, category string, msg string, args ...interface{}) {
-	if level < l.MinLevel {
-		return
+// Exit statuses after a SIGINT or SIGTERM. They are part of the documented
+// command line contract (see --drainms in help.go), so don't renumber them.
+const (
+	exitDrained       = 0 // every session ended within --drainms
+	exitDrainDeadline = 5 // --drainms passed (or a second signal came) and the remaining processes were killed
+)
+
+// serverList tracks every http.Server main starts, so that a shutdown signal
+// can stop them all accepting.
+type serverList struct {
+	mu      sync.Mutex
+	servers []*http.Server
+	closed  bool
+}
+
+// add registers srv and returns it. A server added after shutdown has begun
+// is closed straight away, so its Serve call returns instead of accepting.
+func (sl *serverList) add(srv *http.Server) *http.Server {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.closed {
+		srv.Close()
 	}
-	fullMsg := fmt.Sprintf(msg, args...)
+	sl.servers = append(sl.servers, srv)
+	return srv
+}
 
-	assocDump := ""
-	for index, pair := range l.Associated {
-		if index > 0 {
-			assocDump += " "
+// shutdown stops every server's listeners (which also unlinks a Unix socket
+// file) and waits for in-flight HTTP requests, such as CGI scripts, until ctx
+// is done. Hijacked WebSocket connections are not waited for; see
+// WebsocketdServer.Shutdown.
+func (sl *serverList) shutdown(ctx context.Context) error {
+	sl.mu.Lock()
+	sl.closed = true
+	servers := sl.servers
+	sl.mu.Unlock()
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			errs <- srv.Shutdown(ctx)
+		}(srv)
+	}
+	var err error
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
 		}
-		assocDump += fmt.Sprintf("%s:'%s'", pair.Key, pair.Value)
 	}
-
-	l.Mutex.Lock()
-	fmt.Printf("%s | %-6s | %-10s | %s | %s\n", libwebsocketd.Timestamp(), levelName, category, assocDump, fullMsg)
-	l.Mutex.Unlock()
+	return err
 }
 
 // serve listens on the given network ("tcp" or "unix") and address/path and
-// runs an HTTP(S) server on it, honoring the Ssl/mutual-TLS config. It blocks
-// until the listener errors out.
-func serve(network, address string, config *Config, log *libwebsocketd.LogScope) error {
+// runs an HTTP server on it, or an HTTPS server if tlsCfg is not nil. It
+// blocks until the listener errors out or the server is shut down.
+func serve(network, address string, tlsCfg *tls.Config, proxies []*net.IPNet, servers *serverList) error {
 	listener, err := net.Listen(network, address)
 	if err != nil {
 		return err
 	}
-	if !config.Ssl {
-		return (&http.Server{ReadHeaderTimeout: readHeaderTimeout}).Serve(listener)
+	if len(proxies) > 0 {
+		// Beneath TLS: the PROXY header comes before the handshake.
+		listener = &proxyListener{Listener: listener, trusted: proxies}
 	}
-	if config.SslCaFile != "" {
-		return serveMutualTLS(listener, config.CertFile, config.KeyFile, config.SslCaFile, log)
+	if tlsCfg == nil {
+		return servers.add(&http.Server{ReadHeaderTimeout: readHeaderTimeout}).Serve(listener)
 	}
-	server := &http.Server{ReadHeaderTimeout: readHeaderTimeout, TLSConfig: tlsConfig()}
-	return server.ServeTLS(listener, config.CertFile, config.KeyFile)
-}
-
-// tlsConfig returns the base TLS settings shared by all HTTPS servers. It pins
-// a minimum protocol version explicitly rather than relying on the Go default,
-// which has drifted across releases.
-func tlsConfig() *tls.Config {
-	return &tls.Config{MinVersion: tls.VersionTLS12}
-}
-
-// serveMutualTLS runs an HTTPS server on the given listener that requires
-// client certificates verified against the given CA file.
-func serveMutualTLS(listener net.Listener, certFile, keyFile, caFile string, log *libwebsocketd.LogScope) error {
-	caCert, err := os.ReadFile(caFile)
-	if err != nil {
-		return fmt.Errorf("failed to read CA file %s: %w", caFile, err)
-	}
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		return fmt.Errorf("failed to parse CA certificates from %s", caFile)
-	}
-
-	cfg := tlsConfig()
-	cfg.ClientAuth = tls.RequireAndVerifyClientCert
-	cfg.ClientCAs = caCertPool
-	server := &http.Server{
-		ReadHeaderTimeout: readHeaderTimeout,
-		TLSConfig:         cfg,
-	}
-	log.Info("server", "Mutual TLS enabled (client certs verified against %s)", caFile)
-	return server.ServeTLS(listener, certFile, keyFile)
+	server := servers.add(&http.Server{ReadHeaderTimeout: readHeaderTimeout, TLSConfig: tlsCfg})
+	// The certificate comes from tlsCfg.GetCertificate, so it can be reloaded.
+	return server.ServeTLS(listener, "", "")
 }
 
 // unixSocketProbeTimeout bounds the liveness probe against an existing socket
@@ -109,7 +119,7 @@ const unixSocketProbeTimeout = time.Second
 // first tells the two apart — a successful dial means someone is listening, a
 // refused connection means the file is stale. Refusing to start on a live
 // socket matches what a TCP listener already does when its port is taken.
-func serveUnixSocket(path string, config *Config, log *libwebsocketd.LogScope) error {
+func serveUnixSocket(path string, tlsCfg *tls.Config, servers *serverList) error {
 	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
 		if conn, err := net.DialTimeout("unix", path, unixSocketProbeTimeout); err == nil {
 			conn.Close()
@@ -119,13 +129,90 @@ func serveUnixSocket(path string, config *Config, log *libwebsocketd.LogScope) e
 			return fmt.Errorf("failed to remove stale socket %s: %w", path, err)
 		}
 	}
-	return serve("unix", path, config, log)
+	return serve("unix", path, tlsCfg, nil, servers)
+}
+
+// drain shuts the server down gracefully after the first SIGINT or SIGTERM:
+// listeners stop accepting, live sessions are closed (with a 1001 close frame
+// if --goingaway) and their processes terminated, all within --drainms. A
+// second signal stops waiting early. It returns the exit status to use.
+func drain(sig os.Signal, signals <-chan os.Signal, handler *libwebsocketd.WebsocketdServer, servers *serverList, config *Config, log *libwebsocketd.LogScope) int {
+	log.Info("server", "Received %s, shutting down (deadline %s)", sig, config.DrainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Info("server", "Received %s again, not waiting for sessions to end", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	serversDone := make(chan error, 1)
+	go func() {
+		serversDone <- servers.shutdown(ctx)
+	}()
+	sessionsErr := handler.Shutdown(ctx, config.GoingAway)
+	serversErr := <-serversDone
+
+	if sessionsErr != nil || serversErr != nil {
+		log.Error("server", "Shutdown incomplete: %s", ctx.Err())
+		return exitDrainDeadline
+	}
+	log.Info("server", "Shutdown complete")
+	return exitDrained
+}
+
+// reload handles SIGHUP: it reopens the log files (for logrotate), re-reads
+// the TLS files (certs is nil without --ssl), the --auth htpasswd or JWKS
+// file, and the reloadable settings in the --config file. Sessions already
+// running are unaffected. Whatever fails to load is reported and keeps its
+// previous value.
+func reload(config *Config, logFiles []*logFile, certs *tlsFiles, handler *libwebsocketd.WebsocketdServer, log *libwebsocketd.LogScope) {
+	for _, lf := range logFiles {
+		if err := lf.Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+	log.Info("server", "Received SIGHUP, reloading")
+	if certs != nil {
+		if err := certs.reload(); err != nil {
+			log.Error("server", "Keeping previous TLS certificates: %s", err)
+		} else {
+			log.Info("server", "Reloaded TLS certificates")
+		}
+	}
+	if config.Auth != nil {
+		if err := libwebsocketd.ReloadAuth(config.Auth); err != nil {
+			log.Error("server", "Keeping previous --auth credentials: %s", err)
+		}
+	}
+	if config.ConfigFile == "" {
+		return
+	}
+	reloaded, maxForks, err := reloadConfigFile(flag.CommandLine, config)
+	if err != nil {
+		log.Error("server", "Keeping previous settings: %s", err)
+		return
+	}
+	for _, o := range schemelessOriginWarnings(config.Ssl, reloaded.AllowOrigins) {
+		log.Error("server", "--origin=%q has no scheme, so it also accepts insecure http origins; use \"https://%s\" to require TLS", o, o)
+	}
+	handler.Reload(reloaded, maxForks)
+	log.Info("server", "Reloaded settings from %s", config.ConfigFile)
 }
 
 func main() {
 	config := parseCommandLine()
 
-	log := libwebsocketd.RootLogScope(config.LogLevel, logfunc)
+	logOut, accessOut, logFiles, err := openLogs(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	log := libwebsocketd.RootLogScope(config.LogLevel, newLogfunc(logFormats[config.LogFormat], logOut, accessOut))
 
 	for _, o := range schemelessOriginWarnings(config.Ssl, config.AllowOrigins) {
 		log.Error("server", "--origin=%q has no scheme, so it also accepts insecure http origins; use \"https://%s\" to require TLS", o, o)
@@ -148,6 +235,48 @@ func main() {
 	handler := libwebsocketd.NewWebsocketdServer(config.Config, log, config.MaxForks)
 	http.Handle("/", handler)
 
+	var certs *tlsFiles
+	var tlsCfg *tls.Config
+	var acmeManager *autocert.Manager // with --acme
+	if config.Ssl {
+		var err error
+		if len(config.ACMEDomains) > 0 {
+			var m *autocert.Manager
+			if m, err = newACMEManager(config); err == nil {
+				acmeManager = m
+				certs, err = loadACMEFiles(m, config.SslCaFile)
+			}
+		} else {
+			certs, err = loadTLSFiles(config.CertFile, config.KeyFile, config.SslCaFile)
+		}
+		if err != nil {
+			log.Fatal("server", "Can't start server: %s", err)
+			os.Exit(3)
+		}
+		if acmeManager != nil {
+			log.Info("server", "Certificates for %s from %s", strings.Join(config.ACMEDomains, ", "), config.ACMEDirectory)
+		}
+		tlsCfg = certs.serverConfig(config.OptionalCert)
+		if config.SslCaFile != "" && config.OptionalCert {
+			log.Info("server", "Mutual TLS enabled (client certs verified against %s if given)", config.SslCaFile)
+		} else if config.SslCaFile != "" {
+			log.Info("server", "Mutual TLS enabled (client certs verified against %s)", config.SslCaFile)
+		}
+	}
+
+	// Installed before any listener starts, so a signal can never find a
+	// server that the drain does not know about.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	servers := &serverList{}
+
+	for _, r := range config.Routes {
+		if r.Command != "" {
+			log.Info("server", "Serving route %-14s: %s %s", r.Path, r.Command, strings.Join(r.Args, " "))
+		}
+	}
 	if config.UsingScriptDir {
 		log.Info("server", "Serving from directory      : %s", config.ScriptDir)
 	} else if config.CommandName != "" {
@@ -159,6 +288,18 @@ func main() {
 	if config.CgiDir != "" {
 		log.Info("server", "Serving CGI scripts from    : %s", config.CgiDir)
 	}
+	if config.Broadcast {
+		log.Info("server", "Broadcasting output         : one process per command, to every client")
+	}
+	if config.Multiplex {
+		log.Info("server", "Multiplexing sessions       : one process per command")
+	}
+	if config.ResumeMs > 0 {
+		log.Info("server", "Resumable sessions          : kept %dms for clients to reconnect", config.ResumeMs)
+	}
+	if config.PoolMin > 0 {
+		log.Info("server", "Pre-forking processes       : %d spare per command, up to %d", config.PoolMin, max(config.PoolMin, config.PoolMax))
+	}
 
 	// Buffered for every possible sender (one listener plus one redirect
 	// server per address, plus the Unix socket listener if any) so no
@@ -167,7 +308,29 @@ func main() {
 	if config.UnixSocket != "" {
 		rejectCap++
 	}
+	if config.MetricsAddr != "" {
+		rejectCap++
+	}
 	rejects := make(chan error, rejectCap)
+	if config.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", handler.MetricsHandler())
+		metrics := servers.add(&http.Server{
+			Addr:              config.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       10 * time.Second,
+			WriteTimeout:      10 * time.Second,
+		})
+		log.Info("server", "Serving metrics             : http://%s/metrics", config.MetricsAddr)
+		go func() {
+			rejects <- metrics.ListenAndServe()
+		}()
+	}
+	var proxyProtocol []*net.IPNet
+	if config.ProxyProtocol {
+		proxyProtocol = config.TrustedProxies
+	}
 	for _, addrSingle := range config.Addr {
 		log.Info("server", "Starting WebSocket server   : %s", handler.TellURL("ws", addrSingle, "/"))
 		if config.DevConsole {
@@ -180,14 +343,34 @@ func main() {
 		// never return non-error.
 
 		go func(addr string) {
-			rejects <- serve("tcp", addr, config, log)
+			rejects <- serve("tcp", addr, tlsCfg, proxyProtocol, servers)
 		}(addrSingle)
 
 		if config.RedirPort != 0 {
 			go func(addr string) {
 				pos := strings.IndexByte(addr, ':')
 				rediraddr := addr[:pos] + ":" + strconv.Itoa(config.RedirPort) // it would be silly to optimize this one
-				redir := &http.Server{Addr: rediraddr,
+				var redirect http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					// redirect to same hostname as in request but different port and probably schema
+					uri := "https://"
+					if !config.Ssl {
+						uri = "http://"
+					}
+					if cpos := strings.IndexByte(r.Host, ':'); cpos > 0 {
+						uri += r.Host[:cpos] + addr[pos:] + "/"
+					} else {
+						uri += r.Host + addr[pos:] + "/"
+					}
+
+					// Not an open redirect: the target is the host the client itself
+					// sent, switched to the canonical scheme and port.
+					http.Redirect(w, r, uri, http.StatusMovedPermanently) // #nosec G710
+				})
+				if acmeManager != nil {
+					// Answers HTTP-01 challenges, and redirects the rest.
+					redirect = acmeManager.HTTPHandler(redirect)
+				}
+				redir := servers.add(&http.Server{Addr: rediraddr,
 					// The redirect server only emits tiny immediate responses,
 					// so full timeouts are safe here (unlike the main server,
 					// which carries long-lived WebSocket/CGI streams).
@@ -195,22 +378,8 @@ func main() {
 					ReadTimeout:       10 * time.Second,
 					WriteTimeout:      10 * time.Second,
 					IdleTimeout:       60 * time.Second,
-					Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-						// redirect to same hostname as in request but different port and probably schema
-						uri := "https://"
-						if !config.Ssl {
-							uri = "http://"
-						}
-						if cpos := strings.IndexByte(r.Host, ':'); cpos > 0 {
-							uri += r.Host[:cpos] + addr[pos:] + "/"
-						} else {
-							uri += r.Host + addr[pos:] + "/"
-						}
-
-						// Not an open redirect: the target is the host the client itself
-						// sent, switched to the canonical scheme and port.
-						http.Redirect(w, r, uri, http.StatusMovedPermanently) // #nosec G710
-					})}
+					Handler:           redirect,
+				})
 				log.Info("server", "Starting redirect server   : http://%s/", rediraddr)
 				rejects <- redir.ListenAndServe()
 			}(addrSingle)
@@ -219,12 +388,21 @@ func main() {
 	if config.UnixSocket != "" {
 		log.Info("server", "Starting WebSocket server   : unix socket at %s", config.UnixSocket)
 		go func(path string) {
-			rejects <- serveUnixSocket(path, config, log)
+			rejects <- serveUnixSocket(path, tlsCfg, servers)
 		}(config.UnixSocket)
 	}
-	err := <-rejects
-	if err != nil {
-		log.Fatal("server", "Can't start server: %s", err)
-		os.Exit(3)
+	for {
+		select {
+		case err := <-rejects:
+			if err != nil {
+				log.Fatal("server", "Can't start server: %s", err)
+				os.Exit(3)
+			}
+			return
+		case sig := <-signals:
+			os.Exit(drain(sig, signals, handler, servers, config, log))
+		case <-reloads:
+			reload(config, logFiles, certs, handler, log)
+		}
 	}
 }
@@ -0,0 +1,172 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+// proxyV2Signature starts every PROXY protocol version 2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest a version 1 header line can be.
+const proxyV1MaxLength = 107
+
+// proxyListener reads the HAProxy PROXY protocol header (--proxyprotocol)
+// that a trusted proxy sends ahead of each connection, and reports the
+// client it names as the connection's RemoteAddr. Connections from anywhere
+// else are passed through untouched, so clients may still connect directly
+// but cannot claim to be someone else.
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: c, trusted: l.trusted}, nil
+}
+
+// proxyConn reads its PROXY header on first use rather than in Accept, so a
+// slow proxy cannot hold up the accept loop. http.Server asks for RemoteAddr
+// before anything else, on the connection's own goroutine.
+type proxyConn struct {
+	net.Conn
+	trusted []*net.IPNet
+
+	once   sync.Once
+	reader io.Reader
+	remote net.Addr // the client named by the header, if any
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.reader = c.Conn
+	tcp, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !libwebsocketd.IsTrustedProxy(tcp.IP, c.trusted) {
+		return
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(readHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	r := bufio.NewReader(c.Conn)
+	c.reader = r
+	c.remote, c.err = readProxyHeader(r)
+	if c.err != nil {
+		c.err = fmt.Errorf("PROXY protocol header from %s: %w", tcp, c.err)
+		c.Conn.Close()
+	}
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.init)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a version 1 or 2 PROXY protocol header and returns
+// the source address it gives, or nil if it gives none (a health check's
+// LOCAL or UNKNOWN header, or a family other than TCP over IPv4 or IPv6).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, errors.New("missing header")
+}
+
+// readProxyV1 parses "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, errors.New("version 1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed version 1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("malformed version 1 header %q", strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary version 2 header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte // signature, version/command, family, length
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch hdr[12] & 0x0f {
+	case 0x0: // LOCAL: the proxy's own connection, e.g. a health check
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported command %d", hdr[12]&0x0f)
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4: src, dst, sport, dport
+		if len(body) < 12 {
+			return nil, errors.New("short IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
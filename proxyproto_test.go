@@ -0,0 +1,96 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+func proxyV2Header(cmd, family byte, addrs []byte) []byte {
+	h := append([]byte(nil), proxyV2Signature...)
+	h = append(h, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:16], uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := []byte{203, 0, 113, 9, 192, 0, 2, 1, 0x1f, 0x90, 0x01, 0xbb} // :8080 → :443
+	tests := []struct {
+		name    string
+		header  string
+		want    string // "" for no address
+		wantErr bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.9 192.0.2.1 8080 443\r\n", "203.0.113.9:8080", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::9 2001:db8::1 8080 443\r\n", "[2001:db8::9]:8080", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 malformed", "PROXY TCP4 nonsense\r\n", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", true},
+		{"v2 proxy", string(proxyV2Header(1, 0x11, v4)), "203.0.113.9:8080", false},
+		{"v2 local", string(proxyV2Header(0, 0x00, nil)), "", false},
+		{"v2 short", string(proxyV2Header(1, 0x11, v4[:6])), "", true},
+		{"no header", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+			addr, err := readProxyHeader(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("address = %q, want %q", got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+				t.Errorf("header read too much or too little; left %q", rest)
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	trusted, _ := libwebsocketd.ParseTrustedProxies("127.0.0.1")
+	pl := &proxyListener{Listener: ln, trusted: trusted}
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Write([]byte("PROXY TCP4 203.0.113.9 127.0.0.1 5000 80\r\nhello"))
+		c.Close()
+	}()
+	c, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.RemoteAddr().String(); got != "203.0.113.9:5000" {
+		t.Errorf("RemoteAddr = %s, want the client from the header", got)
+	}
+	if body, _ := io.ReadAll(c); string(body) != "hello" {
+		t.Errorf("read %q after the header, want hello", body)
+	}
+}
@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for --acme against Pebble, the ACME test CA
+// (https://github.com/letsencrypt/pebble), which needs setting up by hand:
+//
+//	pebble -config test/config/pebble-config.json
+//	WEBSOCKETD_TEST_PEBBLE=https://localhost:14000/dir \
+//	WEBSOCKETD_TEST_PEBBLE_CA=test/certs/pebble.minica.pem \
+//	WEBSOCKETD_TEST_ACME_DOMAIN=ws.example.test \
+//	go test -run ACME ./qa/integration
+//
+// The domain must resolve to 127.0.0.1 (e.g. in /etc/hosts). Pebble's test
+// config validates challenges on ports 5001 (TLS-ALPN-01) and 5002 (HTTP-01),
+// which is where websocketd listens.
+
+func TestACME_Pebble(t *testing.T) {
+	directory := os.Getenv("WEBSOCKETD_TEST_PEBBLE")
+	pebbleCA := os.Getenv("WEBSOCKETD_TEST_PEBBLE_CA")
+	domain := os.Getenv("WEBSOCKETD_TEST_ACME_DOMAIN")
+	if directory == "" || pebbleCA == "" || domain == "" {
+		t.Skip("WEBSOCKETD_TEST_PEBBLE, WEBSOCKETD_TEST_PEBBLE_CA and WEBSOCKETD_TEST_ACME_DOMAIN not set")
+	}
+
+	cache := t.TempDir()
+	cmd := exec.Command(websocketdBin,
+		"--port=5001",
+		"--redirport=5002",
+		"--address=127.0.0.1",
+		"--loglevel=error",
+		"--acme="+domain,
+		"--acmedirectory="+directory,
+		"--acmeca="+pebbleCA,
+		"--acmecache="+cache,
+		testcmdBin, "echo",
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	waitForPort(t, 5001, 10*time.Second)
+
+	// Pebble issues from a fresh root on every start, so the certificate is
+	// only checked for its name here, not its chain.
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 30 * time.Second, // covers getting the certificate
+		TLSClientConfig:  &tls.Config{ServerName: domain, InsecureSkipVerify: true},
+	}
+	conn, _, err := dialer.Dial("wss://127.0.0.1:5001/", nil)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer conn.Close()
+	state := conn.UnderlyingConn().(*tls.Conn).ConnectionState()
+	if err := state.PeerCertificates[0].VerifyHostname(domain); err != nil {
+		t.Errorf("certificate from the CA: %v", err)
+	}
+	if len(state.PeerCertificates) < 2 {
+		t.Errorf("got %d certificates, want the CA's chain too", len(state.PeerCertificates))
+	} else {
+		issuer := x509.NewCertPool()
+		issuer.AddCert(state.PeerCertificates[1])
+		if _, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{DNSName: domain, Roots: issuer}); err != nil {
+			t.Errorf("certificate not issued by the CA's intermediate: %v", err)
+		}
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Errorf("echo: got %q, %v", msg, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cache, domain)); err != nil {
+		t.Errorf("certificate not cached: %v", err)
+	}
+}
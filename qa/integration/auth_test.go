@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests for --auth: credentials are checked before a process is started,
+// and the process learns who the client is from REMOTE_USER and AUTH_TYPE.
+
+// aliceSecret is an htpasswd line for alice with password "secret", in
+// htpasswd's default MD5 format.
+const aliceSecret = "alice:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/\n"
+
+func TestAuth_Basic(t *testing.T) {
+	t.Parallel()
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte(aliceSecret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := startServerOpts(t, []string{"--auth=basic:" + htpasswd}, "env")
+
+	_, resp, err := s.TryConnect("/", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want 401 without credentials, got %v, %v", resp, err)
+	}
+	if !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), `Basic realm="websocketd"`) {
+		t.Errorf("WWW-Authenticate = %q", resp.Header.Get("WWW-Authenticate"))
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("alice", "secret")
+	ws, _, err := s.TryConnect("/", req.Header)
+	if err != nil {
+		t.Fatalf("connect with credentials failed: %v", err)
+	}
+	defer ws.Close()
+	output := strings.Join(collectMessages(ws, 3*time.Second), "\n")
+	for k, v := range map[string]string{"REMOTE_USER": "alice", "AUTH_TYPE": "Basic"} {
+		if got, _ := findEnvValue(output, k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if _, ok := findEnvValue(output, "HTTP_AUTHORIZATION"); ok {
+		t.Error("the Basic password reached the process in HTTP_AUTHORIZATION")
+	}
+	s.WaitForStdout("user:'alice'", 5*time.Second)
+}
+
+func TestAuth_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a shell script")
+	}
+	t.Parallel()
+	script := filepath.Join(t.TempDir(), "auth.sh")
+	content := "#!/bin/sh\n[ \"$HTTP_X_TICKET\" = open-sesame ] || exit 1\necho ticket-holder\n"
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		t.Fatal(err)
+	}
+	s := startServerOpts(t, []string{"--auth=command:" + script}, "env")
+
+	if _, resp, err := s.TryConnect("/", http.Header{"X-Ticket": {"guess"}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("want 403 for a wrong ticket, got %v, %v", resp, err)
+	}
+	ws, _, err := s.TryConnect("/", http.Header{"X-Ticket": {"open-sesame"}})
+	if err != nil {
+		t.Fatalf("connect with ticket failed: %v", err)
+	}
+	defer ws.Close()
+	output := strings.Join(collectMessages(ws, 3*time.Second), "\n")
+	if got, _ := findEnvValue(output, "REMOTE_USER"); got != "ticket-holder" {
+		t.Errorf("REMOTE_USER = %q, want ticket-holder", got)
+	}
+}
+
+func TestAuth_BadSpecRefused(t *testing.T) {
+	t.Parallel()
+	_, stderr, exitCode := runWebsocketd(t, "--port=0", "--auth=basic:/nonexistent/htpasswd", "cat")
+	if exitCode != 1 || !strings.Contains(stderr, "--auth") {
+		t.Errorf("want exit 1 naming --auth, got %d: %q", exitCode, stderr)
+	}
+	_, stderr, exitCode = runWebsocketd(t, "--port=0", "--jwtaudience=x", "cat")
+	if exitCode != 1 || !strings.Contains(stderr, "--jwtaudience") {
+		t.Errorf("want exit 1 naming --jwtaudience, got %d: %q", exitCode, stderr)
+	}
+}
@@ -0,0 +1,50 @@
+package integration
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests for --broadcast: one process whose output goes to every client.
+
+func TestBroadcast_FanOut(t *testing.T) {
+	t.Parallel()
+	metricsPort := freePort(t)
+	s := startServerOpts(t, []string{"--broadcast", "--broadcastinput", "--metrics=127.0.0.1:" + strconv.Itoa(metricsPort)}, "echo")
+	waitForPort(t, metricsPort, 10*time.Second)
+
+	a := s.Connect("/")
+	defer a.Close()
+	b := s.Connect("/")
+	defer b.Close()
+	// Both must be receiving before anything is sent.
+	deadline := time.Now().Add(3 * time.Second)
+	for !strings.Contains(scrapeMetrics(t, metricsPort), "websocketd_sessions_active 2\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("sessions did not start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	a.Send("from a")
+	a.ExpectMessage("from a")
+	b.ExpectMessage("from a")
+	b.Send("from b")
+	a.ExpectMessage("from b")
+	b.ExpectMessage("from b")
+}
+
+func TestBroadcast_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--broadcast", "--multiplex", "cat"},
+		{"--broadcast", "--slowclient=wait", "cat"},
+		{"--broadcast", "--broadcastbuffer=0", "cat"},
+	} {
+		if _, stderr, exit := runWebsocketd(t, args...); exit == 0 {
+			t.Errorf("%v: exit 0, stderr %q; want an error", args, stderr)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for --sslclientauth=optional and --certrule: with optional client
+// certificates, browsers without one can still use the site, while the
+// scripts under a rule's path need a verified certificate it allows.
+
+func TestCertRule_OptionalClientCert(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts here are /bin/sh")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	caCertFile, caKeyFile := generateCA(t, dir)
+	serverCertFile, serverKeyFile := generateSignedCert(t, dir, "server", caCertFile, caKeyFile)
+	aliceCertFile, aliceKeyFile := generateSignedCert(t, dir, "alice", caCertFile, caKeyFile)
+	bobCertFile, bobKeyFile := generateSignedCert(t, dir, "bob", caCertFile, caKeyFile)
+
+	scripts := filepath.Join(dir, "scripts")
+	static := filepath.Join(dir, "static")
+	os.MkdirAll(filepath.Join(scripts, "admin"), 0755)
+	os.Mkdir(static, 0755)
+	for _, name := range []string{"chat", "admin/deploy"} {
+		if err := os.WriteFile(filepath.Join(scripts, name), []byte("#!/bin/sh\necho ok\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(static, "index.html"), []byte("hello"), 0644)
+
+	port := freePort(t)
+	cmd := exec.Command(websocketdBin,
+		"--port="+strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--loglevel=error",
+		"--ssl",
+		"--sslcert="+serverCertFile,
+		"--sslkey="+serverKeyFile,
+		"--sslca="+caCertFile,
+		"--sslclientauth=optional",
+		"--certrule=/admin:alice",
+		"--dir="+scripts,
+		"--staticdir="+static,
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	waitForPort(t, port, 10*time.Second)
+
+	caCert, _ := os.ReadFile(caCertFile)
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+	tlsFor := func(certFile, keyFile string) *tls.Config {
+		cfg := &tls.Config{RootCAs: caCertPool, ServerName: "localhost"}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatalf("failed to load client cert: %v", err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		return cfg
+	}
+	dial := func(path string, cfg *tls.Config) (int, error) {
+		dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second, TLSClientConfig: cfg}
+		conn, resp, err := dialer.Dial("wss://127.0.0.1:"+strconv.Itoa(port)+path, nil)
+		if err != nil {
+			if resp != nil {
+				return resp.StatusCode, err
+			}
+			return 0, err
+		}
+		conn.Close()
+		return http.StatusSwitchingProtocols, nil
+	}
+
+	noCert := tlsFor("", "")
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: noCert}, Timeout: 5 * time.Second}
+	resp, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/index.html")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("static file without a client certificate: %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		name, path string
+		cfg        *tls.Config
+		want       int
+	}{
+		{"no cert, open script", "/chat", noCert, http.StatusSwitchingProtocols},
+		{"no cert, ruled script", "/admin/deploy", noCert, http.StatusForbidden},
+		{"allowed cert", "/admin/deploy", tlsFor(aliceCertFile, aliceKeyFile), http.StatusSwitchingProtocols},
+		{"other cert", "/admin/deploy", tlsFor(bobCertFile, bobKeyFile), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got, err := dial(tt.path, tt.cfg); got != tt.want {
+			t.Errorf("%s: got status %d (%v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package integration
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for close code propagation: a process's exit status reaches its
+// client as a close code, and the client's close code can reach the process.
+
+// expectCloseFrame reads until the connection closes and returns the close
+// frame the server sent.
+func expectCloseFrame(t *testing.T, ws *WSClient) *websocket.CloseError {
+	t.Helper()
+	for {
+		_, err := ws.RecvTimeout(5 * time.Second)
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		return closeErr
+	}
+}
+
+func TestCloseCodes_Default(t *testing.T) {
+	t.Parallel()
+	s := startServer(t, "exit", "0", "bye")
+	ws := s.Connect("/")
+	ws.ExpectMessage("bye")
+	if got := expectCloseFrame(t, ws); got.Code != websocket.CloseNormalClosure {
+		t.Errorf("exit 0 sent close code %d, want 1000", got.Code)
+	}
+
+	s = startServer(t, "exit", "3")
+	if got := expectCloseFrame(t, s.Connect("/")); got.Code != websocket.CloseInternalServerErr {
+		t.Errorf("exit 3 sent close code %d, want 1011", got.Code)
+	}
+}
+
+func TestCloseCodes_Mapping(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--closecodes=0=1000,3=4003,*=1011"}, "exit", "3")
+	if got := expectCloseFrame(t, s.Connect("/")); got.Code != 4003 {
+		t.Errorf("exit 3 sent close code %d, want 4003", got.Code)
+	}
+}
+
+func TestCloseCodes_Reason(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--closereason"}, "stderr")
+	got := expectCloseFrame(t, s.Connect("/"))
+	if got.Code != websocket.CloseNormalClosure || got.Text != "stderr line" {
+		t.Errorf("close = %d %q, want 1000 \"stderr line\"", got.Code, got.Text)
+	}
+}
+
+func TestCloseCodes_Invalid(t *testing.T) {
+	t.Parallel()
+	stdout, stderr, exitCode := runWebsocketd(t, "--closecodes=0=1006", testcmdBin, "echo")
+	if exitCode != 1 {
+		t.Fatalf("expected exit 1 for a reserved close code, got %d", exitCode)
+	}
+	if !strings.Contains(stdout+stderr, "closecodes") {
+		t.Errorf("expected an error naming closecodes, got: %q %q", stdout, stderr)
+	}
+}
@@ -0,0 +1,172 @@
+package integration
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for --config: a JSON file of option settings, overridden by the
+// command line and validated exactly like flags.
+
+// writeConfig writes settings as a JSON config file and returns its path.
+func writeConfig(t *testing.T, settings map[string]interface{}) string {
+	t.Helper()
+	content, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := writeFile(dir, "websocketd.json", string(content)); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "websocketd.json")
+}
+
+func TestConfigFile_SettingsApply(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, map[string]interface{}{
+		"header-ws": []string{"X-From-File: yes"},
+	})
+	s := startServerOpts(t, []string{"--config=" + path}, "echo")
+
+	ws, resp, err := s.TryConnect("/", nil)
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer ws.Close()
+	if got := resp.Header.Get("X-From-File"); got != "yes" {
+		t.Errorf("X-From-File = %q, want the header from the config file", got)
+	}
+}
+
+func TestConfigFile_CommandFromFile(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, map[string]interface{}{
+		"command": []string{testcmdBin, "welcome", "from-file"},
+	})
+	port := freePort(t)
+	s := startServerRawArgs(t, []string{
+		"--port=" + strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--loglevel=access",
+		"--config=" + path,
+	})
+	if err := s.waitReady(port, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Port = port
+
+	ws := s.Connect("/")
+	defer ws.Close()
+	ws.ExpectMessage("from-file")
+}
+
+func TestConfigFile_CommandLineWins(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, map[string]interface{}{
+		"command": []string{testcmdBin, "welcome", "from-file"},
+	})
+	s := startServerOpts(t, []string{"--config=" + path}, "welcome", "from-cli")
+	ws := s.Connect("/")
+	defer ws.Close()
+	ws.ExpectMessage("from-cli")
+}
+
+func TestConfigFile_MergedResultIsValidated(t *testing.T) {
+	t.Parallel()
+	// Neither source is invalid alone; together they give --streambyte
+	// without --passstderr, which must be rejected as if both were flags.
+	path := writeConfig(t, map[string]interface{}{"binary": true, "passstderr": true, "streambyte": true})
+	_, stderr, exitCode := runWebsocketd(t, "--port=0", "--config="+path, "--passstderr=false", testcmdBin, "echo")
+	if exitCode == 0 {
+		t.Fatal("expected non-zero exit for --passstderr=false with streambyte from the config file")
+	}
+	if !strings.Contains(stderr, "--streambyte") || !strings.Contains(stderr, "--passstderr") {
+		t.Errorf("expected the usual validation error, got stderr: %q", stderr)
+	}
+}
+
+func TestConfigFile_UnknownSettingRejected(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, map[string]interface{}{"prot": 8080})
+	_, stderr, exitCode := runWebsocketd(t, "--config="+path, testcmdBin, "echo")
+	if exitCode == 0 {
+		t.Fatal("expected non-zero exit for a misspelled setting")
+	}
+	if !strings.Contains(stderr, `"prot"`) {
+		t.Errorf("expected the error to name the setting, got stderr: %q", stderr)
+	}
+}
+
+func TestConfigFile_Routes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts here are /bin/sh")
+	}
+	t.Parallel()
+	scripts := t.TempDir()
+	os.Mkdir(filepath.Join(scripts, "video"), 0755)
+	for _, name := range []string{"chat", "video/feed"} {
+		if err := writeFile(scripts, name, "#!/bin/sh\necho frame\n"); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(filepath.Join(scripts, name), 0755)
+	}
+	path := writeConfig(t, map[string]interface{}{
+		"routes": map[string]interface{}{
+			"/video": map[string]interface{}{"binary": true, "header-ws": []string{"X-Route: video"}},
+		},
+	})
+	port := freePort(t)
+	s := startServerRawArgs(t, []string{
+		"--port=" + strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--config=" + path,
+		"--dir=" + scripts,
+	})
+	if err := s.waitReady(port, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Port = port
+
+	tests := []struct {
+		path     string
+		msgType  int
+		wantHead string
+	}{
+		{"/chat", websocket.TextMessage, ""},
+		{"/video/feed", websocket.BinaryMessage, "video"},
+	}
+	for _, tt := range tests {
+		ws, resp, err := s.TryConnect(tt.path, nil)
+		if err != nil {
+			t.Fatalf("%s: connect failed: %v", tt.path, err)
+		}
+		if got := resp.Header.Get("X-Route"); got != tt.wantHead {
+			t.Errorf("%s: X-Route = %q, want %q", tt.path, got, tt.wantHead)
+		}
+		msgType, msg := ws.RecvBinary()
+		if msgType != tt.msgType || !strings.HasPrefix(string(msg), "frame") {
+			t.Errorf("%s: got message type %d %q, want type %d", tt.path, msgType, msg, tt.msgType)
+		}
+		ws.Close()
+	}
+}
+
+func TestConfigFile_RoutesNeedDir(t *testing.T) {
+	t.Parallel()
+	path := writeConfig(t, map[string]interface{}{
+		"routes": map[string]interface{}{"/video": map[string]interface{}{"binary": true}},
+	})
+	_, stderr, exit := runWebsocketd(t, "--config="+path, testcmdBin, "echo")
+	if exit == 0 || !strings.Contains(stderr, "--dir") {
+		t.Errorf("exit %d, stderr %q; want a complaint that routes need --dir", exit, stderr)
+	}
+}
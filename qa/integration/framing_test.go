@@ -0,0 +1,49 @@
+package integration
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests for --framing: one WebSocket message per record on stdin and stdout.
+
+func TestFraming_LengthPrefixedBinary(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--binary", "--framing=length"}, "binary-echo")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	// Sent back to back, these would come back as one read without framing.
+	msgs := [][]byte{{0, 1, 2, '\n'}, {'\n'}, bytes.Repeat([]byte{0xff}, 100000)}
+	for _, msg := range msgs {
+		ws.SendBinary(msg)
+	}
+	for _, want := range msgs {
+		if _, got := ws.RecvBinary(); !bytes.Equal(got, want) {
+			t.Errorf("got %d bytes, want %d: one message per record", len(got), len(want))
+		}
+	}
+}
+
+func TestFraming_JSONLines(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--framing=jsonlines"}, "echo")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	ws.Send("two\nlines")
+	ws.ExpectMessage("two\nlines")
+}
+
+func TestFraming_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--framing=chunks", "cat"},
+		{"--framing=delim:ab", "cat"},
+		{"--framing=netstring", "--multiplex", "cat"},
+	} {
+		if _, stderr, exit := runWebsocketd(t, args...); exit == 0 {
+			t.Errorf("%v: exit 0, stderr %q; want an error", args, stderr)
+		}
+	}
+}
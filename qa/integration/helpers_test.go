@@ -416,6 +416,20 @@ func (s *Server) Stdout() string {
 	return s.stdout.String()
 }
 
+// WaitForStdout polls websocketd's stdout until it contains want, failing
+// the test after timeout. Log lines are written after the event they
+// describe, so a test that just closed a session must wait for its lines.
+func (s *Server) WaitForStdout(want string, timeout time.Duration) {
+	s.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !strings.Contains(s.Stdout(), want) {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("websocketd never logged %q; stdout:\n%s", want, s.Stdout())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
 // Stderr returns websocketd's captured stderr so far.
 func (s *Server) Stderr() string {
 	return s.stderr.String()
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
 	"net"
@@ -140,6 +141,7 @@ func generateCA(t *testing.T, dir string) (certFile, keyFile string) {
 
 	template := x509.Certificate{
 		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "websocketd test CA"},
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(time.Hour),
 		IsCA:                  true,
@@ -186,6 +188,7 @@ func generateSignedCert(t *testing.T, dir, name, caCertFile, caKeyFile string) (
 
 	template := x509.Certificate{
 		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().Add(time.Hour),
 		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
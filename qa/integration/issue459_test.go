@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // Tests for #459: forward STDERR to WebSocket clients via --passstderr.
@@ -63,13 +65,94 @@ func TestIssue459_NoPassStderrByDefault(t *testing.T) {
 	ws.ExpectClosed()
 }
 
-func TestIssue459_BinaryAndPassStderrRejected(t *testing.T) {
+func TestIssue459_BinaryPassStderr(t *testing.T) {
+	// With --binary, stdout stays binary and stderr comes as text messages.
+	t.Parallel()
+	s := startServerOpts(t, []string{"--binary", "--passstderr"}, "stderr")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	got := map[int]string{}
+	for len(got) < 2 {
+		mtype, msg := ws.RecvBinary()
+		got[mtype] = string(msg)
+	}
+	if got[websocket.BinaryMessage] != "stdout line\n" || got[websocket.TextMessage] != "stderr line" {
+		t.Errorf("got binary %q and text %q", got[websocket.BinaryMessage], got[websocket.TextMessage])
+	}
+}
+
+func TestIssue459_StreamByte(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--binary", "--passstderr", "--streambyte"}, "stderr")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	got := map[byte]string{}
+	for len(got) < 2 {
+		mtype, msg := ws.RecvBinary()
+		if mtype != websocket.BinaryMessage || len(msg) == 0 {
+			t.Fatalf("got message of type %d: %q", mtype, msg)
+		}
+		got[msg[0]] = string(msg[1:])
+	}
+	if got[1] != "stdout line\n" || got[2] != "stderr line" {
+		t.Errorf("got stdout %q and stderr %q", got[1], got[2])
+	}
+}
+
+func TestIssue459_StreamByteNeedsBinaryPassStderr(t *testing.T) {
+	t.Parallel()
+	_, stderr, exitCode := runWebsocketd(t, "--port=0", "--binary", "--streambyte", testcmdBin, "echo")
+	if exitCode == 0 {
+		t.Fatal("expected non-zero exit for --streambyte without --passstderr")
+	}
+	if !strings.Contains(stderr, "--streambyte") {
+		t.Errorf("expected error mentioning --streambyte, got stderr: %q", stderr)
+	}
+}
+
+func TestIssue459_ExtendedEnvelope(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--passstderr", "--envelope=extended"}, "exit", "3", "bye")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	var out, exit struct {
+		Stream string `json:"stream"`
+		Data   string `json:"data"`
+		Seq    int    `json:"seq"`
+		Time   string `json:"time"`
+		Code   int    `json:"code"`
+	}
+	if msg := ws.Recv(); json.Unmarshal([]byte(msg), &out) != nil || out.Stream != "stdout" || out.Data != "bye" || out.Seq != 1 || out.Time == "" {
+		t.Errorf("got %q, want stdout bye numbered 1 and timed", msg)
+	}
+	if msg := ws.Recv(); json.Unmarshal([]byte(msg), &exit) != nil || exit.Stream != "exit" || exit.Code != 3 || exit.Seq != 2 {
+		t.Errorf("got %q, want an exit message of code 3 numbered 2", msg)
+	}
+	ws.ExpectClosed()
+}
+
+func TestIssue459_RawStdout(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--passstderr", "--rawstdout"}, "stderr")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	got := map[string]bool{ws.Recv(): true, ws.Recv(): true}
+	if !got["stdout line"] || !got[`{"stream":"stderr","data":"stderr line"}`] {
+		t.Errorf("got %v, want stdout as it is and stderr wrapped", got)
+	}
+}
+
+func TestIssue459_EnvelopeNeedsPassStderr(t *testing.T) {
 	t.Parallel()
-	_, stderr, exitCode := runWebsocketd(t, "--port=0", "--binary", "--passstderr", testcmdBin, "echo")
+	_, stderr, exitCode := runWebsocketd(t, "--port=0", "--envelope=extended", testcmdBin, "echo")
 	if exitCode == 0 {
-		t.Fatal("expected non-zero exit combining --binary and --passstderr")
+		t.Fatal("expected non-zero exit for --envelope=extended without --passstderr")
 	}
-	if !strings.Contains(stderr, "--binary") || !strings.Contains(stderr, "--passstderr") {
-		t.Errorf("expected error mentioning both flags, got stderr: %q", stderr)
+	if !strings.Contains(stderr, "--envelope") {
+		t.Errorf("expected error mentioning --envelope, got stderr: %q", stderr)
 	}
 }
@@ -0,0 +1,97 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Tests for --logfile and --accesslog, including reopening on SIGHUP.
+
+// waitForFile polls until the file at path contains want.
+func waitForFile(t *testing.T, path, want string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, _ := os.ReadFile(path)
+		if strings.Contains(string(content), want) {
+			return string(content)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never contained %q; it has:\n%s", path, want, content)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// startServerLogging starts websocketd with log flags that take the access
+// log off stdout, where startServer's readiness probe looks for it.
+func startServerLogging(t *testing.T, logFlags []string, mode string) *Server {
+	t.Helper()
+	port := freePort(t)
+	args := append([]string{"--port=" + strconv.Itoa(port), "--address=127.0.0.1"}, logFlags...)
+	s := startServerRawArgs(t, append(args, testcmdBin, mode))
+	waitForPort(t, port, 10*time.Second)
+	s.Port = port
+	return s
+}
+
+func TestLogFile_SeparateAccessLog(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	logFile, accessLog := filepath.Join(dir, "ws.log"), filepath.Join(dir, "access.log")
+	s := startServerLogging(t, []string{"--logfile=" + logFile, "--accesslog=" + accessLog}, "stderr")
+	ws := s.Connect("/")
+	ws.ExpectMessage("stdout line")
+	ws.Close()
+
+	access := waitForFile(t, accessLog, "CONNECT")
+	main := waitForFile(t, logFile, "stderr line")
+	if strings.Contains(access, "stderr line") || strings.Contains(access, "Starting WebSocket server") {
+		t.Errorf("access log has non-access lines:\n%s", access)
+	}
+	if strings.Contains(main, "CONNECT") {
+		t.Errorf("main log has access lines:\n%s", main)
+	}
+	if s.Stdout() != "" {
+		t.Errorf("nothing should go to stdout with --logfile, got:\n%s", s.Stdout())
+	}
+}
+
+func TestLogFile_ReopenOnSIGHUP(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "ws.log")
+	s := startServerLogging(t, []string{"--logfile=" + logFile}, "echo")
+	waitForFile(t, logFile, "Starting WebSocket server")
+
+	rotated := logFile + ".1"
+	if err := os.Rename(logFile, rotated); err != nil {
+		t.Fatal(err)
+	}
+	s.cmd.Process.Signal(syscall.SIGHUP)
+	waitForFile(t, logFile, "Received SIGHUP")
+
+	ws := s.Connect("/")
+	ws.Close()
+	waitForFile(t, logFile, "CONNECT")
+	if content, _ := os.ReadFile(rotated); strings.Contains(string(content), "CONNECT") {
+		t.Error("lines after SIGHUP went to the rotated file")
+	}
+}
+
+func TestLogFile_RotationNeedsAFile(t *testing.T) {
+	t.Parallel()
+	_, stderr, exitCode := runWebsocketd(t, "--logrotate=daily", testcmdBin, "echo")
+	if exitCode == 0 {
+		t.Fatal("expected non-zero exit for --logrotate without a log file")
+	}
+	if !strings.Contains(stderr, "--logfile") {
+		t.Errorf("expected the error to mention --logfile, got: %q", stderr)
+	}
+}
@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests for --logformat: json and logfmt output carry the session's
+// associated values as fields.
+
+func TestLogFormat_JSON(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--logformat=json"}, "echo")
+	ws := s.Connect("/")
+	ws.Send("hi")
+	ws.ExpectMessage("hi")
+	ws.Close()
+	s.WaitForStdout(`"message":"CONNECT"`, 5*time.Second)
+
+	var connect map[string]string
+	for _, line := range strings.Split(strings.TrimSpace(s.Stdout()), "\n") {
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line is not a JSON object: %q: %v", line, err)
+		}
+		if fields["message"] == "CONNECT" {
+			connect = fields
+		}
+	}
+	for _, key := range []string{"timestamp", "level", "category", "id", "remote", "url", "command"} {
+		if connect[key] == "" {
+			t.Errorf("CONNECT line has no %q field: %v", key, connect)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, connect["timestamp"]); err != nil {
+		t.Errorf("timestamp %q is not RFC 3339: %v", connect["timestamp"], err)
+	}
+}
+
+func TestLogFormat_Logfmt(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--logformat=logfmt"}, "echo")
+	ws := s.Connect("/")
+	ws.Close()
+	s.WaitForStdout("level=access category=session message=CONNECT", 5*time.Second)
+}
+
+func TestLogFormat_Invalid(t *testing.T) {
+	t.Parallel()
+	stdout, _, exitCode := runWebsocketd(t, "--logformat=xml", testcmdBin, "echo")
+	if exitCode == 0 {
+		t.Fatal("expected non-zero exit for an unknown log format")
+	}
+	if !strings.Contains(stdout, "logformat") {
+		t.Errorf("expected an error naming logformat, got: %q", stdout)
+	}
+}
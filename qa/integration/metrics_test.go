@@ -0,0 +1,53 @@
+package integration
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests for --metrics: a separate Prometheus listener.
+
+func scrapeMetrics(t *testing.T, port int) string {
+	t.Helper()
+	resp, err := http.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/metrics")
+	if err != nil {
+		t.Fatalf("scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMetrics_Endpoint(t *testing.T) {
+	t.Parallel()
+	metricsPort := freePort(t)
+	s := startServerOpts(t, []string{"--metrics=127.0.0.1:" + strconv.Itoa(metricsPort)}, "echo")
+	waitForPort(t, metricsPort, 10*time.Second)
+
+	ws := s.Connect("/")
+	ws.Send("hello")
+	ws.ExpectMessage("hello")
+
+	body := scrapeMetrics(t, metricsPort)
+	for _, want := range []string{
+		"websocketd_sessions_active 1",
+		"websocketd_messages_received_total 1",
+		"websocketd_messages_sent_total 1",
+		"# TYPE websocketd_upgrades_total counter",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	// Metrics are not served on the WebSocket port.
+	resp, _ := s.HTTPGet("/metrics")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/metrics on the main port = %d, want 404", resp.StatusCode)
+	}
+	ws.Close()
+}
@@ -0,0 +1,40 @@
+package integration
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for --mixed: text and binary messages in one session.
+
+func TestMixed_Echo(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--mixed", "--framing=length"}, "binary-echo")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	// The type byte goes to the process and comes back with the echo.
+	ws.Send(`{"op":"upload"}`)
+	ws.SendBinary([]byte{0, 1, 2})
+	if mtype, msg := ws.RecvBinary(); mtype != websocket.TextMessage || string(msg) != `{"op":"upload"}` {
+		t.Errorf("got type %d %q, want the text message", mtype, msg)
+	}
+	if mtype, msg := ws.RecvBinary(); mtype != websocket.BinaryMessage || !bytes.Equal(msg, []byte{0, 1, 2}) {
+		t.Errorf("got type %d %q, want the binary message", mtype, msg)
+	}
+}
+
+func TestMixed_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--mixed", "cat"},
+		{"--mixed", "--framing=jsonlines", "cat"},
+		{"--mixed", "--framing=length", "--passstderr", "cat"},
+	} {
+		if _, stderr, exit := runWebsocketd(t, args...); exit == 0 {
+			t.Errorf("%v: exit 0, stderr %q; want an error", args, stderr)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests for --multiplex: one process serving every session, framed as JSON
+// lines (see libwebsocketd.MultiplexEnvVar).
+
+func TestMultiplex_SharedProcess(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--multiplex"}, "mux-echo")
+
+	a := s.Connect("/?name=a")
+	defer a.Close()
+	b := s.Connect("/?name=b")
+	defer b.Close()
+	greetA, greetB := a.Recv(), b.Recv()
+	if !strings.HasSuffix(greetA, " name=a") || !strings.HasSuffix(greetB, " name=b") {
+		t.Fatalf("greetings %q and %q, want each session's QUERY_STRING", greetA, greetB)
+	}
+	if pidA, pidB := strings.Fields(greetA)[1], strings.Fields(greetB)[1]; pidA != pidB {
+		t.Errorf("sessions got processes %s and %s, want one", pidA, pidB)
+	}
+
+	a.Send("hello")
+	a.ExpectMessage("hello")
+	b.Send("count")
+	b.ExpectMessage("2")
+
+	// The process closes a with its own code; it hears when c leaves.
+	a.Send("bye")
+	if closeErr := expectCloseFrame(t, a); closeErr.Code != 4000 || closeErr.Text != "bye" {
+		t.Errorf("close frame %d %q, want 4000 \"bye\"", closeErr.Code, closeErr.Text)
+	}
+	c := s.Connect("/")
+	c.Recv()
+	c.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		b.Send("count")
+		if got := b.Recv(); got == "1" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("process counts %s sessions, want 1", got)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestMultiplex_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--multiplex", "--poolmin=2", "cat"},
+		{"--multiplex", "--passstderr", "cat"},
+	} {
+		if _, stderr, exit := runWebsocketd(t, args...); exit == 0 {
+			t.Errorf("%v: exit 0, stderr %q; want an error", args, stderr)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package integration
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Tests for --poolmin/--poolmax: processes started ahead of their sessions.
+
+func TestPool_Handshake(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses /bin/sh")
+	}
+	s := startServerRaw(t, []string{"--poolmin=2", "--poolmax=4"}, "/bin/sh", "-c",
+		`read -r vars; echo "$WEBSOCKETD_POOL $vars"; exec cat`)
+
+	for i := 0; i < 3; i++ {
+		ws := s.Connect("/?n=1")
+		flag, handshake, _ := strings.Cut(ws.Recv(), " ")
+		if flag != "1" {
+			t.Errorf("WEBSOCKETD_POOL = %q, want 1", flag)
+		}
+		var vars map[string]string
+		if err := json.Unmarshal([]byte(handshake), &vars); err != nil {
+			t.Fatalf("handshake %q: %v", handshake, err)
+		}
+		if vars["QUERY_STRING"] != "n=1" || vars["REMOTE_ADDR"] != "127.0.0.1" {
+			t.Errorf("handshake = %v, want the session's variables", vars)
+		}
+		ws.Send("hi")
+		ws.ExpectMessage("hi")
+		ws.Close()
+	}
+}
+
+func TestPool_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--poolmin=-1", "cat"},
+		{"--poolmax=2", "cat"},
+		{"--poolmin=3", "--poolmax=2", "cat"},
+	} {
+		if _, stderr, exit := runWebsocketd(t, args...); exit == 0 {
+			t.Errorf("%v: exit 0, stderr %q; want an error", args, stderr)
+		}
+	}
+}
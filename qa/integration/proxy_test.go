@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for --trustedproxy and --proxyprotocol: behind a trusted proxy, the
+// process sees the client's address, host and scheme rather than the proxy's.
+
+func TestProxy_ForwardedHeaders(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--trustedproxy=127.0.0.1"}, "env")
+	ws, _, err := s.TryConnect("/", http.Header{
+		"X-Forwarded-For":   {"203.0.113.9"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"public.example"},
+	})
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer ws.Close()
+	output := strings.Join(collectMessages(ws, 3*time.Second), "\n")
+
+	want := map[string]string{
+		"REMOTE_ADDR": "203.0.113.9",
+		"REMOTE_PORT": "",
+		"SERVER_NAME": "public.example",
+		"SERVER_PORT": "443",
+		"HTTPS":       "on",
+	}
+	for k, v := range want {
+		if got, ok := findEnvValue(output, k); !ok || got != v {
+			t.Errorf("%s = %q (set %v), want %q", k, got, ok, v)
+		}
+	}
+	s.WaitForStdout("proxy:'127.0.0.1:", 5*time.Second)
+}
+
+func TestProxy_UntrustedHeadersIgnored(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--trustedproxy=192.0.2.0/24"}, "env")
+	ws, _, err := s.TryConnect("/", http.Header{"X-Forwarded-For": {"203.0.113.9"}})
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer ws.Close()
+	output := strings.Join(collectMessages(ws, 3*time.Second), "\n")
+	if got, _ := findEnvValue(output, "REMOTE_ADDR"); got != "127.0.0.1" {
+		t.Errorf("REMOTE_ADDR = %q; an untrusted peer's X-Forwarded-For must be ignored", got)
+	}
+}
+
+func TestProxy_ProxyProtocol(t *testing.T) {
+	t.Parallel()
+	// Not startServer: its readiness probe sends no PROXY header.
+	port := freePort(t)
+	startServerRawArgs(t, []string{
+		"--port=" + strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--trustedproxy=127.0.0.1", "--proxyprotocol",
+		testcmdBin, "env",
+	})
+	waitForPort(t, port, 10*time.Second)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("PROXY TCP4 203.0.113.9 127.0.0.1 41000 80\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("ws://127.0.0.1:" + strconv.Itoa(port) + "/")
+	ws, _, err := websocket.NewClient(conn, u, nil, 1024, 1024)
+	if err != nil {
+		t.Fatalf("handshake after PROXY header failed: %v", err)
+	}
+	defer ws.Close()
+
+	var lines []string
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		lines = append(lines, string(msg))
+	}
+	output := strings.Join(lines, "\n")
+	if got, _ := findEnvValue(output, "REMOTE_ADDR"); got != "203.0.113.9" {
+		t.Errorf("REMOTE_ADDR = %q, want the address from the PROXY header", got)
+	}
+	if got, _ := findEnvValue(output, "REMOTE_PORT"); got != "41000" {
+		t.Errorf("REMOTE_PORT = %q, want the port from the PROXY header", got)
+	}
+}
+
+func TestProxy_ProxyProtocolNeedsTrustedProxy(t *testing.T) {
+	t.Parallel()
+	_, stderr, exitCode := runWebsocketd(t, "--proxyprotocol", testcmdBin, "echo")
+	if exitCode != 1 || !strings.Contains(stderr, "trustedproxy") {
+		t.Errorf("want exit 1 naming --trustedproxy, got %d: %q", exitCode, stderr)
+	}
+}
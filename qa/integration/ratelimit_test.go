@@ -0,0 +1,58 @@
+package integration
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Tests for the per-client limits: --maxclientconns and --clientrate answer
+// 429 with Retry-After once a client is over them.
+
+func expectTooMany(t *testing.T, s *Server) {
+	t.Helper()
+	ws, resp, err := s.TryConnect("/", nil)
+	if err == nil {
+		ws.Close()
+		t.Fatal("upgrade over the per-client limit succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v (%v)", resp, err)
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || secs < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestRateLimit_MaxClientConns(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--maxclientconns=2"}, "echo")
+	a := s.Connect("/")
+	defer a.Close()
+	b := s.Connect("/")
+	defer b.Close()
+
+	expectTooMany(t, s)
+	s.WaitForStdout("already has 2 session(s)", 5*time.Second)
+
+	a.Send("still here")
+	a.ExpectMessage("still here")
+}
+
+func TestRateLimit_ClientRate(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--clientrate=0.1", "--clientburst=2"}, "echo")
+	for i := 0; i < 2; i++ {
+		s.Connect("/").Close()
+	}
+	expectTooMany(t, s)
+}
+
+func TestRateLimit_InvalidFlags(t *testing.T) {
+	t.Parallel()
+	_, _, exitCode := runWebsocketd(t, "--clientburst=5", testcmdBin, "echo")
+	if exitCode != 1 {
+		t.Errorf("expected exit 1 for --clientburst without --clientrate, got %d", exitCode)
+	}
+}
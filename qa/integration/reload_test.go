@@ -0,0 +1,216 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for SIGHUP: TLS files and the reloadable --config settings are
+// re-read without dropping live sessions.
+
+// copyFile overwrites dst with the contents of src.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	content, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sighup sends SIGHUP and waits for the server to log want.
+func sighup(t *testing.T, s *Server, want string) {
+	t.Helper()
+	before := strings.Count(s.Stdout(), want)
+	s.cmd.Process.Signal(syscall.SIGHUP)
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(s.Stdout(), want) == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("no %q log line after SIGHUP:\n%s", want, s.Stdout())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// servedCert returns the leaf certificate the server presents.
+func servedCert(t *testing.T, port int) []byte {
+	t.Helper()
+	conn, err := tls.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS dial failed: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Raw
+}
+
+func certDER(t *testing.T, certFile, keyFile string) []byte {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestReload_RotatesCertificate(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	oldCert, oldKey := generateTestCert(t)
+	newCert, newKey := generateTestCert(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	copyFile(t, oldCert, certFile)
+	copyFile(t, oldKey, keyFile)
+
+	s := startServerOpts(t, []string{"--ssl", "--sslcert=" + certFile, "--sslkey=" + keyFile}, "echo")
+	if !bytes.Equal(servedCert(t, s.Port), certDER(t, oldCert, oldKey)) {
+		t.Fatal("server is not presenting the initial certificate")
+	}
+	ws := s.ConnectTLS("/")
+	defer ws.Close()
+
+	copyFile(t, newCert, certFile)
+	copyFile(t, newKey, keyFile)
+	sighup(t, s, "Reloaded TLS certificates")
+
+	if !bytes.Equal(servedCert(t, s.Port), certDER(t, newCert, newKey)) {
+		t.Error("new handshakes should present the reloaded certificate")
+	}
+	ws.Send("still here")
+	ws.ExpectMessage("still here")
+}
+
+func TestReload_BrokenCertificateKeepsPrevious(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	origCert, origKey := generateTestCert(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	copyFile(t, origCert, certFile)
+	copyFile(t, origKey, keyFile)
+
+	s := startServerOpts(t, []string{"--ssl", "--sslcert=" + certFile, "--sslkey=" + keyFile}, "echo")
+
+	if err := os.WriteFile(certFile, []byte("half-written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sighup(t, s, "Keeping previous TLS certificates")
+
+	if !bytes.Equal(servedCert(t, s.Port), certDER(t, origCert, origKey)) {
+		t.Error("a failed reload should leave the previous certificate in service")
+	}
+}
+
+func TestReload_ClientCA(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	dir := t.TempDir()
+	oldCA, oldCAKey := generateCA(t, dir)
+	serverCert, serverKey := generateSignedCert(t, dir, "server", oldCA, oldCAKey)
+	caFile := filepath.Join(t.TempDir(), "clients.pem")
+	copyFile(t, oldCA, caFile)
+
+	newDir := t.TempDir()
+	newCA, newCAKey := generateCA(t, newDir)
+	clientCert, clientKey := generateSignedCert(t, newDir, "client", newCA, newCAKey)
+
+	// Not startServer: its readiness probe has no client certificate.
+	port := freePort(t)
+	s := startServerRawArgs(t, []string{
+		"--port=" + strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--loglevel=access",
+		"--ssl", "--sslcert=" + serverCert, "--sslkey=" + serverKey, "--sslca=" + caFile,
+		testcmdBin, "echo",
+	})
+	waitForPort(t, port, 10*time.Second)
+
+	serverCAPEM, _ := os.ReadFile(oldCA)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCAPEM)
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 5 * time.Second,
+		TLSClientConfig:  &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}},
+	}
+	url := "wss://127.0.0.1:" + strconv.Itoa(port) + "/"
+
+	if conn, _, err := dialer.Dial(url, nil); err == nil {
+		conn.Close()
+		t.Fatal("client cert from an untrusted CA was accepted")
+	}
+
+	copyFile(t, newCA, caFile)
+	sighup(t, s, "Reloaded TLS certificates")
+
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("client cert from the reloaded CA was rejected: %v", err)
+	}
+	conn.Close()
+}
+
+func TestReload_ConfigFileSettings(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	path := writeConfig(t, map[string]interface{}{
+		"header-ws": []string{"X-Version: 1"},
+	})
+	s := startServerOpts(t, []string{"--config=" + path}, "echo")
+	live := s.Connect("/")
+	defer live.Close()
+
+	rewrite := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	header := func() string {
+		ws, resp, err := s.TryConnect("/", nil)
+		if err != nil {
+			t.Fatalf("connect failed: %v", err)
+		}
+		ws.Close()
+		return resp.Header.Get("X-Version")
+	}
+
+	rewrite(`{"header-ws": ["X-Version: 2"]}`)
+	sighup(t, s, "Reloaded settings")
+	if got := header(); got != "2" {
+		t.Errorf("X-Version = %q after reload, want 2", got)
+	}
+
+	rewrite(`{"header-ws": "X-Version: 3", "maxforks": "many"}`)
+	sighup(t, s, "Keeping previous settings")
+	if got := header(); got != "2" {
+		t.Errorf("X-Version = %q after a failed reload, want the previous 2", got)
+	}
+
+	rewrite(`{"header-ws": ["X-Version: 4"], "origin": "https://only.example"}`)
+	sighup(t, s, "Reloaded settings")
+	if _, resp, err := s.TryConnect("/", http.Header{"Origin": {"http://127.0.0.1"}}); err == nil {
+		t.Error("upgrade from a disallowed origin succeeded after reload")
+	} else if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for a disallowed origin, got %v", resp)
+	}
+
+	// The session opened before any reload is untouched.
+	live.Send("hello")
+	live.ExpectMessage("hello")
+}
@@ -0,0 +1,55 @@
+package integration
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Tests for --resumems: a session's process kept for its client to
+// reconnect to.
+
+func TestResume_Reconnect(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--resumems=5000"}, "pid-echo")
+
+	var greeting struct {
+		Token   string `json:"resume"`
+		Resumed bool   `json:"resumed"`
+	}
+	a := s.Connect("/")
+	if err := json.Unmarshal([]byte(a.Recv()), &greeting); err != nil || greeting.Token == "" {
+		t.Fatalf("first message is not a resume token: %v", err)
+	}
+	pid := a.Recv()
+	// Gone without a close frame, as when the network drops.
+	a.conn.UnderlyingConn().Close()
+
+	b := s.Connect("/?websocketd_resume=" + greeting.Token)
+	defer b.Close()
+	if err := json.Unmarshal([]byte(b.Recv()), &greeting); err != nil || !greeting.Resumed {
+		t.Fatalf("session was not resumed: %+v, %v", greeting, err)
+	}
+	b.Send("pid?")
+	b.ExpectMessage("pid?")
+
+	// A session without the token gets a process of its own.
+	c := s.Connect("/")
+	defer c.Close()
+	c.Recv()
+	if got := c.Recv(); got == pid {
+		t.Errorf("new session has the resumed process %s", pid)
+	}
+}
+
+func TestResume_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, args := range [][]string{
+		{"--resumems=1000", "--multiplex", "cat"},
+		{"--resumems=1000", "--broadcast", "cat"},
+		{"--resumems=1000", "--resumebuffer=0", "cat"},
+	} {
+		if _, stderr, exit := runWebsocketd(t, args...); exit == 0 {
+			t.Errorf("%v: exit 0, stderr %q; want an error", args, stderr)
+		}
+	}
+}
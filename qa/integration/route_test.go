@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for --route: several commands on their own paths of one server,
+// without a --dir of wrapper scripts.
+
+func TestRoute_SeveralCommands(t *testing.T) {
+	t.Parallel()
+	static := t.TempDir()
+	if err := os.WriteFile(filepath.Join(static, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfig(t, map[string]interface{}{
+		"routes": map[string]interface{}{
+			"/bytes": map[string]interface{}{"binary": true},
+		},
+	})
+	port := freePort(t)
+	s := startServerRawArgs(t, []string{
+		"--port=" + strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--config=" + path,
+		"--staticdir=" + static,
+		"--route", "/alpha='" + testcmdBin + "' welcome alpha",
+		"--route", "/beta='" + testcmdBin + "' welcome \"beta gamma\"",
+		"--route", "/bytes='" + testcmdBin + "' welcome bytes",
+	})
+	if err := s.waitReady(port, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Port = port
+
+	tests := []struct {
+		path, want string
+		msgType    int
+	}{
+		{"/alpha", "alpha", websocket.TextMessage},
+		{"/alpha/room/1", "alpha", websocket.TextMessage},
+		{"/beta", "beta gamma", websocket.TextMessage},
+		{"/bytes", "bytes\n", websocket.BinaryMessage},
+	}
+	for _, tt := range tests {
+		ws, _, err := s.TryConnect(tt.path, nil)
+		if err != nil {
+			t.Fatalf("%s: connect failed: %v", tt.path, err)
+		}
+		if msgType, msg := ws.RecvBinary(); msgType != tt.msgType || string(msg) != tt.want {
+			t.Errorf("%s: got type %d %q, want type %d %q", tt.path, msgType, msg, tt.msgType, tt.want)
+		}
+		ws.Close()
+	}
+
+	if _, resp, err := s.TryConnect("/gamma", nil); err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unrouted path: got %v, %v; want 404", resp, err)
+	}
+	if resp, body := s.HTTPGet("/"); resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Errorf("static file: got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestRoute_Invalid(t *testing.T) {
+	t.Parallel()
+	for _, arg := range []string{"alpha=cat", "/alpha=", "/alpha=no-such-command-here"} {
+		if _, stderr, exit := runWebsocketd(t, "--route", arg); exit == 0 {
+			t.Errorf("--route %s: exit 0, stderr %q; want an error", arg, stderr)
+		}
+	}
+}
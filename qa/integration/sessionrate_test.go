@@ -0,0 +1,42 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for the per-session rate limits: a session over a rate is throttled,
+// or closed with 1008 under --ratemode=close.
+
+func TestSessionRate_CloseInbound(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--inmsgrate=1", "--ratemode=close"}, "echo")
+	ws := s.Connect("/")
+	for i := 0; i < 3; i++ {
+		ws.Send("flood")
+	}
+	got := expectCloseFrame(t, ws)
+	if got.Code != websocket.ClosePolicyViolation {
+		t.Errorf("close code %d, want 1008", got.Code)
+	}
+	s.WaitForStdout("inbound rate limit exceeded", 5*time.Second)
+}
+
+func TestSessionRate_ThrottleOutbound(t *testing.T) {
+	t.Parallel()
+	lines := make([]string, 15)
+	for i := range lines {
+		lines[i] = "line"
+	}
+	s := startServerOpts(t, []string{"--outmsgrate=10"}, "output", lines...)
+	start := time.Now()
+	ws := s.Connect("/")
+	defer ws.Close()
+	ws.ExpectMessages(lines...)
+	// A burst of 10, then the last 5 at 10 a second.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("15 messages arrived in %v; --outmsgrate=10 should take about 500ms", elapsed)
+	}
+}
@@ -0,0 +1,131 @@
+package integration
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for graceful shutdown: SIGINT/SIGTERM drain live sessions within
+// --drainms, then exit with a documented status (0 drained, 5 deadline).
+
+func skipSignalsOnWindows(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("cannot deliver SIGTERM on windows")
+	}
+}
+
+// processAlive reports whether pid still names a running process.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+func TestShutdown_SIGTERMDrainsSessions(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	s := startServer(t, "pid-echo")
+	ws := s.Connect("/")
+	pid, err := strconv.Atoi(ws.Recv())
+	if err != nil {
+		t.Fatalf("expected a pid: %v", err)
+	}
+
+	s.cmd.Process.Signal(syscall.SIGTERM)
+
+	ws.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = ws.conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a 1001 going away close, got %v", err)
+	}
+	if !s.WaitExit(10 * time.Second) {
+		t.Fatal("websocketd did not exit after SIGTERM")
+	}
+	if code := s.ExitCode(); code != 0 {
+		t.Errorf("exit code = %d, want 0 after a clean drain", code)
+	}
+	if processAlive(pid) {
+		t.Errorf("child %d still running after shutdown", pid)
+	}
+	if !strings.Contains(s.Stdout(), "Shutdown complete") {
+		t.Errorf("expected a shutdown log line, got:\n%s", s.Stdout())
+	}
+}
+
+func TestShutdown_GoingAwayDisabled(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	s := startServerOpts(t, []string{"--goingaway=false"}, "echo")
+	ws := s.Connect("/")
+	ws.Send("hi")
+	ws.ExpectMessage("hi")
+
+	s.cmd.Process.Signal(syscall.SIGINT)
+
+	ws.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := ws.conn.ReadMessage()
+	if err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+	if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Error("got a 1001 close frame with --goingaway=false")
+	}
+	if !s.WaitExit(10 * time.Second) {
+		t.Fatal("websocketd did not exit after SIGINT")
+	}
+	if code := s.ExitCode(); code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+}
+
+func TestShutdown_DeadlineKillsStubbornProcess(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	s := startServerOpts(t, []string{"--drainms=300"}, "ignore-signals")
+	ws := s.Connect("/")
+	pid, err := strconv.Atoi(ws.Recv())
+	if err != nil {
+		t.Fatalf("expected a pid: %v", err)
+	}
+
+	s.cmd.Process.Signal(syscall.SIGTERM)
+	if !s.WaitExit(10 * time.Second) {
+		t.Fatal("websocketd did not exit after the drain deadline")
+	}
+	if code := s.ExitCode(); code != 5 {
+		t.Errorf("exit code = %d, want 5 when the deadline passes", code)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processAlive(pid) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if processAlive(pid) {
+		t.Errorf("child %d survived the drain deadline", pid)
+	}
+}
+
+func TestShutdown_RemovesUnixSocket(t *testing.T) {
+	skipSignalsOnWindows(t)
+	t.Parallel()
+	sockPath := shortSocketPath(t)
+	s := startServerRawArgs(t, []string{"--unixsocket=" + sockPath, testcmdBin, "echo"})
+	waitForSocket(t, sockPath, 10*time.Second)
+
+	s.cmd.Process.Signal(syscall.SIGTERM)
+	if !s.WaitExit(10 * time.Second) {
+		t.Fatal("websocketd did not exit after SIGTERM")
+	}
+	if _, err := os.Stat(sockPath); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed on shutdown, stat err = %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tests for the SSL_* variables: with --ssl, WebSocket processes and CGI
+// scripts learn the TLS protocol and, with --sslca, the client certificate.
+
+func TestSSLEnv_ClientCertificate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("CGI scripts here are /bin/sh")
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	caCertFile, caKeyFile := generateCA(t, dir)
+	serverCertFile, serverKeyFile := generateSignedCert(t, dir, "server", caCertFile, caKeyFile)
+	clientCertFile, clientKeyFile := generateSignedCert(t, dir, "alice", caCertFile, caKeyFile)
+	cgiDir := filepath.Join(dir, "cgi")
+	os.Mkdir(cgiDir, 0755)
+	script := "#!/bin/sh\nprintf 'Content-Type: text/plain\\r\\n\\r\\n'\necho \"$SSL_CLIENT_S_DN_CN $SSL_CLIENT_VERIFY $SSL_PROTOCOL\"\n"
+	if err := os.WriteFile(filepath.Join(cgiDir, "whoami"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	port := freePort(t)
+	cmd := exec.Command(websocketdBin,
+		"--port="+strconv.Itoa(port),
+		"--address=127.0.0.1",
+		"--loglevel=error",
+		"--ssl",
+		"--sslcert="+serverCertFile,
+		"--sslkey="+serverKeyFile,
+		"--sslca="+caCertFile,
+		"--cgidir="+cgiDir,
+		testcmdBin, "env",
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	waitForPort(t, port, 10*time.Second)
+
+	caCert, _ := os.ReadFile(caCertFile)
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM(caCert)
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load client cert: %v", err)
+	}
+	tlsConfig := &tls.Config{RootCAs: caCertPool, Certificates: []tls.Certificate{clientCert}, ServerName: "localhost"}
+
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second, TLSClientConfig: tlsConfig}
+	conn, _, err := dialer.Dial("wss://127.0.0.1:"+strconv.Itoa(port)+"/", nil)
+	if err != nil {
+		t.Fatalf("mutual TLS connect failed: %v", err)
+	}
+	defer conn.Close()
+	var lines []string
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		lines = append(lines, string(msg))
+	}
+	output := strings.Join(lines, "\n")
+
+	want := map[string]string{
+		"SSL_CLIENT_S_DN_CN": "alice",
+		"SSL_CLIENT_S_DN":    "CN=alice",
+		"SSL_CLIENT_I_DN":    "CN=websocketd test CA",
+		"SSL_CLIENT_SERIAL":  "2",
+		"SSL_CLIENT_VERIFY":  "SUCCESS",
+		"SSL_SERVER_NAME":    "localhost",
+		"SSL_PROTOCOL":       "TLSv1.3",
+	}
+	for k, v := range want {
+		if got, ok := findEnvValue(output, k); !ok || got != v {
+			t.Errorf("%s = %q (set %v), want %q", k, got, ok, v)
+		}
+	}
+	for _, k := range []string{"SSL_CLIENT_FINGERPRINT", "SSL_CIPHER"} {
+		if got, _ := findEnvValue(output, k); got == "" {
+			t.Errorf("%s not set", k)
+		}
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 5 * time.Second}
+	resp, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/whoami")
+	if err != nil {
+		t.Fatalf("CGI request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := strings.TrimSpace(string(body)); got != "alice SUCCESS TLSv1.3" {
+		t.Errorf("CGI script saw %q, want \"alice SUCCESS TLSv1.3\"", got)
+	}
+}
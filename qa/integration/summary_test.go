@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests for the DISCONNECT summary: one access line per session saying how
+// long it lasted, what it relayed and why it ended.
+
+// disconnectLine waits for the DISCONNECT line in --logformat=json output and
+// returns its fields.
+func disconnectLine(t *testing.T, s *Server) map[string]string {
+	t.Helper()
+	s.WaitForStdout(`"message":"DISCONNECT"`, 5*time.Second)
+	for _, line := range strings.Split(strings.TrimSpace(s.Stdout()), "\n") {
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line is not a JSON object: %q: %v", line, err)
+		}
+		if fields["message"] == "DISCONNECT" {
+			return fields
+		}
+	}
+	return nil
+}
+
+func TestSummary_ClientClose(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--logformat=json"}, "echo")
+	ws := s.Connect("/")
+	ws.Send("hello")
+	ws.ExpectMessage("hello")
+	ws.Close()
+
+	got := disconnectLine(t, s)
+	want := map[string]string{
+		"msgs_in": "1", "bytes_in": "6", "msgs_out": "1", "bytes_out": "5",
+		"closed_by": "client", "close_code": "1000",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q (line %v)", k, got[k], v, got)
+		}
+	}
+	if _, err := time.ParseDuration(got["duration"]); err != nil {
+		t.Errorf("duration %q: %v", got["duration"], err)
+	}
+}
+
+func TestSummary_ProcessExit(t *testing.T) {
+	t.Parallel()
+	s := startServerOpts(t, []string{"--logformat=json"}, "exit", "7", "bye")
+	ws := s.Connect("/")
+	defer ws.Close()
+
+	got := disconnectLine(t, s)
+	if got["closed_by"] != "process" || got["exit"] != "7" {
+		t.Errorf("want closed_by=process exit=7, got %v", got)
+	}
+}
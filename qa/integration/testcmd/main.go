@@ -5,12 +5,15 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -103,6 +106,15 @@ func main() {
 		fmt.Println("started")
 		select {}
 
+	case "ignore-signals":
+		// Survives everything but SIGKILL: stdin EOF, SIGINT and SIGTERM.
+		// (A bare select{} would trip the runtime's deadlock detector.)
+		signal.Ignore(os.Interrupt, syscall.SIGTERM)
+		fmt.Println(os.Getpid())
+		for {
+			time.Sleep(time.Hour)
+		}
+
 	case "crlf":
 		fmt.Print("line1\r\n")
 		fmt.Print("line2\r\n")
@@ -133,6 +145,44 @@ func main() {
 			fmt.Println(scanner.Text())
 		}
 
+	case "mux-echo":
+		// Speaks the --multiplex protocol: greets each session with the
+		// pid and its QUERY_STRING, echoes its messages, answers "count"
+		// with the number of sessions connected, and ends a session that
+		// sends "bye".
+		type frame struct {
+			Event  string            `json:"event"`
+			ID     string            `json:"id"`
+			Env    map[string]string `json:"env,omitempty"`
+			Data   string            `json:"data,omitempty"`
+			Code   int               `json:"code,omitempty"`
+			Reason string            `json:"reason,omitempty"`
+		}
+		out := json.NewEncoder(os.Stdout)
+		live := make(map[string]bool)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			var f frame
+			if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			switch {
+			case f.Event == "connect":
+				live[f.ID] = true
+				out.Encode(frame{Event: "message", ID: f.ID, Data: fmt.Sprintf("pid %d %s", os.Getpid(), f.Env["QUERY_STRING"])})
+			case f.Event == "disconnect":
+				delete(live, f.ID)
+			case f.Event == "message" && f.Data == "count":
+				out.Encode(frame{Event: "message", ID: f.ID, Data: strconv.Itoa(len(live))})
+			case f.Event == "message" && f.Data == "bye":
+				delete(live, f.ID)
+				out.Encode(frame{Event: "disconnect", ID: f.ID, Code: 4000, Reason: "bye"})
+			case f.Event == "message":
+				out.Encode(frame{Event: "message", ID: f.ID, Data: f.Data})
+			}
+		}
+
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
 		os.Exit(1)
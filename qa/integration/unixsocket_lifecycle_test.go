@@ -13,9 +13,9 @@ import (
 // already refuses a port that is in use.
 //
 // The stale-socket recovery path (a leftover file from an unclean exit) is
-// covered by TestIssue435_StaleSocketCleanup. websocketd installs no signal
-// handlers, so a socket file outliving its server is expected; startup
-// recovery is what clears it.
+// covered by TestIssue435_StaleSocketCleanup. A SIGINT/SIGTERM shutdown
+// removes the socket file (see shutdown_test.go), but SIGKILL, a crash or
+// power loss still leave it behind, so startup recovery remains essential.
 
 // TestUnixSocket_RefusesLiveSocket verifies that starting a second server on a
 // socket path that a healthy server is already listening on fails loudly,
@@ -38,7 +38,7 @@ func TestUnixSocket_RefusesLiveSocket(t *testing.T) {
 	if code := second.ExitCode(); code == 0 {
 		t.Errorf("second server exited 0, want non-zero")
 	}
-	// log.Fatal routes through logfunc, which writes to stdout.
+	// log.Fatal routes through the log function, which writes to stdout.
 	if out := second.Stdout(); !strings.Contains(out, "already in use") {
 		t.Errorf("expected an 'already in use' error on stdout, got:\n%s", out)
 	}
@@ -0,0 +1,133 @@
+// Copyright 2026 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig returns the base TLS settings shared by all HTTPS servers. It pins
+// a minimum protocol version explicitly rather than relying on the Go default,
+// which has drifted across releases.
+func tlsConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
+
+// tlsFiles holds the certificate, key and (for mutual TLS) client CA pool
+// read from --sslcert, --sslkey and --sslca. Every handshake uses the most
+// recently loaded copies, so a SIGHUP can rotate them without a restart and
+// without disturbing connections that are already established. With --acme
+// the certificate comes from the manager instead, which renews it itself.
+type tlsFiles struct {
+	certFile, keyFile, caFile string
+	acme                      *autocert.Manager // set instead of certFile and keyFile
+
+	cert   atomic.Pointer[tls.Certificate]
+	caPool atomic.Pointer[x509.CertPool] // nil unless caFile is set
+}
+
+// loadTLSFiles reads the given files for the first time. caFile may be empty.
+func loadTLSFiles(certFile, keyFile, caFile string) (*tlsFiles, error) {
+	tf := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := tf.reload(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// loadACMEFiles is loadTLSFiles for --acme, where only caFile (which may be
+// empty) is a file.
+func loadACMEFiles(m *autocert.Manager, caFile string) (*tlsFiles, error) {
+	tf := &tlsFiles{acme: m, caFile: caFile}
+	if err := tf.reload(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// reload re-reads the files. Nothing is replaced unless all of them load, so
+// a renewal caught half-written leaves the previous certificate in service.
+func (tf *tlsFiles) reload() error {
+	var cert tls.Certificate
+	var err error
+	if tf.acme == nil {
+		if cert, err = tls.LoadX509KeyPair(tf.certFile, tf.keyFile); err != nil {
+			return err
+		}
+	}
+	var pool *x509.CertPool
+	if tf.caFile != "" {
+		if pool, err = loadCAPool(tf.caFile); err != nil {
+			return err
+		}
+	}
+	if tf.acme == nil {
+		tf.cert.Store(&cert)
+	}
+	if pool != nil {
+		tf.caPool.Store(pool)
+	}
+	return nil
+}
+
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	caCert, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file %s: %w", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("failed to parse CA certificates from %s", caFile)
+	}
+	return pool, nil
+}
+
+// serverConfig returns the TLS settings for an HTTPS server using these
+// files. With a CA file, client certificates are verified against the
+// current pool, and required unless optionalClientCert
+// (--sslclientauth=optional) lets clients without one through.
+func (tf *tlsFiles) serverConfig(optionalClientCert bool) *tls.Config {
+	cfg := tlsConfig()
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return tf.cert.Load(), nil
+	}
+	if tf.acme != nil {
+		// The manager answers TLS-ALPN-01 challenges itself, given the
+		// protocol is offered.
+		cfg.GetCertificate = tf.acme.GetCertificate
+		cfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+	if tf.caFile == "" {
+		return cfg
+	}
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if optionalClientCert {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	// ClientCAs has no callback of its own, so each handshake gets a copy of
+	// the config carrying the current pool. That copy bypasses the ALPN list
+	// http.Server adds to its own, hence the explicit NextProtos.
+	if tf.acme == nil {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		perConn := cfg.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.ClientCAs = tf.caPool.Load()
+		if tf.acme != nil && offersACMEALPN(hello) {
+			perConn.ClientAuth = tls.NoClientCert // the CA has no certificate to give
+		}
+		return perConn, nil
+	}
+	return cfg
+}
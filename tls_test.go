@@ -8,6 +8,9 @@ package main
 import (
 	"crypto/tls"
 	"testing"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // TestTLSConfigMinVersion pins the minimum TLS version so a future refactor
@@ -17,3 +20,33 @@ func TestTLSConfigMinVersion(t *testing.T) {
 		t.Errorf("tlsConfig().MinVersion = 0x%04x, want TLS 1.2 (0x%04x)", got, tls.VersionTLS12)
 	}
 }
+
+// TestACMEChallengeSkipsClientCert checks that with --acme and --sslca the
+// CA's TLS-ALPN-01 connections are not asked for a client certificate,
+// while everyone else still is.
+func TestACMEChallengeSkipsClientCert(t *testing.T) {
+	tf := &tlsFiles{acme: &autocert.Manager{}, caFile: "ca.pem"}
+	cfg := tf.serverConfig(false)
+
+	challenge, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{acme.ALPNProto}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.ClientAuth != tls.NoClientCert {
+		t.Errorf("ACME challenge ClientAuth = %v, want NoClientCert", challenge.ClientAuth)
+	}
+	browser, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{SupportedProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if browser.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("browser ClientAuth = %v, want RequireAndVerifyClientCert", browser.ClientAuth)
+	}
+	found := false
+	for _, p := range browser.NextProtos {
+		found = found || p == acme.ALPNProto
+	}
+	if !found {
+		t.Errorf("NextProtos = %v, want %s offered", browser.NextProtos, acme.ALPNProto)
+	}
+}